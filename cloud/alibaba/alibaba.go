@@ -0,0 +1,342 @@
+// Package alibaba rotates the AccessKeys of Alibaba Cloud RAM users, kept in
+// the ALIBABA_CLOUD_ACCESS_KEY_* environment variables or the profiles of the
+// aliyun CLI's config.json.
+package alibaba
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Name is the name of the Alibaba Cloud provider
+const Name = "alibaba"
+
+// SourceConfigFile is the Source of profiles in the aliyun CLI's config.json
+const SourceConfigFile = "ConfigFile"
+
+// KindAccessKey is the kind of profiles with their own AccessKey, the aliyun
+// CLI's AK mode. Other modes are named after the mode, like sts-token.
+const KindAccessKey = "access-key"
+
+// configFileMode keeps the config file private to the user
+const configFileMode = 0600
+
+// Provider works with RAM user AccessKeys
+type Provider struct{}
+
+func init() {
+	cloud.Register(Provider{})
+}
+
+// ramProfile is what a profile needs to manage its AccessKeys
+type ramProfile struct {
+	// name is the name of the profile in config.json
+	name string
+	// user is the RAM user of the AccessKey, found by Lookup. It is empty for
+	// the AccessKeys of the account itself.
+	user string
+}
+
+// Name is the name of the provider
+func (Provider) Name() string {
+	return Name
+}
+
+// Profiles finds the AccessKey in the environment variables and the profiles
+// of ~/.aliyun/config.json. The current one is the environment's, or else
+// ALIBABA_CLOUD_PROFILE, or else the config's current profile.
+func (Provider) Profiles() ([]cloud.Profile, error) {
+	var profiles []cloud.Profile
+	id, secret := os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_ID"), os.Getenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	if id != "" && secret != "" {
+		profiles = append(profiles, cloud.Profile{
+			Cloud:     Name,
+			Kind:      KindAccessKey,
+			Cred:      cloud.Credential{ID: id, Secret: secret},
+			Source:    cloud.SourceEnviron,
+			IsCurrent: true,
+			Rotatable: true,
+			Data:      ramProfile{},
+		})
+	}
+
+	path, err := ConfigFilename()
+	if err != nil {
+		return profiles, err
+	}
+	c, err := readConfig(path)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	}
+	if err != nil {
+		return profiles, err
+	}
+	current := ""
+	if len(profiles) == 0 {
+		current = os.Getenv("ALIBABA_CLOUD_PROFILE")
+		if current == "" {
+			current = c.value("current")
+		}
+	}
+	for _, e := range c.profiles() {
+		p := cloud.Profile{
+			Cloud:     Name,
+			Name:      e.value("name"),
+			Kind:      kindOf(e.value("mode")),
+			Region:    e.value("region_id"),
+			Cred:      cloud.Credential{ID: e.value("access_key_id"), Secret: e.value("access_key_secret")},
+			Source:    SourceConfigFile,
+			File:      path,
+			IsCurrent: e.value("name") == current,
+			Data:      ramProfile{name: e.value("name")},
+		}
+		p.Rotatable = p.Kind == KindAccessKey && p.Cred.ID != "" && p.Cred.Secret != ""
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// kindOf names the kind of an aliyun CLI mode: AK is access-key, and
+// StsToken is sts-token. Profiles without a mode are AK profiles.
+func kindOf(mode string) string {
+	if mode == "" || mode == "AK" {
+		return KindAccessKey
+	}
+	var b strings.Builder
+	for i, r := range mode {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ConfigFile overrides the location of the aliyun CLI's config.json
+var ConfigFile string
+
+// ConfigFilename gets the path of the aliyun CLI's config.json: the
+// ConfigFile override, then ~/.aliyun/config.json
+func ConfigFilename() (string, error) {
+	if ConfigFile != "" {
+		return homedir.Expand(ConfigFile)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".aliyun", "config.json"), nil
+}
+
+// configFile is the aliyun CLI's config.json. Unknown keys are kept when the
+// file is written.
+type configFile map[string]interface{}
+
+// entry is a profile of config.json
+type entry map[string]interface{}
+
+func (c configFile) value(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func (e entry) value(name string) string {
+	s, _ := e[name].(string)
+	return s
+}
+
+func (c configFile) profiles() []entry {
+	list, _ := c["profiles"].([]interface{})
+	entries := make([]entry, 0, len(list))
+	for _, item := range list {
+		if e, ok := item.(map[string]interface{}); ok {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// readConfig reads config.json, keeping numbers as they are written
+func readConfig(path string) (configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var c configFile
+	if err := d.Decode(&c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return c, nil
+}
+
+// writeAccessKey replaces the AccessKey of a profile in config.json
+func writeAccessKey(path, name string, cred cloud.Credential) error {
+	c, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, e := range c.profiles() {
+		if e.value("name") == name {
+			e["access_key_id"], e["access_key_secret"] = cred.ID, cred.Secret
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s: profile %s is gone", path, name)
+	}
+	// The aliyun CLI indents with tabs too
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data, configFileMode)
+}
+
+// ramProfileOf gets the RAM profile of a profile
+func ramProfileOf(p cloud.Profile) (ramProfile, error) {
+	rp, ok := p.Data.(ramProfile)
+	if !ok {
+		return rp, errors.New("Not an Alibaba Cloud profile")
+	}
+	return rp, nil
+}
+
+// keyOf is the AccessKey of a credential
+func keyOf(cred cloud.Credential) accessKey {
+	return accessKey{id: cred.ID, secret: cred.Secret}
+}
+
+// Lookup asks STS for the account and RAM user of the AccessKey
+func (Provider) Lookup(p *cloud.Profile) error {
+	rp, err := ramProfileOf(*p)
+	if err != nil {
+		return err
+	}
+	id, err := getCallerIdentity(keyOf(p.Cred))
+	if err != nil {
+		return err
+	}
+	switch id.IdentityType {
+	case "Account":
+		rp.user = ""
+		p.UserName = "root"
+	case "RAMUser":
+		// The ARN is acs:ram::<account>:user/<name>
+		rp.user = id.Arn[strings.LastIndex(id.Arn, "/")+1:]
+		p.UserName = rp.user
+	default:
+		return fmt.Errorf("The AccessKey belongs to a %s, not a RAM user", id.IdentityType)
+	}
+	p.Account = id.AccountID
+	p.Data = rp
+	return nil
+}
+
+// Keys lists the AccessKeys of the RAM user
+func (Provider) Keys(p cloud.Profile) ([]cloud.Key, error) {
+	rp, err := ramProfileOf(p)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		AccessKeys struct {
+			AccessKey []ramAccessKey `json:"AccessKey"`
+		} `json:"AccessKeys"`
+	}
+	if err := ram(keyOf(p.Cred), "ListAccessKeys", rp.user, nil, &out); err != nil {
+		return nil, err
+	}
+	keys := make([]cloud.Key, 0, len(out.AccessKeys.AccessKey))
+	for _, k := range out.AccessKeys.AccessKey {
+		created, _ := time.Parse(time.RFC3339, k.CreateDate)
+		keys = append(keys, cloud.Key{ID: k.AccessKeyID, Active: k.Status == "Active", Created: created})
+	}
+	return keys, nil
+}
+
+// CreateKey creates a new AccessKey for the RAM user. Like IAM, RAM allows
+// at most two AccessKeys per user.
+func (Provider) CreateKey(p cloud.Profile) (cloud.Credential, error) {
+	rp, err := ramProfileOf(p)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	var out struct {
+		AccessKey ramAccessKey `json:"AccessKey"`
+	}
+	if err := ram(keyOf(p.Cred), "CreateAccessKey", rp.user, nil, &out); err != nil {
+		return cloud.Credential{}, err
+	}
+	return cloud.Credential{ID: out.AccessKey.AccessKeyID, Secret: out.AccessKey.AccessKeySecret}, nil
+}
+
+// DeactivateKey makes an AccessKey of the RAM user inactive
+func (Provider) DeactivateKey(p cloud.Profile, id string) error {
+	rp, err := ramProfileOf(p)
+	if err != nil {
+		return err
+	}
+	return ram(keyOf(p.Cred), "UpdateAccessKey", rp.user, map[string]string{"UserAccessKeyId": id, "Status": "Inactive"}, nil)
+}
+
+// DeleteKey deletes an AccessKey of the RAM user
+func (Provider) DeleteKey(p cloud.Profile, id string) error {
+	rp, err := ramProfileOf(p)
+	if err != nil {
+		return err
+	}
+	return ram(keyOf(p.Cred), "DeleteAccessKey", rp.user, map[string]string{"UserAccessKeyId": id}, nil)
+}
+
+// Verify checks whether STS accepts the AccessKey
+func (Provider) Verify(p cloud.Profile, cred cloud.Credential) error {
+	_, err := getCallerIdentity(keyOf(cred))
+	return err
+}
+
+// WriteCredential saves the AccessKey in config.json, or in the
+// ALIBABA_CLOUD_ACCESS_KEY_* environment variables of this process
+func (Provider) WriteCredential(p *cloud.Profile, cred cloud.Credential) error {
+	rp, err := ramProfileOf(*p)
+	if err != nil {
+		return err
+	}
+	switch p.Source {
+	case cloud.SourceEnviron:
+		for _, v := range (Provider{}).EnvVars(cred) {
+			os.Setenv(v.Name, v.Value)
+		}
+	case SourceConfigFile:
+		if err := writeAccessKey(p.File, rp.name, cred); err != nil {
+			return err
+		}
+	}
+	p.Cred = cred
+	return nil
+}
+
+// EnvVars gives the variables holding the AccessKey
+func (Provider) EnvVars(cred cloud.Credential) []cloud.EnvVar {
+	return []cloud.EnvVar{
+		{Name: "ALIBABA_CLOUD_ACCESS_KEY_ID", Value: cred.ID},
+		{Name: "ALIBABA_CLOUD_ACCESS_KEY_SECRET", Value: cred.Secret},
+	}
+}
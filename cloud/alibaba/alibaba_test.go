@@ -0,0 +1,128 @@
+package alibaba
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+)
+
+const (
+	oldKeyID  = "LTAI4OldKeyEXAMPLE"
+	oldSecret = "oldSecretEXAMPLE"
+)
+
+// configFileWith writes config.json to a temporary directory and points
+// ConfigFile at it
+func configFileWith(t *testing.T, config string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ConfigFile = filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(ConfigFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_PROFILE"} {
+		os.Unsetenv(env)
+	}
+	return ConfigFile, func() {
+		ConfigFile = ""
+		for _, env := range []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_PROFILE"} {
+			os.Unsetenv(env)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+const configJSON = `{
+	"current": "default",
+	"profiles": [
+		{
+			"name": "default",
+			"mode": "AK",
+			"access_key_id": "` + oldKeyID + `",
+			"access_key_secret": "` + oldSecret + `",
+			"region_id": "cn-hangzhou",
+			"retry_count": 0
+		},
+		{
+			"name": "sts",
+			"mode": "StsToken",
+			"access_key_id": "STS.temporary",
+			"access_key_secret": "temporary",
+			"sts_token": "token",
+			"region_id": "cn-shanghai"
+		}
+	],
+	"meta_path": ""
+}`
+
+func TestProfiles(t *testing.T) {
+	file, cleanup := configFileWith(t, configJSON)
+	defer cleanup()
+
+	t.Run("config file", func(t *testing.T) {
+		got, err := Provider{}.Profiles()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		want := []cloud.Profile{
+			{Cloud: Name, Name: "default", Kind: KindAccessKey, Region: "cn-hangzhou", Cred: cloud.Credential{ID: oldKeyID, Secret: oldSecret}, Source: SourceConfigFile, File: file, IsCurrent: true, Rotatable: true, Data: ramProfile{name: "default"}},
+			{Cloud: Name, Name: "sts", Kind: "sts-token", Region: "cn-shanghai", Cred: cloud.Credential{ID: "STS.temporary", Secret: "temporary"}, Source: SourceConfigFile, File: file, Data: ramProfile{name: "sts"}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+	t.Run("environment variables", func(t *testing.T) {
+		os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "LTAI4EnvEXAMPLE")
+		os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "envSecret")
+
+		got, err := Provider{}.Profiles()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 3 || got[0].Source != cloud.SourceEnviron || !got[0].IsCurrent || got[1].IsCurrent {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
+
+func TestSignedQuery(t *testing.T) {
+	// The example of the signature documentation of Alibaba Cloud
+	query := map[string]string{
+		"AccessKeyId":      "testid",
+		"Action":           "DescribeRegions",
+		"Format":           "XML",
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf",
+		"SignatureVersion": "1.0",
+		"Timestamp":        "2016-02-23T12:46:24Z",
+		"Version":          "2014-05-26",
+	}
+
+	got := signedQuery(accessKey{id: "testid", secret: "testsecret"}, query)
+
+	want := "AccessKeyId=testid&Action=DescribeRegions&Format=XML&SignatureMethod=HMAC-SHA1" +
+		"&SignatureNonce=3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf&SignatureVersion=1.0" +
+		"&Timestamp=2016-02-23T12%3A46%3A24Z&Version=2014-05-26&Signature=OLeaidS1JvxuMvnyHOwuJ%2BuX5qY%3D"
+	if got != want {
+		t.Errorf("got %q but want %q", got, want)
+	}
+}
+
+func TestKindOf(t *testing.T) {
+	tests := map[string]string{"": KindAccessKey, "AK": KindAccessKey, "StsToken": "sts-token", "RamRoleArn": "ram-role-arn", "EcsRamRole": "ecs-ram-role"}
+	for mode, want := range tests {
+		if got := kindOf(mode); got != want {
+			t.Errorf("got %q for %q but want %q", got, mode, want)
+		}
+	}
+}
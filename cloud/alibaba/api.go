@@ -0,0 +1,124 @@
+package alibaba
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/internal/httpapi"
+)
+
+// Endpoints of RAM and STS. Tests point them at a local fake.
+var (
+	RAMURL = "https://ram.aliyuncs.com"
+	STSURL = "https://sts.aliyuncs.com"
+)
+
+// API versions of RAM and STS
+const (
+	ramVersion = "2015-05-01"
+	stsVersion = "2015-04-01"
+)
+
+// percentEncode encodes like RFC 3986, as the RPC signature needs
+func percentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
+
+// call calls an action of an RPC API with an AccessKey and decodes the
+// response into out, unless out is nil. Requests are signed with
+// signature version 1.0 (HMAC-SHA1).
+func call(cred accessKey, endpoint, version, action string, params map[string]string, out interface{}) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	query := map[string]string{
+		"Action":           action,
+		"Version":          version,
+		"Format":           "JSON",
+		"AccessKeyId":      cred.id,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureVersion": "1.0",
+		"SignatureNonce":   hex.EncodeToString(nonce),
+		"Timestamp":        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+	}
+	for k, v := range params {
+		query[k] = v
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimRight(endpoint, "/")+"/?"+signedQuery(cred, query), nil)
+	if err != nil {
+		return err
+	}
+	return httpapi.Do(req, out)
+}
+
+// signedQuery encodes the query of a request in canonical order and adds its
+// signature
+func signedQuery(cred accessKey, query map[string]string) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = percentEncode(name) + "=" + percentEncode(query[name])
+	}
+	canonical := strings.Join(pairs, "&")
+	mac := hmac.New(sha1.New, []byte(cred.secret+"&"))
+	mac.Write([]byte("GET&" + percentEncode("/") + "&" + percentEncode(canonical)))
+	return canonical + "&Signature=" + percentEncode(base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// accessKey is an AccessKey pair to sign requests with
+type accessKey struct {
+	id     string
+	secret string
+}
+
+// callerIdentity is who STS says signed a request
+type callerIdentity struct {
+	AccountID    string `json:"AccountId"`
+	UserID       string `json:"UserId"`
+	Arn          string `json:"Arn"`
+	IdentityType string `json:"IdentityType"`
+}
+
+// getCallerIdentity asks STS who the AccessKey belongs to
+func getCallerIdentity(cred accessKey) (callerIdentity, error) {
+	var id callerIdentity
+	err := call(cred, STSURL, stsVersion, "GetCallerIdentity", nil, &id)
+	return id, err
+}
+
+// ramAccessKey is an AccessKey as RAM lists it
+type ramAccessKey struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret"`
+	Status          string `json:"Status"`
+	CreateDate      string `json:"CreateDate"`
+}
+
+// ram calls a RAM action on the AccessKeys of a RAM user, or of the caller if
+// userName is empty
+func ram(cred accessKey, action, userName string, params map[string]string, out interface{}) error {
+	if params == nil {
+		params = map[string]string{}
+	}
+	if userName != "" {
+		params["UserName"] = userName
+	}
+	return call(cred, RAMURL, ramVersion, action, params, out)
+}
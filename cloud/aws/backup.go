@@ -0,0 +1,19 @@
+package aws
+
+import "github.com/buzzsurfr/cloudkey/internal/backup"
+
+// BackupDir is where a copy of the credentials or config file is saved before
+// cloudkey changes it. When empty, no backups are saved.
+var BackupDir string
+
+// BackupKeep is how many backups of each file are kept. Zero keeps every backup.
+var BackupKeep = 10
+
+// backupFile saves a copy of file in BackupDir before it is changed
+func backupFile(file string) error {
+	if BackupDir == "" {
+		return nil
+	}
+	_, err := backup.Save(BackupDir, file, BackupKeep)
+	return err
+}
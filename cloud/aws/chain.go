@@ -0,0 +1,146 @@
+package aws
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// verifyRoleSessionName names the sessions used to check dependent roles
+const verifyRoleSessionName = "cloudkey-verify"
+
+// Get gets a profile by name
+func (ps *Profiles) Get(name string) (Profile, bool) {
+	for _, p := range ps.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// SourceChain follows source_profile from the named profile to the profile
+// that owns the access key, the same way the AWS CLI does. The chain starts
+// with the named profile and ends with the source profile; a profile that is
+// not a role profile is its own chain. The chain is returned along with a
+// NotRotatableError if it ends at a profile without an access key, such as a
+// role profile that uses credential_source.
+func (ps *Profiles) SourceChain(name string) ([]Profile, error) {
+	p, ok := ps.Get(name)
+	if !ok {
+		return nil, errors.New("No credential with profile name " + name + " found")
+	}
+
+	chain := []Profile{p}
+	seen := map[string]bool{name: true}
+	for p.Kind == KindAssumeRole && p.Config.SourceProfile != "" {
+		if p.Config.SourceProfile == p.Name && p.Cred.AccessKeyID != "" {
+			// A role profile can use its own access key as the source
+			source := p
+			source.Kind = KindStaticKey
+			chain = append(chain, source)
+			p = source
+			break
+		}
+		if seen[p.Config.SourceProfile] {
+			return nil, &SourceProfileError{Profile: p.Name, SourceProfile: p.Config.SourceProfile, Err: ErrSourceProfileLoop}
+		}
+		source, ok := ps.Get(p.Config.SourceProfile)
+		if !ok {
+			return nil, &SourceProfileError{Profile: p.Name, SourceProfile: p.Config.SourceProfile, Err: ErrSourceProfileNotFound}
+		}
+		seen[source.Name] = true
+		chain = append(chain, source)
+		p = source
+	}
+
+	if !p.Rotatable() {
+		return chain, &NotRotatableError{Profile: p.Name, Kind: p.Kind}
+	}
+	return chain, nil
+}
+
+// Dependents gets the role profiles whose source_profile chain ends at the
+// named profile
+func (ps *Profiles) Dependents(name string) []Profile {
+	var dependents []Profile
+	for _, p := range ps.Profiles {
+		if p.Kind != KindAssumeRole {
+			continue
+		}
+		chain, err := ps.SourceChain(p.Name)
+		if err != nil || chain[len(chain)-1].Name != name {
+			continue
+		}
+		dependents = append(dependents, p)
+	}
+	return dependents
+}
+
+// RoleCheck is the result of checking that a role profile still works after
+// its source profile was rotated
+type RoleCheck struct {
+	Profile string
+	RoleARN string
+	// Skipped explains why the role was not checked
+	Skipped string
+	Err     error
+}
+
+// VerifyRoles checks that each role profile can assume its role, and every
+// role before it in its chain, starting from source's current credential.
+// Roles that need an MFA token are skipped.
+func (ps *Profiles) VerifyRoles(source *Profile, roles []Profile) []RoleCheck {
+	var checks []RoleCheck
+	for _, role := range roles {
+		check := RoleCheck{Profile: role.Name, RoleARN: role.Config.RoleARN}
+		chain, err := ps.SourceChain(role.Name)
+		if err != nil {
+			check.Err = err
+			checks = append(checks, check)
+			continue
+		}
+
+		// Assume each role from the source outwards
+		cred := source.Cred
+		for i := len(chain) - 2; i >= 0; i-- {
+			hop := chain[i]
+			if hop.Config.MFASerial != "" {
+				check.Skipped = "profile " + hop.Name + " requires an MFA token"
+				break
+			}
+			if cred, err = assumeRole(source, cred, hop.Config); err != nil {
+				check.Err = &RotateError{Step: StepAssumeRole, Err: err}
+				break
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// assumeRole assumes the role of a profile with the credential
+func assumeRole(p *Profile, cred Credential, config Config) (Credential, error) {
+	_, svc, err := clientsForCredential(p, cred)
+	if err != nil {
+		return Credential{}, err
+	}
+	input := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(config.RoleARN),
+		RoleSessionName: aws.String(verifyRoleSessionName),
+		DurationSeconds: aws.Int64(900), // the shortest session allowed
+	}
+	if config.ExternalID != "" {
+		input.ExternalId = aws.String(config.ExternalID)
+	}
+	result, err := svc.AssumeRole(input)
+	if err != nil {
+		return Credential{}, err
+	}
+	return Credential{
+		AccessKeyID:     aws.StringValue(result.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(result.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(result.Credentials.SessionToken),
+	}, nil
+}
@@ -0,0 +1,133 @@
+package aws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// roleSTS assumes roles, failing for the role ARNs in Denied
+type roleSTS struct {
+	stsiface.STSAPI
+	Cred   Credential
+	Denied map[string]bool
+	Calls  *[]string
+}
+
+func (m roleSTS) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	arn := aws.StringValue(input.RoleArn)
+	if m.Calls != nil {
+		*m.Calls = append(*m.Calls, m.Cred.AccessKeyID+" "+arn)
+	}
+	if m.Denied[arn] {
+		return nil, errors.New("AccessDenied")
+	}
+	return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("ASIA" + arn[len(arn)-4:]),
+		SecretAccessKey: aws.String(secretAccessKey),
+		SessionToken:    aws.String("token"),
+	}}, nil
+}
+
+func chainProfiles() Profiles {
+	static := Credential{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey}
+	return Profiles{Profiles: []Profile{
+		{Name: "default", Kind: KindStaticKey, Cred: static},
+		{Name: "admin", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/admin", SourceProfile: "default"}},
+		{Name: "prod", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::210987654321:role/prod", SourceProfile: "admin"}},
+		{Name: "audit", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/audit", SourceProfile: "default", MFASerial: "arn:aws:iam::123456789012:mfa/defaultUser"}},
+		{Name: "ec2", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/ec2", CredentialSource: "Ec2InstanceMetadata"}},
+		{Name: "self", Kind: KindAssumeRole, Cred: static, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/self", SourceProfile: "self"}},
+		{Name: "loop", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/loop", SourceProfile: "loop2"}},
+		{Name: "loop2", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/loop2", SourceProfile: "loop"}},
+		{Name: "orphan", Kind: KindAssumeRole, Config: Config{RoleARN: "arn:aws:iam::123456789012:role/orphan", SourceProfile: "missing"}},
+	}}
+}
+
+func chainNames(chain []Profile) []string {
+	names := []string{}
+	for _, p := range chain {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestSourceChain(t *testing.T) {
+	ps := chainProfiles()
+
+	t.Run("static profile is its own chain", func(t *testing.T) {
+		got, err := ps.SourceChain("default")
+
+		assertNoError(t, err)
+		assertCalls(t, chainNames(got), []string{"default"})
+	})
+	t.Run("follow source_profile to the static profile", func(t *testing.T) {
+		got, err := ps.SourceChain("prod")
+
+		assertNoError(t, err)
+		assertCalls(t, chainNames(got), []string{"prod", "admin", "default"})
+	})
+	t.Run("role profile using its own access key", func(t *testing.T) {
+		got, err := ps.SourceChain("self")
+
+		assertNoError(t, err)
+		assertCalls(t, chainNames(got), []string{"self", "self"})
+		if !got[1].Rotatable() {
+			t.Errorf("source of self is not rotatable")
+		}
+	})
+	t.Run("fail on credential_source", func(t *testing.T) {
+		_, err := ps.SourceChain("ec2")
+
+		assertError(t, err, &NotRotatableError{Profile: "ec2", Kind: KindAssumeRole})
+	})
+	t.Run("fail on loop", func(t *testing.T) {
+		_, err := ps.SourceChain("loop")
+
+		assertError(t, err, &SourceProfileError{Profile: "loop2", SourceProfile: "loop", Err: ErrSourceProfileLoop})
+	})
+	t.Run("fail on missing source_profile", func(t *testing.T) {
+		_, err := ps.SourceChain("orphan")
+
+		assertError(t, err, &SourceProfileError{Profile: "orphan", SourceProfile: "missing", Err: ErrSourceProfileNotFound})
+	})
+}
+
+func TestDependents(t *testing.T) {
+	ps := chainProfiles()
+
+	got := ps.Dependents("default")
+
+	assertCalls(t, chainNames(got), []string{"admin", "prod", "audit"})
+}
+
+func TestVerifyRoles(t *testing.T) {
+	ps := chainProfiles()
+	newCred := Credential{AccessKeyID: newAccessKeyID, SecretAccessKey: newSecretAccessKey}
+	source := Profile{Name: "default", Kind: KindStaticKey, Cred: newCred}
+	var calls []string
+	denied := map[string]bool{"arn:aws:iam::210987654321:role/prod": true}
+	clientsForCredential = func(p *Profile, cred Credential) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+		return mockedIAM{}, roleSTS{Cred: cred, Denied: denied, Calls: &calls}, nil
+	}
+
+	got := ps.VerifyRoles(&source, ps.Dependents("default"))
+
+	if len(got) != 3 {
+		t.Fatalf("got %d checks, want 3", len(got))
+	}
+	if got[0].Err != nil || got[0].Skipped != "" {
+		t.Errorf("admin: got %+v, want success", got[0])
+	}
+	assertRotateError(t, got[1].Err, StepAssumeRole, errors.New("AccessDenied"))
+	assertString(t, got[2].Skipped, "profile audit requires an MFA token")
+	assertCalls(t, calls, []string{
+		newAccessKeyID + " arn:aws:iam::123456789012:role/admin",
+		newAccessKeyID + " arn:aws:iam::123456789012:role/admin",
+		"ASIAdmin arn:aws:iam::210987654321:role/prod",
+	})
+}
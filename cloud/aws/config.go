@@ -0,0 +1,187 @@
+package aws
+
+import (
+	"os"
+	"strings"
+
+	"github.com/buzzsurfr/cloudkey/internal/inifile"
+)
+
+// Kind is how a profile gets its credentials
+type Kind string
+
+// Kinds of profiles
+const (
+	KindStaticKey   Kind = "static"       // long-lived access key
+	KindAssumeRole  Kind = "assume-role"  // role_arn with source_profile or credential_source
+	KindSSO         Kind = "sso"          // IAM Identity Center (sso_session or sso_start_url)
+	KindProcess     Kind = "process"      // credential_process
+	KindWebIdentity Kind = "web-identity" // role_arn with web_identity_token_file
+)
+
+// Config is the configuration of a profile from the shared config file, with
+// any of the same settings from the credentials file taking precedence
+type Config struct {
+	Region               string
+	RoleARN              string
+	SourceProfile        string
+	CredentialSource     string
+	ExternalID           string
+	SSOSession           string
+	SSOStartURL          string
+	CredentialProcess    string
+	WebIdentityTokenFile string
+	MFASerial            string
+}
+
+// configFromSection reads the settings of a profile section
+func configFromSection(section inifile.Section) Config {
+	get := func(name string) string {
+		value, _ := section.Get(name)
+		return value
+	}
+	return Config{
+		Region:               get("region"),
+		RoleARN:              get("role_arn"),
+		SourceProfile:        get("source_profile"),
+		CredentialSource:     get("credential_source"),
+		ExternalID:           get("external_id"),
+		SSOSession:           get("sso_session"),
+		SSOStartURL:          get("sso_start_url"),
+		CredentialProcess:    get("credential_process"),
+		WebIdentityTokenFile: get("web_identity_token_file"),
+		MFASerial:            get("mfa_serial"),
+	}
+}
+
+// merge overrides the settings that are set in o
+func (c *Config) merge(o Config) {
+	set := func(dst *string, src string) {
+		if src != "" {
+			*dst = src
+		}
+	}
+	set(&c.Region, o.Region)
+	set(&c.RoleARN, o.RoleARN)
+	set(&c.SourceProfile, o.SourceProfile)
+	set(&c.CredentialSource, o.CredentialSource)
+	set(&c.ExternalID, o.ExternalID)
+	set(&c.SSOSession, o.SSOSession)
+	set(&c.SSOStartURL, o.SSOStartURL)
+	set(&c.CredentialProcess, o.CredentialProcess)
+	set(&c.WebIdentityTokenFile, o.WebIdentityTokenFile)
+	set(&c.MFASerial, o.MFASerial)
+}
+
+// kindOf decides how the profile gets its credentials, in the same order of
+// precedence as the AWS CLI. It is empty if the profile has no credentials.
+func kindOf(p Profile) Kind {
+	switch {
+	case p.Config.RoleARN != "" && p.Config.WebIdentityTokenFile != "":
+		return KindWebIdentity
+	case p.Config.RoleARN != "":
+		return KindAssumeRole
+	case p.Config.SSOSession != "" || p.Config.SSOStartURL != "":
+		return KindSSO
+	case p.Cred.AccessKeyID != "":
+		return KindStaticKey
+	case p.Config.CredentialProcess != "":
+		return KindProcess
+	}
+	return ""
+}
+
+// Rotatable checks whether the profile owns a long-lived access key that can
+// be rotated. Profiles of an unknown kind are judged by their credential alone.
+func (p *Profile) Rotatable() bool {
+	if p.Kind != KindStaticKey && p.Kind != "" {
+		return false
+	}
+	return p.Cred.AccessKeyID != "" && p.Cred.SecretAccessKey != "" && p.Cred.SessionToken == ""
+}
+
+// sharedConfigProfile is a profile section of the shared config file
+type sharedConfigProfile struct {
+	name    string
+	section string
+	cred    Credential
+	config  Config
+}
+
+// parseSharedConfigFile parses the profiles of the shared config file
+// (~/.aws/config), where profiles other than default are named
+// "[profile name]". Other sections, like sso-session, are skipped.
+func parseSharedConfigFile(path string) ([]sharedConfigProfile, error) {
+	sections, err := inifile.ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profiles []sharedConfigProfile
+	for _, section := range sections {
+		name := section.Name
+		if name != "default" {
+			fields := strings.Fields(name)
+			if len(fields) != 2 || fields[0] != "profile" {
+				continue
+			}
+			name = fields[1]
+		}
+		profiles = append(profiles, sharedConfigProfile{
+			name:    name,
+			section: section.Name,
+			cred:    credentialFromSection(section),
+			config:  configFromSection(section),
+		})
+	}
+	return profiles, nil
+}
+
+// mergeSharedConfig adds the settings of the shared config file to the
+// profiles from the credentials file, and adds the profiles that only exist in
+// the shared config file
+func mergeSharedConfig(profiles Profiles, configProfiles []sharedConfigProfile, configFile, currentProfile string) Profiles {
+	index := make(map[string]int)
+	for i, p := range profiles.Profiles {
+		index[p.Name] = i
+	}
+
+	for _, cp := range configProfiles {
+		i, ok := index[cp.name]
+		if !ok {
+			i = len(profiles.Profiles)
+			index[cp.name] = i
+			profiles.Profiles = append(profiles.Profiles, Profile{
+				Name:      cp.name,
+				Cloud:     "aws",
+				Source:    "ConfigFile",
+				File:      configFile,
+				IsCurrent: currentProfile != "" && currentProfile == cp.name,
+			})
+		}
+		p := &profiles.Profiles[i]
+
+		// The credentials file takes precedence over the shared config file
+		config := cp.config
+		config.merge(p.Config)
+		p.Config = config
+		if p.Cred.AccessKeyID == "" && cp.cred.AccessKeyID != "" {
+			p.Cred = cp.cred
+			p.File = configFile
+			p.section = cp.section
+		}
+	}
+
+	for i := range profiles.Profiles {
+		profiles.Profiles[i].Kind = kindOf(profiles.Profiles[i])
+	}
+	return profiles
+}
+
+// regionFromEnviron gets the region the AWS CLI would use from the environment
+func regionFromEnviron() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
@@ -0,0 +1,159 @@
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sharedFiles writes a credentials file and a shared config file to a
+// temporary directory and points CredentialsFile and AWS_CONFIG_FILE at them
+func sharedFiles(t *testing.T, credentials, config string) (string, string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsFile := filepath.Join(dir, "credentials")
+	configFile := filepath.Join(dir, "config")
+	if credentials != "" {
+		if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if config != "" {
+		if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	CredentialsFile = credentialsFile
+	os.Setenv("AWS_CONFIG_FILE", configFile)
+	return credentialsFile, configFile, func() {
+		CredentialsFile = ""
+		os.Unsetenv("AWS_CONFIG_FILE")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestFromConfigFileKinds(t *testing.T) {
+	os.Unsetenv("AWS_PROFILE")
+	os.Unsetenv("AWS_DEFAULT_PROFILE")
+	credentials := "[default]\n" +
+		"aws_access_key_id = " + accessKeyID + "\n" +
+		"aws_secret_access_key = " + secretAccessKey + "\n" +
+		"region = eu-west-1\n"
+	config := "[default]\n" +
+		"region = us-west-2\n" +
+		"output = json\n" +
+		"[profile admin]\n" +
+		"role_arn = arn:aws:iam::123456789012:role/admin\n" +
+		"source_profile = default\n" +
+		"[profile sandbox]\n" +
+		"sso_session = corp\n" +
+		"region = eu-central-1\n" +
+		"[profile tool]\n" +
+		"credential_process = /usr/bin/get-creds\n" +
+		"[profile ci]\n" +
+		"role_arn = arn:aws:iam::123456789012:role/ci\n" +
+		"web_identity_token_file = /var/run/token\n" +
+		"[profile legacy]\n" +
+		"aws_access_key_id = " + secondAccessKeyID + "\n" +
+		"aws_secret_access_key = " + secretAccessKey + "\n" +
+		"[sso-session corp]\n" +
+		"sso_start_url = https://corp.awsapps.com/start\n"
+	credentialsFile, configFile, cleanup := sharedFiles(t, credentials, config)
+	defer cleanup()
+
+	got, err := FromConfigFile(true)
+
+	assertNoError(t, err)
+	want := []struct {
+		name, region, file string
+		kind               Kind
+		rotatable          bool
+	}{
+		{"default", "eu-west-1", credentialsFile, KindStaticKey, true},
+		{"admin", "", configFile, KindAssumeRole, false},
+		{"sandbox", "eu-central-1", configFile, KindSSO, false},
+		{"tool", "", configFile, KindProcess, false},
+		{"ci", "", configFile, KindWebIdentity, false},
+		{"legacy", "", configFile, KindStaticKey, true},
+	}
+	if len(got.Profiles) != len(want) {
+		t.Fatalf("got %d profiles, want %d: %+v", len(got.Profiles), len(want), got.Profiles)
+	}
+	for i, w := range want {
+		p := got.Profiles[i]
+		assertString(t, p.Name, w.name)
+		assertString(t, string(p.Kind), string(w.kind))
+		assertString(t, p.Config.Region, w.region)
+		assertString(t, p.File, w.file)
+		if p.Rotatable() != w.rotatable {
+			t.Errorf("profile %s: got rotatable %v, want %v", p.Name, p.Rotatable(), w.rotatable)
+		}
+	}
+	assertString(t, got.Profiles[1].Config.SourceProfile, "default")
+	if !got.Profiles[0].IsCurrent {
+		t.Errorf("default profile is not current")
+	}
+}
+
+func TestFromConfigFileMissing(t *testing.T) {
+	t.Run("only the shared config file", func(t *testing.T) {
+		_, _, cleanup := sharedFiles(t, "", "[profile admin]\nrole_arn = arn:aws:iam::123456789012:role/admin\n")
+		defer cleanup()
+
+		got, err := FromConfigFile(false)
+
+		assertNoError(t, err)
+		if len(got.Profiles) != 1 {
+			t.Fatalf("got %d profiles, want 1", len(got.Profiles))
+		}
+		assertString(t, string(got.Profiles[0].Kind), string(KindAssumeRole))
+	})
+	t.Run("neither file", func(t *testing.T) {
+		_, _, cleanup := sharedFiles(t, "", "")
+		defer cleanup()
+
+		_, err := FromConfigFile(false)
+
+		if !os.IsNotExist(err) {
+			t.Errorf("got %v, want a not exist error", err)
+		}
+	})
+}
+
+func TestUpdateCredentialConfigFile(t *testing.T) {
+	config := "[profile legacy]\n" +
+		"aws_access_key_id = " + accessKeyID + "\n" +
+		"aws_secret_access_key = " + secretAccessKey + "\n"
+	_, configFile, cleanup := sharedFiles(t, "", config)
+	defer cleanup()
+	profiles, err := FromConfigFile(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := profiles.Profiles[0]
+
+	err = p.UpdateCredential(Credential{AccessKeyID: newAccessKeyID, SecretAccessKey: newSecretAccessKey})
+
+	assertNoError(t, err)
+	got, err := ioutil.ReadFile(configFile)
+	assertNoError(t, err)
+	assertString(t, string(got), "[profile legacy]\n"+
+		"aws_access_key_id = "+newAccessKeyID+"\n"+
+		"aws_secret_access_key = "+newSecretAccessKey+"\n")
+}
+
+func TestRotateKeyNotRotatable(t *testing.T) {
+	var calls []string
+	p := rotateProfile(mockedIAM{}, mockedIAM{Calls: &calls})
+	p.Kind = KindAssumeRole
+
+	_, err := p.RotateKey(fastVerify)
+
+	assertError(t, err, &NotRotatableError{Profile: profileName, Kind: KindAssumeRole})
+	assertString(t, err.Error(), "Profile default has no access key of its own to rotate (kind assume-role)")
+	assertCalls(t, calls, []string{})
+}
@@ -3,14 +3,16 @@ package aws
 import (
 	"os"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/buzzsurfr/cloudkey/internal/inifile"
 )
 
 // Credential is an AWS credential from the credentials file
 type Credential struct {
-	AccessKeyID     string `mapstructure:"aws_access_key_id"`
-	SecretAccessKey string `mapstructure:"aws_secret_access_key"`
-	SessionToken    string `mapstructure:"aws_session_token"`
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
 }
 
 func getCredentialFromEnviron() (Credential, bool) {
@@ -29,11 +31,23 @@ func getCredentialFromEnviron() (Credential, bool) {
 	return Credential{}, false
 }
 
+// credentialFromSection reads the credential keys of a profile section
+func credentialFromSection(section inifile.Section) Credential {
+	var cred Credential
+	cred.AccessKeyID, _ = section.Get("aws_access_key_id")
+	cred.SecretAccessKey, _ = section.Get("aws_secret_access_key")
+	cred.SessionToken, _ = section.Get("aws_session_token")
+	return cred
+}
+
 // FromAccessKey converts an iam.AccessKey to a Credential
 func FromAccessKey(key iam.AccessKey) (Credential, error) {
 	cred := Credential{
-		AccessKeyID:     *key.AccessKeyId,
-		SecretAccessKey: *key.SecretAccessKey,
+		AccessKeyID:     aws.StringValue(key.AccessKeyId),
+		SecretAccessKey: aws.StringValue(key.SecretAccessKey),
+	}
+	if cred.AccessKeyID == "" || cred.SecretAccessKey == "" {
+		return Credential{}, ErrIncompleteAccessKey
 	}
 	return cred, nil
 }
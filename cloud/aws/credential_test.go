@@ -72,4 +72,11 @@ func TestFromAccessKey(t *testing.T) {
 			t.Errorf("got %+v, want %+v", got, want)
 		}
 	})
+	t.Run("access key without secret", func(t *testing.T) {
+		_, err := FromAccessKey(iam.AccessKey{AccessKeyId: aws.String(accessKeyID)})
+
+		if err != ErrIncompleteAccessKey {
+			t.Errorf("got error %v, want %v", err, ErrIncompleteAccessKey)
+		}
+	})
 }
@@ -1,6 +1,10 @@
 package aws
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
 
 // ErrCredentialNotFound means no credential existed that can be rotated by cloudkey.
 var ErrCredentialNotFound = errors.New("No rotatable credential found. You may need to run aws configure")
@@ -10,3 +14,134 @@ var ErrUnknownSource = errors.New("Unknown source in profile")
 
 // ErrUnsupportedIdentityType is for any IAM resource that's not a user
 var ErrUnsupportedIdentityType = errors.New("Unsupported Identity Type--only supports user type")
+
+// ErrTooManyAccessKeys means the IAM user has no room for a second access key
+var ErrTooManyAccessKeys = errors.New("Too many access keys")
+
+// ErrKeyNotAccepted means STS never accepted the new access key
+var ErrKeyNotAccepted = errors.New("New access key was not accepted before the timeout")
+
+// ErrIncompleteAccessKey means IAM returned an access key without its ID or secret
+var ErrIncompleteAccessKey = errors.New("IAM returned an access key without its ID or secret")
+
+// ErrSecondKeyActive means the user's other access key is active, so it is not deleted
+var ErrSecondKeyActive = errors.New("Too many access keys and the other access key is active")
+
+// SecondKeyInUseError means the user's other access key is active and used by
+// another local profile, so it is not deleted
+type SecondKeyInUseError struct {
+	AccessKeyID string
+	Profile     string
+}
+
+func (e *SecondKeyInUseError) Error() string {
+	user := "the environment variables"
+	if e.Profile != "" {
+		user = "profile " + e.Profile
+	}
+	return "Too many access keys and the other access key " + e.AccessKeyID + " is used by " + user
+}
+
+// NotRotatableError means the profile does not own a long-lived access key,
+// such as a profile that assumes a role or signs in with SSO
+type NotRotatableError struct {
+	Profile string
+	Kind    Kind
+}
+
+func (e *NotRotatableError) Error() string {
+	name := "The environment variables"
+	if e.Profile != "" {
+		name = "Profile " + e.Profile
+	}
+	if e.Kind == "" || e.Kind == KindStaticKey {
+		return name + " has no long-lived access key to rotate"
+	}
+	return name + " has no access key of its own to rotate (kind " + string(e.Kind) + ")"
+}
+
+// AccountMismatchError means the access key belongs to another account than
+// the one expected for the profile
+type AccountMismatchError struct {
+	Want string
+	Got  string
+}
+
+func (e *AccountMismatchError) Error() string {
+	return "The access key belongs to account " + e.Got + ", not the expected account " + e.Want
+}
+
+// ErrSourceProfileNotFound means a role profile's source_profile does not exist
+var ErrSourceProfileNotFound = errors.New("source_profile not found")
+
+// ErrSourceProfileLoop means following source_profile leads back to a profile already visited
+var ErrSourceProfileLoop = errors.New("source_profile loops back to an earlier profile")
+
+// SourceProfileError means the source_profile chain of a role profile is broken
+type SourceProfileError struct {
+	Profile       string
+	SourceProfile string
+	Err           error
+}
+
+func (e *SourceProfileError) Error() string {
+	return "Profile " + e.Profile + " has source_profile " + e.SourceProfile + ": " + e.Err.Error()
+}
+
+// ErrNoDelivery means a new access key in environment variables could not reach the user's shell
+var ErrNoDelivery = errors.New("Environment variables can only be rotated if the new access key is delivered, for example with eval \"$(cloudkey rotate)\" or --env-file")
+
+// ErrNoMFADevice is returned when an MFA session is needed but the IAM user has no MFA device
+var ErrNoMFADevice = errors.New("No MFA device found for the IAM user. Set mfa_serial in the profile")
+
+// ErrNoGraceFile means there is nowhere to record an old access key kept for a grace period
+var ErrNoGraceFile = errors.New("A grace period needs a file to record the old access key in")
+
+// ErrKeyReactivated means a deactivated access key is active again, so it is not deleted
+var ErrKeyReactivated = errors.New("The old access key was reactivated, so it is not deleted")
+
+// ErrRotationInProgress means the journal of an interrupted rotation was found
+var ErrRotationInProgress = errors.New("A previous rotation was interrupted. Run cloudkey recover to finish or roll it back")
+
+// ErrCannotRollBack means the old access key was deleted, so the rotation can only be finished
+var ErrCannotRollBack = errors.New("The old access key was already deleted, so the rotation can only be finished")
+
+// Steps of a key rotation, reported in a RotateError
+const (
+	StepLookup           = "GetCallerIdentity"
+	StepListKeys         = "ListAccessKeys"
+	StepLastUsed         = "GetAccessKeyLastUsed"
+	StepCreateKey        = "CreateAccessKey"
+	StepUpdateCredential = "UpdateCredential"
+	StepDeliver          = "Deliver"
+	StepNewSession       = "NewSession"
+	StepVerify           = "Verify"
+	StepJournal          = "Journal"
+	StepDeactivateKey    = "UpdateAccessKey"
+	StepDeleteKey        = "DeleteAccessKey"
+	StepAssumeRole       = "AssumeRole"
+	StepGrace            = "Grace"
+	StepWatch            = "Watch"
+	StepPreRotate        = "PreRotate"
+	StepPostRotate       = "PostRotate"
+	StepMFA              = "GetSessionToken"
+)
+
+// RotateError is returned when a step of a key rotation fails
+type RotateError struct {
+	Step string
+	Err  error
+}
+
+func (e *RotateError) Error() string {
+	return e.Step + ": " + e.Err.Error()
+}
+
+// Code returns the AWS error code of the underlying error, or an empty string
+// if the error did not come from AWS
+func (e *RotateError) Code() string {
+	if aerr, ok := e.Err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package aws
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+)
+
+// GraceKey is an old access key that was deactivated instead of deleted, so it
+// can be reactivated if something still needs it. Prune deletes it once its
+// grace period is over.
+type GraceKey struct {
+	Profile     string    `json:"profile"`
+	Source      string    `json:"source"`
+	UserName    string    `json:"userName"`
+	AccessKeyID string    `json:"accessKeyId"`
+	Deactivated time.Time `json:"deactivated"`
+	DeleteAfter time.Time `json:"deleteAfter"`
+}
+
+// Due checks whether the grace period of the key is over
+func (k GraceKey) Due(now time.Time) bool {
+	return !now.Before(k.DeleteAfter)
+}
+
+// ReadGraceKeys reads the deactivated access keys recorded at path. It returns
+// nothing if the file does not exist.
+func ReadGraceKeys(path string) ([]GraceKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []GraceKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// addGraceKey records a deactivated access key at path
+func addGraceKey(path string, key GraceKey) error {
+	return updateGraceKeys(path, func(keys []GraceKey) []GraceKey {
+		return append(keys, key)
+	})
+}
+
+// RemoveGraceKey removes an access key from the record at path, once it was
+// deleted or reactivated
+func RemoveGraceKey(path, accessKeyID string) error {
+	return updateGraceKeys(path, func(keys []GraceKey) []GraceKey {
+		var kept []GraceKey
+		for _, k := range keys {
+			if k.AccessKeyID != accessKeyID {
+				kept = append(kept, k)
+			}
+		}
+		return kept
+	})
+}
+
+// updateGraceKeys changes the record at path, one change at a time
+func updateGraceKeys(path string, change func([]GraceKey) []GraceKey) error {
+	unlock := lockFile(path)
+	defer unlock()
+	keys, err := ReadGraceKeys(path)
+	if err != nil {
+		return err
+	}
+	keys = change(keys)
+	if keys == nil {
+		keys = []GraceKey{}
+	}
+	b, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, b, 0600)
+}
+
+// PruneKey deletes a deactivated access key using the profile's IAM client.
+// A key that was reactivated since is not deleted.
+func (p *Profile) PruneKey(key GraceKey) error {
+	keys, err := p.IAM.ListAccessKeys(&iam.ListAccessKeysInput{
+		UserName: aws.String(key.UserName),
+	})
+	if err != nil {
+		return &RotateError{Step: StepListKeys, Err: err}
+	}
+	for _, k := range keys.AccessKeyMetadata {
+		if aws.StringValue(k.AccessKeyId) == key.AccessKeyID && aws.StringValue(k.Status) == iam.StatusTypeActive {
+			return &RotateError{Step: StepListKeys, Err: ErrKeyReactivated}
+		}
+	}
+	if err := deleteKey(p.IAM, key.UserName, key.AccessKeyID); err != nil {
+		return &RotateError{Step: StepDeleteKey, Err: err}
+	}
+	return nil
+}
+
+// ReactivateKey makes a deactivated access key active again using the
+// profile's IAM client
+func (p *Profile) ReactivateKey(key GraceKey) error {
+	_, err := p.IAM.UpdateAccessKey(&iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(key.AccessKeyID),
+		Status:      aws.String(iam.StatusTypeActive),
+		UserName:    aws.String(key.UserName),
+	})
+	if err != nil {
+		return &RotateError{Step: StepDeactivateKey, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package aws
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestRotateKeyGrace(t *testing.T) {
+	path, cleanup := tempJournal(t)
+	defer cleanup()
+	graceFile := filepath.Join(filepath.Dir(path), "grace.json")
+	oneKey := []*iam.AccessKeyMetadata{{AccessKeyId: aws.String(accessKeyID)}}
+
+	t.Run("keep old access key deactivated", func(t *testing.T) {
+		var newCalls []string
+		p := rotateProfile(mockedIAM{Calls: &newCalls}, mockedIAM{Keys: oneKey})
+		opts := fastVerify
+		opts.Journal = path
+		opts.Grace = 7 * 24 * time.Hour
+		opts.GraceFile = graceFile
+
+		got, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertCalls(t, newCalls, []string{"UpdateAccessKey " + accessKeyID + " Inactive"})
+		assertNoJournal(t, path)
+		keys, err := ReadGraceKeys(graceFile)
+		assertNoError(t, err)
+		if len(keys) != 1 {
+			t.Fatalf("got %d grace keys, want 1", len(keys))
+		}
+		assertString(t, keys[0].AccessKeyID, accessKeyID)
+		assertString(t, keys[0].UserName, "defaultUser")
+		if !keys[0].DeleteAfter.Equal(got.DeleteAfter) || keys[0].Due(time.Now()) {
+			t.Errorf("got delete after %v, want a week from now", keys[0].DeleteAfter)
+		}
+	})
+	t.Run("fail without grace file", func(t *testing.T) {
+		var calls []string
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, Calls: &calls})
+		opts := fastVerify
+		opts.Grace = time.Hour
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepGrace, ErrNoGraceFile)
+		assertCalls(t, calls, []string{})
+	})
+}
+
+func TestPruneKey(t *testing.T) {
+	key := GraceKey{Profile: profileName, UserName: "defaultUser", AccessKeyID: accessKeyID}
+
+	t.Run("delete inactive access key", func(t *testing.T) {
+		var calls []string
+		p := Profile{IAM: mockedIAM{Calls: &calls, Keys: []*iam.AccessKeyMetadata{
+			{AccessKeyId: aws.String(accessKeyID), Status: aws.String(iam.StatusTypeInactive)},
+		}}}
+
+		err := p.PruneKey(key)
+
+		assertNoError(t, err)
+		assertCalls(t, calls, []string{"ListAccessKeys", "DeleteAccessKey " + accessKeyID})
+	})
+	t.Run("keep reactivated access key", func(t *testing.T) {
+		var calls []string
+		p := Profile{IAM: mockedIAM{Calls: &calls, Keys: []*iam.AccessKeyMetadata{
+			{AccessKeyId: aws.String(accessKeyID), Status: aws.String(iam.StatusTypeActive)},
+		}}}
+
+		err := p.PruneKey(key)
+
+		assertRotateError(t, err, StepListKeys, ErrKeyReactivated)
+		assertCalls(t, calls, []string{"ListAccessKeys"})
+	})
+	t.Run("reactivate access key", func(t *testing.T) {
+		var calls []string
+		p := Profile{IAM: mockedIAM{Calls: &calls}}
+
+		err := p.ReactivateKey(key)
+
+		assertNoError(t, err)
+		assertCalls(t, calls, []string{"UpdateAccessKey " + accessKeyID + " Active"})
+	})
+}
+
+func TestRemoveGraceKey(t *testing.T) {
+	path, cleanup := tempJournal(t)
+	defer cleanup()
+	for _, id := range []string{accessKeyID, secondAccessKeyID} {
+		if err := addGraceKey(path, GraceKey{AccessKeyID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := RemoveGraceKey(path, accessKeyID)
+
+	assertNoError(t, err)
+	keys, err := ReadGraceKeys(path)
+	assertNoError(t, err)
+	if len(keys) != 1 || keys[0].AccessKeyID != secondAccessKeyID {
+		t.Errorf("got %+v, want only %s", keys, secondAccessKeyID)
+	}
+}
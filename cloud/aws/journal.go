@@ -0,0 +1,237 @@
+package aws
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+)
+
+// Steps recorded in a Journal. Each step is written before it is attempted,
+// so a recorded step may or may not have completed.
+const (
+	JournalStarted     = "started"     // about to create the new access key
+	JournalCreated     = "created"     // new access key exists, local credential not written
+	JournalUpdated     = "updated"     // writing the new access key locally
+	JournalDeactivated = "deactivated" // deactivating and deleting the old access key
+)
+
+// Journal records the progress of a key rotation so that an interrupted
+// rotation can be finished or rolled back. It contains both secrets, so it is
+// written with the same permissions as the credentials file.
+type Journal struct {
+	Path     string     `json:"-"`
+	Profile  string     `json:"profile"`
+	Source   string     `json:"source"`
+	File     string     `json:"file,omitempty"`
+	Section  string     `json:"section,omitempty"`
+	UserName string     `json:"userName"`
+	Step     string     `json:"step"`
+	OldCred  Credential `json:"oldCredential"`
+	NewCred  Credential `json:"newCredential"`
+	// Grace keeps the old access key deactivated for this long instead of deleting it
+	Grace time.Duration `json:"grace,omitempty"`
+	// Watch checks the old access key for use this long before it is deleted
+	Watch time.Duration `json:"watch,omitempty"`
+	OnUse WatchPolicy   `json:"onUse,omitempty"`
+	// MFASerial is the MFA device used for the IAM calls, if any
+	MFASerial string    `json:"mfaSerial,omitempty"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+}
+
+// ReadJournal reads the journal at path. It returns nil if there is no journal.
+func ReadJournal(path string) (*Journal, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var j Journal
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, err
+	}
+	j.Path = path
+	return &j, nil
+}
+
+// JournalName names the journal file of a profile. Each profile has its own
+// journal, so profiles can be rotated at the same time.
+func JournalName(profile string) string {
+	if profile == "" {
+		return "environment.json"
+	}
+	// Hex keeps the name inside the journal directory, and different on case
+	// insensitive file systems, without two profiles sharing a journal
+	return "profile-" + hex.EncodeToString([]byte(profile)) + ".json"
+}
+
+// ReadJournals reads all journals in dir, sorted by when they were started
+func ReadJournals(dir string) ([]*Journal, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var journals []*Journal
+	for _, path := range paths {
+		j, err := ReadJournal(path)
+		if err != nil {
+			return nil, err
+		}
+		if j != nil {
+			journals = append(journals, j)
+		}
+	}
+	sort.Slice(journals, func(i, k int) bool { return journals[i].Started.Before(journals[k].Started) })
+	return journals, nil
+}
+
+// record saves the journal at the step. A journal without a path is not saved.
+func (j *Journal) record(step string) error {
+	j.Step = step
+	j.Updated = time.Now()
+	if j.Path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(j.Path, b, 0600)
+}
+
+// remove deletes the journal once the rotation is finished or rolled back
+func (j *Journal) remove() error {
+	if j.Path == "" {
+		return nil
+	}
+	err := os.Remove(j.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Recover finishes the rotation recorded in the journal, or rolls it back if
+// rollback is set or the rotation cannot be finished. Once the old access key
+// has been deleted, the rotation can only be finished.
+func (j *Journal) Recover(rollback bool, opts RotateOptions) (Rotation, error) {
+	opts = opts.withDefaults()
+	p := &Profile{
+		Name:    j.Profile,
+		Cloud:   "aws",
+		Cred:    j.OldCred,
+		Source:  j.Source,
+		File:    j.File,
+		section: j.Section,
+	}
+	r := Rotation{
+		Profile:  j.Profile,
+		UserName: j.UserName,
+		OldKeyID: j.OldCred.AccessKeyID,
+		NewKeyID: j.NewCred.AccessKeyID,
+		Started:  j.Started,
+	}
+
+	if j.Step != JournalStarted {
+		if err := opts.checkDelivery(p); err != nil {
+			return r, err
+		}
+	}
+
+	var err error
+	x := &rotation{p: p, j: j, opts: opts}
+	x.oldIAM, x.oldSTS, err = clientsForCredential(p, j.OldCred)
+	if err != nil {
+		return r, &RotateError{Step: StepNewSession, Err: err}
+	}
+	if j.Step != JournalStarted {
+		x.newIAM, x.newSTS, err = clientsForCredential(p, j.NewCred)
+		if err != nil {
+			return r, &RotateError{Step: StepNewSession, Err: err}
+		}
+	}
+
+	// Make the IAM calls with an MFA session, from the old access key unless
+	// it may have been deactivated already
+	if opts.MFATokenCode != nil {
+		stsClient, iamClient := x.oldSTS, x.oldIAM
+		if j.Step == JournalDeactivated {
+			stsClient, iamClient = x.newSTS, x.newIAM
+		}
+		mfaIAM, _, err := mfaSession(p, stsClient, iamClient, j.MFASerial, j.UserName, opts)
+		if err != nil {
+			return r, err
+		}
+		x.oldIAM, x.newIAM = mfaIAM, mfaIAM
+	}
+	p.IAM = x.oldIAM
+
+	if j.Step == JournalStarted {
+		// The new access key may have been created, but we never learned its secret
+		if err := x.deleteOrphanedKeys(); err != nil {
+			return r, err
+		}
+		if err := j.remove(); err != nil {
+			return r, &RotateError{Step: StepJournal, Err: err}
+		}
+		r.RolledBack = true
+		return r, nil
+	}
+
+	if rollback {
+		return x.rollback(r, nil)
+	}
+	return x.resume(r)
+}
+
+// deleteOrphanedKeys deletes access keys created after the journal was started
+func (x *rotation) deleteOrphanedKeys() error {
+	keys, err := x.oldIAM.ListAccessKeys(&iam.ListAccessKeysInput{
+		UserName: aws.String(x.j.UserName),
+	})
+	if err != nil {
+		return &RotateError{Step: StepListKeys, Err: err}
+	}
+	for _, key := range keys.AccessKeyMetadata {
+		if aws.StringValue(key.AccessKeyId) == x.j.OldCred.AccessKeyID {
+			continue
+		}
+		// CreateDate only has a precision of seconds
+		if aws.TimeValue(key.CreateDate).Before(x.j.Started.Truncate(time.Second)) {
+			continue
+		}
+		if err := deleteKey(x.oldIAM, x.j.UserName, aws.StringValue(key.AccessKeyId)); err != nil {
+			return &RotateError{Step: StepDeleteKey, Err: err}
+		}
+	}
+	return nil
+}
+
+// deleteKey deletes an access key. A key that is already gone counts as deleted.
+func deleteKey(svc iamiface.IAMAPI, userName, accessKeyID string) error {
+	_, err := svc.DeleteAccessKey(&iam.DeleteAccessKeyInput{
+		AccessKeyId: aws.String(accessKeyID),
+		UserName:    aws.String(userName),
+	})
+	if isNoSuchEntity(err) {
+		return nil
+	}
+	return err
+}
+
+func isNoSuchEntity(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == iam.ErrCodeNoSuchEntityException
+}
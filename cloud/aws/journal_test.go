@@ -0,0 +1,273 @@
+package aws
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// clientsByKey returns mocked clients depending on the access key used
+func clientsByKey(clients map[string]mockedIAM) {
+	clientsForCredential = func(p *Profile, cred Credential) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+		return clients[cred.AccessKeyID], mockedSTS{Resp: userIdentity()}, nil
+	}
+}
+
+func tempJournal(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "journal.json"), func() { os.RemoveAll(dir) }
+}
+
+func testJournal(path, step string) *Journal {
+	return &Journal{
+		Path:     path,
+		Profile:  profileName,
+		Source:   "EnvironmentVariable",
+		UserName: "defaultUser",
+		OldCred:  Credential{AccessKeyID: accessKeyID, SecretAccessKey: secretAccessKey},
+		NewCred:  Credential{AccessKeyID: newAccessKeyID, SecretAccessKey: newSecretAccessKey},
+		Started:  time.Now(),
+		Step:     step,
+	}
+}
+
+func TestRotateKeyJournal(t *testing.T) {
+	oneKey := []*iam.AccessKeyMetadata{{AccessKeyId: aws.String(accessKeyID)}}
+
+	t.Run("journal removed after rotation", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey})
+		opts := fastVerify
+		opts.Journal = path
+
+		_, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertNoJournal(t, path)
+	})
+	t.Run("journal kept when old access key cannot be deleted", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		failure := errors.New("ServiceFailure")
+		p := rotateProfile(mockedIAM{DeleteErr: failure}, mockedIAM{Keys: oneKey})
+		opts := fastVerify
+		opts.Journal = path
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepDeleteKey, failure)
+		j, err := ReadJournal(path)
+		assertNoError(t, err)
+		assertString(t, j.Step, JournalDeactivated)
+		assertString(t, j.NewCred.SecretAccessKey, newSecretAccessKey)
+	})
+	t.Run("new access key deleted when IAM returns no secret", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		var calls []string
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, NoSecret: true, Calls: &calls})
+		opts := fastVerify
+		opts.Journal = path
+
+		got, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepCreateKey, ErrIncompleteAccessKey)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertCalls(t, calls, []string{"ListAccessKeys", "CreateAccessKey", "DeleteAccessKey " + newAccessKeyID})
+		assertNoJournal(t, path)
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+	})
+	t.Run("fail on interrupted rotation", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		if err := testJournal(path, JournalCreated).record(JournalCreated); err != nil {
+			t.Fatal(err)
+		}
+		var calls []string
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, Calls: &calls})
+		opts := fastVerify
+		opts.Journal = path
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepJournal, ErrRotationInProgress)
+		assertCalls(t, calls, []string{})
+	})
+}
+
+func TestRecover(t *testing.T) {
+	t.Run("finish from deactivated", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		j := testJournal(path, JournalDeactivated)
+		var oldCalls, newCalls []string
+		clientsByKey(map[string]mockedIAM{
+			accessKeyID:    {Calls: &oldCalls},
+			newAccessKeyID: {Calls: &newCalls},
+		})
+
+		got, err := j.Recover(false, fastVerify)
+
+		assertNoError(t, err)
+		if got.RolledBack {
+			t.Errorf("rotation was rolled back")
+		}
+		assertCalls(t, oldCalls, []string{})
+		assertCalls(t, newCalls, []string{
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		})
+		assertNoJournal(t, path)
+	})
+	t.Run("roll back from updated", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		j := testJournal(path, JournalUpdated)
+		var oldCalls, newCalls []string
+		clientsByKey(map[string]mockedIAM{
+			accessKeyID:    {Calls: &oldCalls},
+			newAccessKeyID: {Calls: &newCalls},
+		})
+
+		got, err := j.Recover(true, fastVerify)
+
+		assertNoError(t, err)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertString(t, os.Getenv("AWS_ACCESS_KEY_ID"), accessKeyID)
+		assertCalls(t, oldCalls, []string{"DeleteAccessKey " + newAccessKeyID})
+		assertCalls(t, newCalls, []string{})
+		assertNoJournal(t, path)
+	})
+	t.Run("roll back from deactivated", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		j := testJournal(path, JournalDeactivated)
+		var oldCalls, newCalls []string
+		clientsByKey(map[string]mockedIAM{
+			accessKeyID:    {Calls: &oldCalls},
+			newAccessKeyID: {Calls: &newCalls},
+		})
+
+		_, err := j.Recover(true, fastVerify)
+
+		assertNoError(t, err)
+		assertCalls(t, newCalls, []string{"UpdateAccessKey " + accessKeyID + " Active"})
+		assertCalls(t, oldCalls, []string{"DeleteAccessKey " + newAccessKeyID})
+	})
+	t.Run("delete orphaned access key from started", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		j := testJournal(path, JournalStarted)
+		j.NewCred = Credential{}
+		var oldCalls []string
+		clientsByKey(map[string]mockedIAM{
+			accessKeyID: {Calls: &oldCalls, Keys: []*iam.AccessKeyMetadata{
+				{AccessKeyId: aws.String(accessKeyID), CreateDate: aws.Time(j.Started.AddDate(-1, 0, 0))},
+				{AccessKeyId: aws.String(newAccessKeyID), CreateDate: aws.Time(j.Started)},
+			}},
+		})
+
+		got, err := j.Recover(false, fastVerify)
+
+		assertNoError(t, err)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertCalls(t, oldCalls, []string{"ListAccessKeys", "DeleteAccessKey " + newAccessKeyID})
+	})
+}
+
+func TestReadJournal(t *testing.T) {
+	t.Run("no journal", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+
+		got, err := ReadJournal(path)
+
+		assertNoError(t, err)
+		if got != nil {
+			t.Errorf("got %+v, want nil", got)
+		}
+	})
+	t.Run("journal written with restricted permissions", func(t *testing.T) {
+		path, cleanup := tempJournal(t)
+		defer cleanup()
+		want := testJournal(path, JournalCreated)
+		if err := want.record(JournalCreated); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadJournal(path)
+
+		assertNoError(t, err)
+		assertString(t, got.Path, path)
+		assertString(t, got.Step, JournalCreated)
+		assertString(t, got.OldCred.SecretAccessKey, secretAccessKey)
+		info, err := os.Stat(path)
+		assertNoError(t, err)
+		if info.Mode().Perm()&0077 != 0 {
+			t.Errorf("journal is readable by others: %v", info.Mode())
+		}
+	})
+}
+
+func TestReadJournals(t *testing.T) {
+	path, cleanup := tempJournal(t)
+	defer cleanup()
+	dir := filepath.Dir(path)
+	older := testJournal(filepath.Join(dir, JournalName("Prod/Admin")), JournalCreated)
+	older.Started = older.Started.Add(-time.Minute)
+	newer := testJournal(filepath.Join(dir, JournalName("")), JournalUpdated)
+	for _, j := range []*Journal{newer, older} {
+		if err := j.record(j.Step); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ReadJournals(dir)
+
+	assertNoError(t, err)
+	if len(got) != 2 {
+		t.Fatalf("got %d journals, want 2", len(got))
+	}
+	assertString(t, filepath.Base(got[0].Path), "profile-50726f642f41646d696e.json")
+	assertString(t, filepath.Base(got[1].Path), "environment.json")
+}
+
+func TestJournalName(t *testing.T) {
+	names := map[string]string{}
+	for _, profile := range []string{"", "a/b", "a:b", "a_b", "a\\b", "Prod", "prod"} {
+		name := JournalName(profile)
+		if other, ok := names[name]; ok {
+			t.Errorf("profiles %q and %q share journal %s", other, profile, name)
+		}
+		if filepath.Base(name) != name {
+			t.Errorf("journal %s of profile %q is outside the journal directory", name, profile)
+		}
+		names[name] = profile
+	}
+}
+
+func assertNoJournal(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("journal %s still exists", path)
+	}
+}
@@ -0,0 +1,67 @@
+package aws
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+// maxMFASession is the longest session GetSessionToken grants an IAM user
+const maxMFASession = 36 * time.Hour
+
+// mfaSessionDuration is long enough for the IAM calls of a whole rotation,
+// including waiting for the new access key and watching the old one
+func (o RotateOptions) mfaSessionDuration() time.Duration {
+	d := 15*time.Minute + o.VerifyTimeout + o.Watch
+	if d > maxMFASession {
+		d = maxMFASession
+	}
+	return d
+}
+
+// mfaSession asks for a token code of the user's MFA device and gets a
+// session token with it from the STS client, which uses the access key the
+// session is for. It returns an IAM client using the session, and the serial
+// number of the MFA device. Without a serial number, the user's first MFA
+// device is used.
+func mfaSession(p *Profile, stsClient stsiface.STSAPI, iamClient iamiface.IAMAPI, serial, userName string, opts RotateOptions) (iamiface.IAMAPI, string, error) {
+	if serial == "" {
+		devices, err := iamClient.ListMFADevices(&iam.ListMFADevicesInput{
+			UserName: aws.String(userName),
+		})
+		if err != nil {
+			return nil, "", &RotateError{Step: StepMFA, Err: err}
+		}
+		if len(devices.MFADevices) == 0 {
+			return nil, "", &RotateError{Step: StepMFA, Err: ErrNoMFADevice}
+		}
+		serial = aws.StringValue(devices.MFADevices[0].SerialNumber)
+	}
+
+	code, err := opts.MFATokenCode(serial)
+	if err != nil {
+		return nil, serial, &RotateError{Step: StepMFA, Err: err}
+	}
+	out, err := stsClient.GetSessionToken(&sts.GetSessionTokenInput{
+		SerialNumber:    aws.String(serial),
+		TokenCode:       aws.String(code),
+		DurationSeconds: aws.Int64(int64(opts.mfaSessionDuration().Seconds())),
+	})
+	if err != nil {
+		return nil, serial, &RotateError{Step: StepMFA, Err: err}
+	}
+	session := Credential{
+		AccessKeyID:     aws.StringValue(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(out.Credentials.SessionToken),
+	}
+	mfaIAM, _, err := clientsForCredential(p, session)
+	if err != nil {
+		return nil, serial, &RotateError{Step: StepNewSession, Err: err}
+	}
+	return mfaIAM, serial, nil
+}
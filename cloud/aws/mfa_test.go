@@ -0,0 +1,97 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+const mfaSerial = "arn:aws:iam::123456789012:mfa/defaultUser"
+
+// mfaSTS hands out session tokens for an MFA device
+type mfaSTS struct {
+	mockedSTS
+	Calls *[]string
+}
+
+func (m mfaSTS) GetSessionToken(in *sts.GetSessionTokenInput) (*sts.GetSessionTokenOutput, error) {
+	*m.Calls = append(*m.Calls, "GetSessionToken "+aws.StringValue(in.SerialNumber)+" "+aws.StringValue(in.TokenCode))
+	return &sts.GetSessionTokenOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("ASIAMFASESSION"),
+		SecretAccessKey: aws.String(secretAccessKey),
+		SessionToken:    aws.String("token"),
+	}}, nil
+}
+
+func TestRotateKeyMFA(t *testing.T) {
+	oneKey := []*iam.AccessKeyMetadata{{AccessKeyId: aws.String(accessKeyID)}}
+
+	// mfaProfile uses the MFA session for credentials with a session token,
+	// and the new access key's clients otherwise
+	mfaProfile := func(oldIAM, mfaIAM, newIAM mockedIAM, stsCalls *[]string) Profile {
+		p := rotateProfile(newIAM, oldIAM)
+		p.STS = mfaSTS{mockedSTS: mockedSTS{Resp: userIdentity()}, Calls: stsCalls}
+		clientsForCredential = func(_ *Profile, cred Credential) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+			if cred.SessionToken != "" {
+				return mfaIAM, nil, nil
+			}
+			return newIAM, mockedSTS{Resp: userIdentity()}, nil
+		}
+		return p
+	}
+	tokenCode := func(serial string) (string, error) { return "123456", nil }
+
+	t.Run("make IAM calls with MFA session", func(t *testing.T) {
+		var oldCalls, mfaCalls, newCalls, stsCalls []string
+		p := mfaProfile(
+			mockedIAM{MFASerial: mfaSerial, Calls: &oldCalls},
+			mockedIAM{Keys: oneKey, Calls: &mfaCalls},
+			mockedIAM{Calls: &newCalls},
+			&stsCalls,
+		)
+		opts := fastVerify
+		opts.MFATokenCode = tokenCode
+
+		_, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertCalls(t, oldCalls, []string{"ListMFADevices"})
+		assertCalls(t, stsCalls, []string{"GetSessionToken " + mfaSerial + " 123456"})
+		assertCalls(t, mfaCalls, []string{
+			"ListAccessKeys",
+			"CreateAccessKey",
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		})
+		assertCalls(t, newCalls, []string{})
+		assertString(t, p.Cred.AccessKeyID, newAccessKeyID)
+	})
+	t.Run("use mfa_serial of the profile", func(t *testing.T) {
+		var oldCalls, stsCalls []string
+		p := mfaProfile(mockedIAM{Calls: &oldCalls}, mockedIAM{Keys: oneKey}, mockedIAM{}, &stsCalls)
+		p.Config.MFASerial = "arn:aws:iam::123456789012:mfa/other"
+		opts := fastVerify
+		opts.MFATokenCode = tokenCode
+
+		_, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertCalls(t, oldCalls, []string{})
+		assertCalls(t, stsCalls, []string{"GetSessionToken arn:aws:iam::123456789012:mfa/other 123456"})
+	})
+	t.Run("fail without MFA device", func(t *testing.T) {
+		var stsCalls []string
+		p := mfaProfile(mockedIAM{}, mockedIAM{Keys: oneKey}, mockedIAM{}, &stsCalls)
+		opts := fastVerify
+		opts.MFATokenCode = tokenCode
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepMFA, ErrNoMFADevice)
+		assertCalls(t, stsCalls, []string{})
+	})
+}
@@ -0,0 +1,39 @@
+package aws
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// CredentialsFile overrides the location of the shared credentials file. When
+// empty, the location is resolved the same way as the AWS SDK does.
+var CredentialsFile string
+
+// CredentialsFilename gets the path of the shared credentials file: the
+// CredentialsFile override, then AWS_SHARED_CREDENTIALS_FILE, then
+// ~/.aws/credentials
+func CredentialsFilename() (string, error) {
+	return resolveFilename(CredentialsFile, "AWS_SHARED_CREDENTIALS_FILE", credentialsFileName)
+}
+
+// ConfigFilename gets the path of the shared config file: AWS_CONFIG_FILE,
+// then ~/.aws/config
+func ConfigFilename() (string, error) {
+	return resolveFilename("", "AWS_CONFIG_FILE", configFileName)
+}
+
+func resolveFilename(override, env, name string) (string, error) {
+	if override == "" {
+		override = os.Getenv(env)
+	}
+	if override != "" {
+		return homedir.Expand(override)
+	}
+	configPath, err := getConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configPath, name), nil
+}
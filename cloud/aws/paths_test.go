@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"os"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestCredentialsFilename(t *testing.T) {
+	defer func() { CredentialsFile = "" }()
+	defaultFile, err := homedir.Expand("~/.aws/credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("default path", func(t *testing.T) {
+		os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+		CredentialsFile = ""
+
+		got, err := CredentialsFilename()
+
+		assertString(t, got, defaultFile)
+		assertNoError(t, err)
+	})
+	t.Run("AWS_SHARED_CREDENTIALS_FILE set", func(t *testing.T) {
+		os.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/ci/credentials")
+		defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+		CredentialsFile = ""
+
+		got, err := CredentialsFilename()
+
+		assertString(t, got, "/ci/credentials")
+		assertNoError(t, err)
+	})
+	t.Run("override beats AWS_SHARED_CREDENTIALS_FILE", func(t *testing.T) {
+		os.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/ci/credentials")
+		defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+		CredentialsFile = "/project/credentials"
+
+		got, err := CredentialsFilename()
+
+		assertString(t, got, "/project/credentials")
+		assertNoError(t, err)
+	})
+}
+
+func TestConfigFilename(t *testing.T) {
+	t.Run("default path", func(t *testing.T) {
+		os.Unsetenv("AWS_CONFIG_FILE")
+
+		got, gotErr := ConfigFilename()
+		want, wantErr := homedir.Expand("~/.aws/config")
+
+		assertString(t, got, want)
+		assertNoError(t, gotErr)
+		assertNoError(t, wantErr)
+	})
+	t.Run("AWS_CONFIG_FILE set", func(t *testing.T) {
+		os.Setenv("AWS_CONFIG_FILE", "/ci/config")
+		defer os.Unsetenv("AWS_CONFIG_FILE")
+
+		got, err := ConfigFilename()
+
+		assertString(t, got, "/ci/config")
+		assertNoError(t, err)
+	})
+}
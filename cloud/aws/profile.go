@@ -3,10 +3,11 @@ package aws
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/iam/iamiface"
@@ -18,12 +19,18 @@ import (
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"gopkg.in/ini.v1"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	"github.com/buzzsurfr/cloudkey/internal/inifile"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/mitchellh/go-homedir"
-	"github.com/mitchellh/mapstructure"
-	"github.com/spf13/viper"
+)
+
+const (
+	credentialsFileName = "credentials"
+	configFileName      = "config"
+	// credentialsFileMode keeps the credentials file private to the user
+	credentialsFileMode = 0600
 )
 
 // Profile is a local profile containing the credential and configuration.
@@ -31,9 +38,13 @@ type Profile struct {
 	Name  string
 	Cloud string
 	Cred  Credential
-	// config    Config
+	// Config is the profile's merged settings from the config and credentials files
+	Config    Config
+	Kind      Kind
 	Source    string
+	File      string // file holding the credential, for the ConfigFile source
 	IsCurrent bool
+	section   string // section holding the credential, if not named after the profile
 	sts.GetCallerIdentityOutput
 	Session *session.Session
 	STS     stsiface.STSAPI
@@ -61,8 +72,20 @@ func (p *Profile) NewSession() error {
 		return nil
 	case "ConfigFile":
 		// fmt.Println("Source: ConfigFile")
+		configFile, err := ConfigFilename()
+		if err != nil {
+			return err
+		}
+		credentialsFile := p.File
+		if credentialsFile == "" || credentialsFile == configFile {
+			if credentialsFile, err = CredentialsFilename(); err != nil {
+				return err
+			}
+		}
 		p.Session = session.Must(session.NewSessionWithOptions(session.Options{
 			Profile: p.Name,
+			// Later files override earlier ones, as with the default locations
+			SharedConfigFiles: []string{configFile, credentialsFile},
 		}))
 		return nil
 	}
@@ -79,12 +102,6 @@ func (p *Profile) NewIAM() {
 	p.IAM = iam.New(p.Session)
 }
 
-// RotateKey creates a new key and deletes the old key (using the new key)
-// func (p *Profile) RotateKey() (bool, error) {
-// 	// TODO
-// 	return false, nil
-// }
-
 // Current gets the current profile
 func Current() (Profile, error) {
 	envProfile, err := FromEnviron()
@@ -99,6 +116,8 @@ func Current() (Profile, error) {
 				return p, err
 			}
 		}
+	} else if !os.IsNotExist(err) { // the config file is malformed
+		return Profile{}, err
 	}
 	// Didn't find profile in either environment variable or config file, return error
 	return Profile{}, ErrCredentialNotFound
@@ -113,6 +132,8 @@ func GetByName(profileName string) (Profile, error) {
 				return p, err
 			}
 		}
+	} else if !os.IsNotExist(err) { // the config file is malformed
+		return Profile{}, err
 	}
 	// Didn't find profile in either environment variable or config file, return error
 	return Profile{}, errors.New("No credential with profile name " + profileName + " found")
@@ -125,6 +146,8 @@ func FromEnviron() (Profile, error) {
 			Name:                    "",
 			Cloud:                   "aws",
 			Cred:                    c,
+			Config:                  Config{Region: regionFromEnviron()},
+			Kind:                    KindStaticKey,
 			Source:                  "EnvironmentVariable",
 			IsCurrent:               true,
 			GetCallerIdentityOutput: sts.GetCallerIdentityOutput{},
@@ -133,14 +156,18 @@ func FromEnviron() (Profile, error) {
 	return Profile{}, ErrCredentialNotFound
 }
 
-// FromConfigFile gets a list of profiles from the configuration file (default path/file is ~/.aws/credentials)
+// FromConfigFile gets a list of profiles from the credentials file (see
+// CredentialsFilename) merged with the shared config file (see ConfigFilename).
+// Settings in the credentials file take precedence. It only fails with a "not
+// exist" error if neither file exists.
 func FromConfigFile(findDefault bool) (Profiles, error) {
-	var profiles Profiles
-
-	awsConfigPath, err := getConfigPath()
+	credentialsFile, err := CredentialsFilename()
 	if err != nil {
-		return profiles, err // Returning profiles since it's empty here
-		// May change later since this assumes no credentials file found in ~/.aws
+		return Profiles{}, err
+	}
+	configFile, err := ConfigFilename()
+	if err != nil {
+		return Profiles{}, err
 	}
 
 	// Determine whether to highlight a current profile
@@ -152,40 +179,41 @@ func FromConfigFile(findDefault bool) (Profiles, error) {
 		currentProfile = ""
 	}
 
-	// Parse AWS config file
-	profiles, err = parseConfigFile(filepath.Join(awsConfigPath, "credentials"), currentProfile)
-	if err != nil {
+	// Parse AWS credentials file
+	profiles, credentialsErr := parseConfigFile(credentialsFile, currentProfile)
+	if credentialsErr != nil && !os.IsNotExist(credentialsErr) {
+		return Profiles{}, credentialsErr
+	}
+
+	// Parse AWS shared config file
+	configProfiles, err := parseSharedConfigFile(configFile)
+	if err != nil && !os.IsNotExist(err) {
 		return Profiles{}, err
 	}
+	if credentialsErr != nil && err != nil { // neither file exists
+		return Profiles{}, credentialsErr
+	}
 
-	return profiles, err
+	return mergeSharedConfig(profiles, configProfiles, configFile, currentProfile), nil
 }
 
 func parseConfigFile(path string, defaultProfile string) (Profiles, error) {
 	var profiles Profiles
 
-	v := viper.New()
-	v.SetConfigFile(path)
-	v.SetConfigType("ini")
-	err := v.ReadInConfig()
+	sections, err := inifile.ParseFile(path)
 	if err != nil {
 		return profiles, err // Returning profiles since it's empty here
 	}
 
-	// fmt.Printf("Configuration file:\n%+v\n\n", v) // DEBUG
-	allSettings := v.AllSettings()
-	// fmt.Printf("AllSettings: %+v\n", allSettings)
-
-	for key, value := range allSettings {
-		// fmt.Printf("Key: %s\nValue: %+v\n\n", key, value) // DEBUG
-		var cred Credential
-		mapstructure.Decode(value, &cred)
+	for _, section := range sections {
 		profiles.Profiles = append(profiles.Profiles, Profile{
-			Name:      key,
+			Name:      section.Name,
 			Cloud:     "aws",
-			Cred:      cred,
+			Cred:      credentialFromSection(section),
+			Config:    configFromSection(section),
 			Source:    "ConfigFile",
-			IsCurrent: defaultProfile == key,
+			File:      path,
+			IsCurrent: defaultProfile == section.Name,
 		})
 	}
 
@@ -266,13 +294,24 @@ func (p *Profile) UpdateCredential(cred Credential) error {
 		os.Setenv("AWS_ACCESS_KEY_ID", cred.AccessKeyID)
 		os.Setenv("AWS_SECRET_ACCESS_KEY", cred.SecretAccessKey)
 	case "ConfigFile":
-		akidErr := exec.Command("aws", "--profile", p.Name, "configure", "set", "aws_access_key_id", cred.AccessKeyID).Run()
-		if akidErr != nil {
-			panic(akidErr)
+		file := p.File
+		if file == "" {
+			var err error
+			if file, err = CredentialsFilename(); err != nil {
+				return err
+			}
 		}
-		asakErr := exec.Command("aws", "--profile", p.Name, "configure", "set", "aws_secret_access_key", cred.SecretAccessKey).Run()
-		if asakErr != nil {
-			panic(asakErr)
+		unlock := lockFile(file)
+		defer unlock()
+		if err := backupFile(file); err != nil {
+			return err
+		}
+		err := inifile.SetFile(file, p.sectionName(), []inifile.Key{
+			{Name: "aws_access_key_id", Value: cred.AccessKeyID},
+			{Name: "aws_secret_access_key", Value: cred.SecretAccessKey},
+		}, credentialsFileMode)
+		if err != nil {
+			return err
 		}
 	}
 	p.Cred = cred
@@ -280,37 +319,63 @@ func (p *Profile) UpdateCredential(cred Credential) error {
 	return nil
 }
 
-// WriteConfig writes the profiles to the default config file
+// fileLocks holds a mutex for each file written by UpdateCredential
+var fileLocks sync.Map
+
+// lockFile serializes writes to a file, so that profiles sharing a credentials
+// file can be rotated at the same time without losing each other's changes
+func lockFile(path string) func() {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	m, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu := m.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// sectionName is the name of the section holding the profile's credential
+func (p *Profile) sectionName() string {
+	if p.section != "" {
+		return p.section
+	}
+	return p.Name
+}
+
+// WriteConfig writes the profiles to the credentials file (see CredentialsFilename)
 func (p *Profiles) WriteConfig() error {
-	configPath, err := getConfigPath()
+	credentialsFile, err := CredentialsFilename()
 	if err != nil {
 		return err
 	}
-	fileName := "credentials"
-	return p.WriteConfigAs(configPath + string(os.PathSeparator) + fileName)
+	return p.WriteConfigAs(credentialsFile)
 }
 
-// WriteConfigAs writes the profiles to the config filename specified
+// WriteConfigAs writes the profiles to the config filename specified. Only the
+// credential keys of each profile are changed; everything else in the file is
+// kept as it is.
 func (p *Profiles) WriteConfigAs(filename string) error {
-	// Marshal to INI file type
-	cfg := ini.Empty()
-	ini.PrettyFormat = false
-	ini.PrettyEqual = true
+	data, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	for _, profile := range p.Profiles {
 		if profile.Source != "ConfigFile" {
 			continue
 		}
-		cfg.Section(profile.Name).Key("aws_access_key_id").SetValue(profile.Cred.AccessKeyID)
-		cfg.Section(profile.Name).Key("aws_secret_access_key").SetValue(profile.Cred.SecretAccessKey)
+		keys := []inifile.Key{
+			{Name: "aws_access_key_id", Value: profile.Cred.AccessKeyID},
+			{Name: "aws_secret_access_key", Value: profile.Cred.SecretAccessKey},
+		}
 		if profile.Cred.SessionToken != "" {
-			cfg.Section(profile.Name).Key("aws_session_token").SetValue(profile.Cred.SessionToken)
+			keys = append(keys, inifile.Key{Name: "aws_session_token", Value: profile.Cred.SessionToken})
 		}
+		data = inifile.Set(data, profile.Name, keys)
 	}
 
-	// Save to file
-	err := cfg.SaveTo(filename)
-	if err != nil {
+	// Save to file, keeping a copy of the old one
+	if err := backupFile(filename); err != nil {
 		return err
 	}
-	return nil
+	return atomicfile.WriteFile(filename, data, credentialsFileMode)
 }
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,6 +16,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/iam/iamiface"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/buzzsurfr/cloudkey/internal/backup"
 	"github.com/mitchellh/go-homedir"
 )
 
@@ -250,6 +252,7 @@ func TestParseConfigFile(t *testing.T) {
 						SecretAccessKey: secretAccessKey,
 					},
 					Source:                  "ConfigFile",
+					File:                    tempConfigFile.Name(),
 					IsCurrent:               true,
 					GetCallerIdentityOutput: sts.GetCallerIdentityOutput{},
 				},
@@ -272,6 +275,7 @@ func TestParseConfigFile(t *testing.T) {
 						SecretAccessKey: secretAccessKey,
 					},
 					Source:                  "ConfigFile",
+					File:                    tempConfigFile.Name(),
 					IsCurrent:               false,
 					GetCallerIdentityOutput: sts.GetCallerIdentityOutput{},
 				},
@@ -283,6 +287,44 @@ func TestParseConfigFile(t *testing.T) {
 	})
 }
 
+func TestParseConfigFileNames(t *testing.T) {
+	tempConfigFile, err := ioutil.TempFile("", "awsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tempConfigFile.Name())
+	tempConfigFile.Close()
+
+	t.Run("profile names keep their case and order", func(t *testing.T) {
+		tempConfig := fmt.Sprintf("[Prod-Admin]\naws_access_key_id = %s\naws_secret_access_key = %s\n[default]\naws_access_key_id = %s\n", accessKeyID, secretAccessKey, accessKeyID)
+		if err := ioutil.WriteFile(tempConfigFile.Name(), []byte(tempConfig), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := parseConfigFile(tempConfigFile.Name(), "Prod-Admin")
+
+		assertNoError(t, err)
+		if len(got.Profiles) != 2 {
+			t.Fatalf("got %d profiles, want 2", len(got.Profiles))
+		}
+		assertString(t, got.Profiles[0].Name, "Prod-Admin")
+		assertString(t, got.Profiles[1].Name, "default")
+		if !got.Profiles[0].IsCurrent || got.Profiles[1].IsCurrent {
+			t.Errorf("wrong current profile: %+v", got.Profiles)
+		}
+	})
+	t.Run("fail on malformed line", func(t *testing.T) {
+		tempConfig := fmt.Sprintf("[default]\naws_access_key_id %s\n", accessKeyID)
+		if err := ioutil.WriteFile(tempConfigFile.Name(), []byte(tempConfig), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := parseConfigFile(tempConfigFile.Name(), "")
+
+		assertError(t, err, fmt.Errorf("%s:2: malformed line %q", tempConfigFile.Name(), "aws_access_key_id "+accessKeyID))
+	})
+}
+
 func TestGetConfigPath(t *testing.T) {
 	t.Run("default path", func(t *testing.T) {
 
@@ -410,6 +452,92 @@ func TestWriteConfigAs(t *testing.T) {
 	})
 }
 
+func TestUpdateCredential(t *testing.T) {
+	tempConfig := fmt.Sprintf("# work account\n[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\nregion = us-west-2\n", profileName, accessKeyID, secretAccessKey)
+	tempConfigFile, err := ioutil.TempFile("", "awsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tempConfigFile.Name())
+	if _, err := tempConfigFile.Write([]byte(tempConfig)); err != nil {
+		t.Fatal(err)
+	}
+	tempConfigFile.Close()
+
+	t.Run("config file keeps comments and other keys", func(t *testing.T) {
+		p := Profile{Name: profileName, Source: "ConfigFile", File: tempConfigFile.Name()}
+		cred := Credential{AccessKeyID: "AKIAI44QH8DHBEXAMPLE", SecretAccessKey: "je7MtGbClwBF/2Zp9Utk/h3yCo8nvbEXAMPLEKEY"}
+
+		err := p.UpdateCredential(cred)
+
+		assertNoError(t, err)
+		got, err := ioutil.ReadFile(tempConfigFile.Name())
+		assertNoError(t, err)
+		want := fmt.Sprintf("# work account\n[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\nregion = us-west-2\n", profileName, cred.AccessKeyID, cred.SecretAccessKey)
+		assertString(t, string(got), want)
+		if !reflect.DeepEqual(p.Cred, cred) {
+			t.Errorf("got %+v want %+v", p.Cred, cred)
+		}
+		info, err := os.Stat(tempConfigFile.Name())
+		assertNoError(t, err)
+		if info.Mode().Perm()&0077 != 0 {
+			t.Errorf("credentials file is readable by others: %v", info.Mode())
+		}
+	})
+	t.Run("back up the file before changing it", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "backups")
+		assertNoError(t, err)
+		defer os.RemoveAll(dir)
+		BackupDir = dir
+		defer func() { BackupDir = "" }()
+		before, err := ioutil.ReadFile(tempConfigFile.Name())
+		assertNoError(t, err)
+		p := Profile{Name: profileName, Source: "ConfigFile", File: tempConfigFile.Name()}
+
+		err = p.UpdateCredential(Credential{AccessKeyID: newAccessKeyID, SecretAccessKey: newSecretAccessKey})
+
+		assertNoError(t, err)
+		backups, err := backup.List(dir, tempConfigFile.Name())
+		assertNoError(t, err)
+		if len(backups) != 1 {
+			t.Fatalf("got %d backups, want 1", len(backups))
+		}
+		got, err := ioutil.ReadFile(backups[0].Path)
+		assertNoError(t, err)
+		assertString(t, string(got), string(before))
+	})
+}
+
+func TestUpdateCredentialConcurrent(t *testing.T) {
+	tempConfigFile, err := ioutil.TempFile("", "awsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tempConfigFile.Name())
+	tempConfigFile.Close()
+
+	t.Run("profiles sharing a file keep each other's changes", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				p := Profile{Name: fmt.Sprintf("profile%d", i), Source: "ConfigFile", File: tempConfigFile.Name()}
+				if err := p.UpdateCredential(Credential{AccessKeyID: newAccessKeyID, SecretAccessKey: newSecretAccessKey}); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		got, err := parseConfigFile(tempConfigFile.Name(), "")
+		assertNoError(t, err)
+		if len(got.Profiles) != 20 {
+			t.Errorf("got %d profiles, want 20", len(got.Profiles))
+		}
+	})
+}
+
 func assertString(t *testing.T, got, want string) {
 	t.Helper()
 	if !reflect.DeepEqual(got, want) {
@@ -0,0 +1,266 @@
+package aws
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/buzzsurfr/cloudkey/cloud"
+)
+
+// Name is the name of the AWS provider
+const Name = "aws"
+
+// Provider works with AWS access keys through cloud.Provider. It is a
+// cloud.Rotator, so cloud.Rotate rotates AWS profiles with Profile.RotateKey,
+// which also keeps a journal and supports grace periods and MFA.
+type Provider struct{}
+
+func init() {
+	cloud.Register(Provider{})
+}
+
+// Name is the name of the provider
+func (Provider) Name() string {
+	return Name
+}
+
+// Profiles finds the profiles in the environment variables and the
+// credentials and config files
+func (Provider) Profiles() ([]cloud.Profile, error) {
+	var profiles []cloud.Profile
+	envProfile, envErr := FromEnviron()
+	if envErr == nil {
+		profiles = append(profiles, CloudProfile(envProfile))
+	}
+	// The current profile is the environment's, if it has one
+	configProfiles, err := FromConfigFile(envErr != nil)
+	if err != nil && !os.IsNotExist(err) {
+		return profiles, err
+	}
+	for _, p := range configProfiles.Profiles {
+		profiles = append(profiles, CloudProfile(p))
+	}
+	return profiles, nil
+}
+
+// CloudProfile converts an AWS profile. Its Data points to a copy of p.
+func CloudProfile(p Profile) cloud.Profile {
+	return cloud.Profile{
+		Cloud:     Name,
+		Name:      p.Name,
+		Kind:      string(p.Kind),
+		Region:    p.Config.Region,
+		Account:   aws.StringValue(p.Account),
+		Cred:      cloud.Credential{ID: p.Cred.AccessKeyID, Secret: p.Cred.SecretAccessKey},
+		Source:    p.Source,
+		File:      p.File,
+		IsCurrent: p.IsCurrent,
+		Rotatable: p.Rotatable(),
+		Data:      &p,
+	}
+}
+
+// awsProfile gets the AWS profile behind a profile, with its clients
+func awsProfile(p cloud.Profile) (*Profile, error) {
+	ap, ok := p.Data.(*Profile)
+	if !ok {
+		return nil, errors.New("Not an AWS profile")
+	}
+	if ap.STS == nil || ap.IAM == nil {
+		if ap.Session == nil {
+			if err := ap.NewSession(); err != nil {
+				return nil, err
+			}
+		}
+		ap.NewSTS()
+		ap.NewIAM()
+	}
+	return ap, nil
+}
+
+// Lookup asks STS for the account and user name of the profile
+func (Provider) Lookup(p *cloud.Profile) error {
+	ap, err := awsProfile(*p)
+	if err != nil {
+		return err
+	}
+	if err := ap.Lookup(); err != nil {
+		return err
+	}
+	userName, err := ap.UserName()
+	if err != nil {
+		return err
+	}
+	p.Account = aws.StringValue(ap.Account)
+	p.UserName = userName
+	return nil
+}
+
+// Keys lists the access keys of the profile's user
+func (Provider) Keys(p cloud.Profile) ([]cloud.Key, error) {
+	ap, err := awsProfile(p)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ap.IAM.ListAccessKeys(&iam.ListAccessKeysInput{UserName: aws.String(p.UserName)})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]cloud.Key, 0, len(out.AccessKeyMetadata))
+	for _, k := range out.AccessKeyMetadata {
+		keys = append(keys, cloud.Key{
+			ID:      aws.StringValue(k.AccessKeyId),
+			Active:  aws.StringValue(k.Status) == iam.StatusTypeActive,
+			Created: aws.TimeValue(k.CreateDate),
+		})
+	}
+	return keys, nil
+}
+
+// CreateKey creates a new access key for the profile's user
+func (Provider) CreateKey(p cloud.Profile) (cloud.Credential, error) {
+	ap, err := awsProfile(p)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	out, err := ap.IAM.CreateAccessKey(&iam.CreateAccessKeyInput{UserName: aws.String(p.UserName)})
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	if out.AccessKey == nil {
+		return cloud.Credential{}, ErrIncompleteAccessKey
+	}
+	cred, err := FromAccessKey(*out.AccessKey)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	return cloud.Credential{ID: cred.AccessKeyID, Secret: cred.SecretAccessKey}, nil
+}
+
+// DeactivateKey makes an access key of the profile's user inactive
+func (Provider) DeactivateKey(p cloud.Profile, id string) error {
+	ap, err := awsProfile(p)
+	if err != nil {
+		return err
+	}
+	_, err = ap.IAM.UpdateAccessKey(&iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(id),
+		Status:      aws.String(iam.StatusTypeInactive),
+		UserName:    aws.String(p.UserName),
+	})
+	return err
+}
+
+// DeleteKey deletes an access key of the profile's user
+func (Provider) DeleteKey(p cloud.Profile, id string) error {
+	ap, err := awsProfile(p)
+	if err != nil {
+		return err
+	}
+	return deleteKey(ap.IAM, p.UserName, id)
+}
+
+// Verify checks whether STS accepts the credential
+func (Provider) Verify(p cloud.Profile, cred cloud.Credential) error {
+	ap, err := awsProfile(p)
+	if err != nil {
+		return err
+	}
+	_, stsClient, err := clientsForCredential(ap, Credential{AccessKeyID: cred.ID, SecretAccessKey: cred.Secret})
+	if err != nil {
+		return err
+	}
+	_, err = stsClient.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	return err
+}
+
+// WriteCredential saves the credential to the profile's file, or the
+// environment variables of this process. The profile's clients use it from
+// then on.
+func (Provider) WriteCredential(p *cloud.Profile, cred cloud.Credential) error {
+	ap, err := awsProfile(*p)
+	if err != nil {
+		return err
+	}
+	c := Credential{AccessKeyID: cred.ID, SecretAccessKey: cred.Secret}
+	if err := ap.UpdateCredential(c); err != nil {
+		return err
+	}
+	if ap.IAM, ap.STS, err = clientsForCredential(ap, c); err != nil {
+		return err
+	}
+	p.Cred = cred
+	return nil
+}
+
+// RotateKey rotates the access key with Profile.RotateKey. The options are
+// opts.Options if it holds RotateOptions, or else made from opts, and the
+// aws.Rotation is in the result's Data. Errors are those of Profile.RotateKey.
+func (Provider) RotateKey(p *cloud.Profile, opts cloud.RotateOptions) (cloud.Rotation, error) {
+	r := cloud.Rotation{Cloud: Name, Profile: p.Name, OldKeyID: p.Cred.ID, Started: time.Now()}
+	ap, err := awsProfile(*p)
+	if err != nil {
+		return r, &RotateError{Step: StepNewSession, Err: err}
+	}
+	o, ok := opts.Options.(RotateOptions)
+	if !ok {
+		o = rotateOptions(opts)
+	}
+	rotation, err := ap.RotateKey(o)
+	if ap.Account != nil {
+		p.Account, p.UserName = aws.StringValue(ap.Account), rotation.UserName
+	}
+	p.Cred = cloud.Credential{ID: ap.Cred.AccessKeyID, Secret: ap.Cred.SecretAccessKey}
+	return toRotation(rotation), err
+}
+
+// rotateOptions makes the options of Profile.RotateKey from those of
+// cloud.Rotate
+func rotateOptions(opts cloud.RotateOptions) RotateOptions {
+	o := RotateOptions{
+		VerifyTimeout:     opts.VerifyTimeout,
+		VerifyInterval:    opts.VerifyInterval,
+		VerifyMaxInterval: opts.VerifyMaxInterval,
+		MinAge:            opts.MinAge,
+	}
+	if opts.PreRotate != nil {
+		o.PreRotate = func(r Rotation) error {
+			return opts.PreRotate(toRotation(r))
+		}
+	}
+	if opts.PostRotate != nil {
+		o.PostRotate = func(r Rotation, cred Credential) error {
+			return opts.PostRotate(toRotation(r), cloud.Credential{ID: cred.AccessKeyID, Secret: cred.SecretAccessKey})
+		}
+	}
+	if opts.Deliver != nil {
+		o.Deliver = func(cred Credential) error {
+			return opts.Deliver(cloud.Credential{ID: cred.AccessKeyID, Secret: cred.SecretAccessKey}, []cloud.EnvVar{
+				{Name: "AWS_ACCESS_KEY_ID", Value: cred.AccessKeyID},
+				{Name: "AWS_SECRET_ACCESS_KEY", Value: cred.SecretAccessKey},
+			})
+		}
+	}
+	return o
+}
+
+// toRotation converts the result of Profile.RotateKey
+func toRotation(r Rotation) cloud.Rotation {
+	return cloud.Rotation{
+		Cloud:         Name,
+		Profile:       r.Profile,
+		UserName:      r.UserName,
+		OldKeyID:      r.OldKeyID,
+		NewKeyID:      r.NewKeyID,
+		Started:       r.Started,
+		Finished:      r.Finished,
+		OldKeyCreated: r.OldKeyCreated,
+		RolledBack:    r.RolledBack,
+		Skipped:       r.Skipped,
+		Data:          r,
+	}
+}
@@ -0,0 +1,94 @@
+package aws
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/buzzsurfr/cloudkey/cloud"
+)
+
+func TestProviderProfiles(t *testing.T) {
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	os.Unsetenv("AWS_PROFILE")
+	os.Unsetenv("AWS_DEFAULT_PROFILE")
+	_, _, cleanup := sharedFiles(t,
+		"[default]\naws_access_key_id = "+accessKeyID+"\naws_secret_access_key = "+secretAccessKey+"\n",
+		"[default]\nregion = eu-west-1\n[profile admin]\nrole_arn = arn:aws:iam::123456789012:role/admin\nsource_profile = default\n")
+	defer cleanup()
+
+	pr, err := cloud.Get(Name)
+	assertNoError(t, err)
+	got, err := pr.Profiles()
+
+	assertNoError(t, err)
+	if len(got) != 2 {
+		t.Fatalf("got %d profiles but want 2", len(got))
+	}
+	def, admin := got[0], got[1]
+	if def.Name != "default" || def.Cloud != Name || def.Cred.ID != accessKeyID || def.Region != "eu-west-1" || !def.IsCurrent || !def.Rotatable {
+		t.Errorf("got %+v", def)
+	}
+	if admin.Name != "admin" || admin.Kind != string(KindAssumeRole) || admin.Rotatable {
+		t.Errorf("got %+v", admin)
+	}
+}
+
+func TestProviderRotate(t *testing.T) {
+	credentialsFile, _, cleanup := sharedFiles(t, "[default]\naws_access_key_id = "+accessKeyID+"\naws_secret_access_key = "+secretAccessKey+"\n", "")
+	defer cleanup()
+	var oldCalls, newCalls []string
+	p := rotateProfile(mockedIAM{Calls: &newCalls}, mockedIAM{
+		Keys:  []*iam.AccessKeyMetadata{{AccessKeyId: aws.String(accessKeyID), Status: aws.String(iam.StatusTypeActive)}},
+		Calls: &oldCalls,
+	})
+	p.Source = "ConfigFile"
+	p.File = credentialsFile
+	cp := CloudProfile(p)
+
+	r, err := cloud.Rotate(Provider{}, &cp, cloud.RotateOptions{
+		VerifyTimeout:     fastVerify.VerifyTimeout,
+		VerifyInterval:    fastVerify.VerifyInterval,
+		VerifyMaxInterval: fastVerify.VerifyMaxInterval,
+	})
+
+	assertNoError(t, err)
+	assertString(t, r.UserName, "defaultUser")
+	assertString(t, cp.Account, accountID)
+	assertString(t, cp.Cred.ID, newAccessKeyID)
+	assertCalls(t, oldCalls, []string{"ListAccessKeys", "CreateAccessKey"})
+	assertCalls(t, newCalls, []string{"UpdateAccessKey " + accessKeyID + " Inactive", "DeleteAccessKey " + accessKeyID})
+	got, err := ioutil.ReadFile(credentialsFile)
+	assertNoError(t, err)
+	assertString(t, string(got), "[default]\naws_access_key_id = "+newAccessKeyID+"\naws_secret_access_key = "+newSecretAccessKey+"\n")
+	if ar, ok := r.Data.(Rotation); !ok || ar.NewKeyID != newAccessKeyID {
+		t.Errorf("got rotation data %+v but want the AWS rotation", r.Data)
+	}
+}
+
+func TestProviderRotateOptions(t *testing.T) {
+	credentialsFile, _, cleanup := sharedFiles(t, "[default]\naws_access_key_id = "+accessKeyID+"\naws_secret_access_key = "+secretAccessKey+"\n", "")
+	defer cleanup()
+	var oldCalls, newCalls []string
+	p := rotateProfile(mockedIAM{Calls: &newCalls}, mockedIAM{
+		Keys:  []*iam.AccessKeyMetadata{{AccessKeyId: aws.String(accessKeyID), Status: aws.String(iam.StatusTypeActive), CreateDate: aws.Time(time.Now())}},
+		Calls: &oldCalls,
+	})
+	p.Source = "ConfigFile"
+	p.File = credentialsFile
+	cp := CloudProfile(p)
+
+	// The AWS options are used instead of the generic ones
+	r, err := cloud.Rotate(Provider{}, &cp, cloud.RotateOptions{Options: RotateOptions{MinAge: time.Hour}})
+
+	assertNoError(t, err)
+	if r.Skipped == "" {
+		t.Errorf("got %+v but want the rotation skipped", r)
+	}
+	assertCalls(t, oldCalls, []string{"ListAccessKeys"})
+	assertString(t, cp.Cred.ID, accessKeyID)
+}
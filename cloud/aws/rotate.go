@@ -0,0 +1,496 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/buzzsurfr/cloudkey/cloud"
+)
+
+// clientsForCredential creates IAM and STS clients that authenticate with the
+// credential. Tests replace it to inject mocked clients.
+var clientsForCredential = func(p *Profile, cred Credential) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+	creds := credentials.NewStaticCredentials(cred.AccessKeyID, cred.SecretAccessKey, cred.SessionToken)
+	var sess *session.Session
+	if p.Session != nil {
+		// Keep the region and other settings of the profile's session
+		sess = p.Session.Copy(&aws.Config{Credentials: creds})
+	} else {
+		var err error
+		sess, err = session.NewSession(&aws.Config{Credentials: creds})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return iam.New(sess), sts.New(sess), nil
+}
+
+// RotateOptions configures a key rotation. Zero values use the defaults.
+type RotateOptions struct {
+	// VerifyTimeout is how long to wait for the new access key to be accepted
+	VerifyTimeout time.Duration
+	// VerifyInterval is the delay before retrying verification. It doubles
+	// after every failed attempt, up to VerifyMaxInterval.
+	VerifyInterval    time.Duration
+	VerifyMaxInterval time.Duration
+	// SecondKey decides what to do when the user already has two access keys
+	SecondKey SecondKeyPolicy
+	// LocalKeys maps the access key IDs used by local profiles to the profile
+	// names, so that a key still in use is never deleted to make room
+	LocalKeys map[string]string
+	// Journal is the path of the journal that records the rotation's progress.
+	// No journal is written when it is empty.
+	Journal string
+	// Grace only deactivates the old access key, which is recorded in
+	// GraceFile so that it can be deleted with PruneKey once this much time
+	// has passed. Zero deletes the old access key right away.
+	Grace     time.Duration
+	GraceFile string
+	// Watch keeps checking the deactivated old access key for use this long
+	// before it is deleted, every WatchInterval. OnUse decides what happens
+	// if it is used. Zero does not watch.
+	Watch         time.Duration
+	WatchInterval time.Duration
+	OnUse         WatchPolicy
+	// PreRotate runs before anything is changed. An error stops the rotation.
+	PreRotate func(Rotation) error
+	// PostRotate runs once the new access key is in use locally, before the
+	// old access key is deactivated. An error rolls the rotation back.
+	PostRotate func(Rotation, Credential) error
+	// MFATokenCode, if set, is asked for a code of the MFA device with the
+	// serial number, for IAM users whose policies require MFA. The IAM calls
+	// are then made with a session token from GetSessionToken, while the new
+	// access key is still verified on its own.
+	MFATokenCode func(serial string) (string, error)
+	// MinAge skips the rotation if the access key is younger, going by its
+	// CreateDate. Zero always rotates.
+	MinAge time.Duration
+	// Account, if set, refuses to rotate an access key of any other AWS account
+	Account string
+	// Deliver hands a new credential to the user when cloudkey cannot save
+	// it itself, which is the case for the EnvironmentVariable source: cloudkey
+	// cannot change the environment of the shell that started it. It is also
+	// called with the old credential if the rotation is rolled back. Rotating
+	// such a profile fails without it, and the old access key is only
+	// deactivated once it has succeeded.
+	Deliver func(Credential) error
+}
+
+func (o RotateOptions) withDefaults() RotateOptions {
+	v := o.verifyOptions().WithDefaults()
+	o.VerifyTimeout, o.VerifyInterval, o.VerifyMaxInterval = v.VerifyTimeout, v.VerifyInterval, v.VerifyMaxInterval
+	if o.WatchInterval <= 0 {
+		o.WatchInterval = DefaultWatchInterval
+	}
+	return o
+}
+
+// verifyOptions are the verify timings, as cloud.Poll takes them
+func (o RotateOptions) verifyOptions() cloud.RotateOptions {
+	return cloud.RotateOptions{VerifyTimeout: o.VerifyTimeout, VerifyInterval: o.VerifyInterval, VerifyMaxInterval: o.VerifyMaxInterval}
+}
+
+// Rotation is the result of rotating the access key of a profile
+type Rotation struct {
+	Profile  string
+	UserName string
+	OldKeyID string
+	NewKeyID string
+	Started  time.Time
+	Finished time.Time
+	// RemovedKeyID is the user's other access key, deleted to make room
+	RemovedKeyID string
+	// RolledBack is set when the new access key was deleted after a failure
+	RolledBack bool
+	// DeleteAfter is when the deactivated old access key is due to be
+	// deleted, if it was kept for a grace period
+	DeleteAfter time.Time
+	// OldKeyUse is the first use of the old access key while it was watched
+	OldKeyUse *KeyUse
+	// Reactivated is set when the old access key was reactivated because it
+	// was used while watched. It is not deleted then.
+	Reactivated bool
+	// OldKeyCreated is when the old access key was created
+	OldKeyCreated time.Time
+	// Skipped explains why the access key was not rotated, such as being
+	// younger than RotateOptions.MinAge. Nothing is changed if it is set.
+	Skipped string
+}
+
+// UserName gets the IAM user name from the profile's identity
+func (p *Profile) UserName() (string, error) {
+	resultArn, err := arn.Parse(aws.StringValue(p.Arn))
+	if err != nil {
+		return "", err
+	}
+
+	// Verify is a user
+	s := strings.Split(resultArn.Resource, "/")
+	if s[0] != "user" || len(s) < 2 {
+		return "", ErrUnsupportedIdentityType
+	}
+	return s[len(s)-1], nil
+}
+
+// RotateKey creates a new key and deletes the old key (using the new key).
+//
+// The new key is verified with STS before it is saved locally. Once the new key
+// is saved locally, the profile's IAM and STS clients are replaced with ones
+// that use the new key, and the old key is only deactivated after that. If a
+// step fails before the old key is deleted, the rotation is rolled back.
+//
+// Each step is recorded in the journal (if one is set) before it is attempted,
+// so an interrupted rotation can be finished or rolled back with Recover.
+func (p *Profile) RotateKey(opts RotateOptions) (Rotation, error) {
+	opts = opts.withDefaults()
+	r := Rotation{
+		Profile:  p.Name,
+		OldKeyID: p.Cred.AccessKeyID,
+		Started:  time.Now(),
+	}
+
+	// Only long-lived access keys can be rotated
+	if !p.Rotatable() {
+		return r, &NotRotatableError{Profile: p.Name, Kind: p.Kind}
+	}
+
+	if err := opts.checkDelivery(p); err != nil {
+		return r, err
+	}
+	if opts.Grace > 0 && opts.GraceFile == "" {
+		return r, &RotateError{Step: StepGrace, Err: ErrNoGraceFile}
+	}
+
+	// Refuse to start over an interrupted rotation, whose new access key
+	// could look like a second access key
+	if opts.Journal != "" {
+		previous, err := ReadJournal(opts.Journal)
+		if err != nil {
+			return r, &RotateError{Step: StepJournal, Err: err}
+		}
+		if previous != nil {
+			return r, &RotateError{Step: StepJournal, Err: ErrRotationInProgress}
+		}
+	}
+
+	// Find the IAM user that owns the key
+	if p.Arn == nil {
+		if err := p.Lookup(); err != nil {
+			return r, &RotateError{Step: StepLookup, Err: err}
+		}
+	}
+	if opts.Account != "" && aws.StringValue(p.Account) != opts.Account {
+		return r, &RotateError{Step: StepLookup, Err: &AccountMismatchError{Want: opts.Account, Got: aws.StringValue(p.Account)}}
+	}
+	userName, err := p.UserName()
+	if err != nil {
+		return r, &RotateError{Step: StepLookup, Err: err}
+	}
+	r.UserName = userName
+
+	// Make the IAM calls with an MFA session if the user's policies require it
+	var mfaSerial string
+	if opts.MFATokenCode != nil {
+		mfaIAM, serial, err := mfaSession(p, p.STS, p.IAM, p.Config.MFASerial, userName, opts)
+		if err != nil {
+			return r, err
+		}
+		p.IAM, mfaSerial = mfaIAM, serial
+	}
+
+	// Make sure there is room for a second access key
+	keys, err := p.IAM.ListAccessKeys(&iam.ListAccessKeysInput{
+		UserName: aws.String(userName),
+	})
+	if err != nil {
+		return r, &RotateError{Step: StepListKeys, Err: err}
+	}
+
+	// Leave keys that are not due yet
+	for _, key := range keys.AccessKeyMetadata {
+		if aws.StringValue(key.AccessKeyId) == p.Cred.AccessKeyID {
+			r.OldKeyCreated = aws.TimeValue(key.CreateDate)
+		}
+	}
+	if opts.MinAge > 0 && !r.OldKeyCreated.IsZero() {
+		if age := r.Started.Sub(r.OldKeyCreated); age < opts.MinAge {
+			r.Skipped = fmt.Sprintf("access key is %s old, younger than %s", cloud.FormatAge(age), cloud.FormatAge(opts.MinAge))
+			return r, nil
+		}
+	}
+
+	if opts.PreRotate != nil {
+		if err := opts.PreRotate(r); err != nil {
+			return r, &RotateError{Step: StepPreRotate, Err: err}
+		}
+	}
+
+	if len(keys.AccessKeyMetadata) > 1 {
+		removed, err := p.makeRoom(userName, keys.AccessKeyMetadata, opts)
+		if err != nil {
+			return r, err
+		}
+		r.RemovedKeyID = removed
+	}
+
+	j := &Journal{
+		Path:      opts.Journal,
+		Profile:   p.Name,
+		Source:    p.Source,
+		File:      p.File,
+		Section:   p.section,
+		UserName:  userName,
+		OldCred:   p.Cred,
+		Grace:     opts.Grace,
+		Watch:     opts.Watch,
+		OnUse:     opts.OnUse,
+		MFASerial: mfaSerial,
+		Started:   r.Started,
+	}
+	if err := j.record(JournalStarted); err != nil {
+		return r, &RotateError{Step: StepJournal, Err: err}
+	}
+
+	// Create new access key
+	newAccessKey, err := p.IAM.CreateAccessKey(&iam.CreateAccessKeyInput{
+		UserName: aws.String(userName),
+	})
+	if err != nil {
+		j.remove()
+		return r, &RotateError{Step: StepCreateKey, Err: err}
+	}
+	var key iam.AccessKey
+	if newAccessKey.AccessKey != nil {
+		key = *newAccessKey.AccessKey
+	}
+	cred, err := FromAccessKey(key)
+	if err != nil {
+		// Without its secret the new access key is of no use, and the journal
+		// can't help recover delete it, so delete it now
+		if id := aws.StringValue(key.AccessKeyId); id != "" {
+			if derr := deleteKey(p.IAM, userName, id); derr != nil {
+				j.remove()
+				return r, &RotateError{Step: StepCreateKey, Err: fmt.Errorf("%v (rollback failed at %s: %v)", err, StepDeleteKey, derr)}
+			}
+			r.RolledBack = true
+		}
+		j.remove()
+		return r, &RotateError{Step: StepCreateKey, Err: err}
+	}
+	r.NewKeyID = cred.AccessKeyID
+	j.NewCred = cred
+
+	x := &rotation{p: p, j: j, opts: opts, oldIAM: p.IAM, oldSTS: p.STS}
+	if err := j.record(JournalCreated); err != nil {
+		return x.rollback(r, &RotateError{Step: StepJournal, Err: err})
+	}
+	x.newIAM, x.newSTS, err = clientsForCredential(p, cred)
+	if err != nil {
+		return x.rollback(r, &RotateError{Step: StepNewSession, Err: err})
+	}
+	if opts.MFATokenCode != nil {
+		// The new access key has no MFA session, so keep using the old one's
+		x.newIAM = x.oldIAM
+	}
+	return x.resume(r)
+}
+
+// rotation carries the state of a key rotation between its steps
+type rotation struct {
+	p      *Profile
+	j      *Journal
+	opts   RotateOptions
+	oldIAM iamiface.IAMAPI
+	oldSTS stsiface.STSAPI
+	newIAM iamiface.IAMAPI
+	newSTS stsiface.STSAPI
+}
+
+// resume carries the rotation forward from the journal's step. If a step
+// fails before the old access key is deactivated, the rotation is rolled back.
+func (x *rotation) resume(r Rotation) (Rotation, error) {
+	j := x.j
+	switch j.Step {
+	case JournalCreated:
+		// Wait until the new access key is accepted
+		if err := verifyCredential(x.newSTS, x.opts); err != nil {
+			return x.rollback(r, &RotateError{Step: StepVerify, Err: err})
+		}
+		fallthrough
+	case JournalUpdated:
+		// Save cred to profile
+		if err := j.record(JournalUpdated); err != nil {
+			return x.rollback(r, &RotateError{Step: StepJournal, Err: err})
+		}
+		if err := x.updateCredential(j.NewCred); err != nil {
+			return x.rollback(r, err)
+		}
+		x.p.IAM, x.p.STS = x.newIAM, x.newSTS
+		if x.opts.PostRotate != nil {
+			if err := x.opts.PostRotate(r, j.NewCred); err != nil {
+				return x.rollback(r, &RotateError{Step: StepPostRotate, Err: err})
+			}
+		}
+		fallthrough
+	case JournalDeactivated:
+		// Deactivate old access key using new access key
+		if err := j.record(JournalDeactivated); err != nil {
+			return x.rollback(r, &RotateError{Step: StepJournal, Err: err})
+		}
+		_, err := x.newIAM.UpdateAccessKey(&iam.UpdateAccessKeyInput{
+			AccessKeyId: aws.String(j.OldCred.AccessKeyID),
+			Status:      aws.String(iam.StatusTypeInactive),
+			UserName:    aws.String(j.UserName),
+		})
+		if err != nil && !isNoSuchEntity(err) {
+			// The old access key is still active, so there is nothing to reactivate
+			j.Step = JournalUpdated
+			return x.rollback(r, &RotateError{Step: StepDeactivateKey, Err: err})
+		}
+
+		// Watch for anything still using the old access key
+		if j.Watch > 0 {
+			use, err := watchKey(x.newIAM, j.OldCred.AccessKeyID, j.Updated, j.Watch, x.opts.WatchInterval)
+			if err != nil {
+				return r, &RotateError{Step: StepWatch, Err: err}
+			}
+			r.OldKeyUse = use
+			if use != nil && j.OnUse == WatchReactivate {
+				_, err := x.newIAM.UpdateAccessKey(&iam.UpdateAccessKeyInput{
+					AccessKeyId: aws.String(j.OldCred.AccessKeyID),
+					Status:      aws.String(iam.StatusTypeActive),
+					UserName:    aws.String(j.UserName),
+				})
+				if err != nil {
+					return r, &RotateError{Step: StepDeactivateKey, Err: err}
+				}
+				r.Reactivated = true
+				return x.finish(r)
+			}
+		}
+
+		// Delete old access key using new access key, or keep it deactivated
+		// for the grace period. The new access key is already in use, so a
+		// failure here is left in the journal to recover.
+		if j.Grace > 0 {
+			key := GraceKey{
+				Profile:     j.Profile,
+				Source:      j.Source,
+				UserName:    j.UserName,
+				AccessKeyID: j.OldCred.AccessKeyID,
+				Deactivated: time.Now(),
+			}
+			key.DeleteAfter = key.Deactivated.Add(j.Grace)
+			if err := addGraceKey(x.opts.GraceFile, key); err != nil {
+				return r, &RotateError{Step: StepGrace, Err: err}
+			}
+			r.DeleteAfter = key.DeleteAfter
+		} else if err := deleteKey(x.newIAM, j.UserName, j.OldCred.AccessKeyID); err != nil {
+			return r, &RotateError{Step: StepDeleteKey, Err: err}
+		}
+	}
+
+	return x.finish(r)
+}
+
+// finish removes the journal of a finished rotation
+func (x *rotation) finish(r Rotation) (Rotation, error) {
+	if err := x.j.remove(); err != nil {
+		return r, &RotateError{Step: StepJournal, Err: err}
+	}
+	r.Finished = time.Now()
+	return r, nil
+}
+
+// updateCredential saves the credential locally and delivers it to the user
+// if the profile's source needs it
+func (x *rotation) updateCredential(cred Credential) *RotateError {
+	if err := x.p.UpdateCredential(cred); err != nil {
+		return &RotateError{Step: StepUpdateCredential, Err: err}
+	}
+	if x.p.Source == "EnvironmentVariable" {
+		if err := x.opts.Deliver(cred); err != nil {
+			return &RotateError{Step: StepDeliver, Err: err}
+		}
+	}
+	return nil
+}
+
+// checkDelivery makes sure a new credential for the profile will reach the user
+func (o RotateOptions) checkDelivery(p *Profile) error {
+	if p.Source == "EnvironmentVariable" && o.Deliver == nil {
+		return &RotateError{Step: StepDeliver, Err: ErrNoDelivery}
+	}
+	return nil
+}
+
+// rollback undoes as much of the rotation as the journal's step requires: the
+// old access key is reactivated, the old credential is saved locally again and
+// the new access key is deleted using the old access key. The journal is kept
+// if any of that fails, so it can be retried with recover.
+func (x *rotation) rollback(r Rotation, cause *RotateError) (Rotation, error) {
+	j := x.j
+	fail := func(step string, err error) (Rotation, error) {
+		if cause == nil {
+			return r, &RotateError{Step: step, Err: err}
+		}
+		return r, &RotateError{Step: cause.Step, Err: fmt.Errorf("%v (rollback failed at %s: %v)", cause.Err, step, err)}
+	}
+
+	switch j.Step {
+	case JournalDeactivated:
+		_, err := x.newIAM.UpdateAccessKey(&iam.UpdateAccessKeyInput{
+			AccessKeyId: aws.String(j.OldCred.AccessKeyID),
+			Status:      aws.String(iam.StatusTypeActive),
+			UserName:    aws.String(j.UserName),
+		})
+		if isNoSuchEntity(err) {
+			return fail(StepDeactivateKey, ErrCannotRollBack)
+		}
+		if err != nil {
+			return fail(StepDeactivateKey, err)
+		}
+		fallthrough
+	case JournalUpdated:
+		if err := x.updateCredential(j.OldCred); err != nil {
+			return fail(err.Step, err.Err)
+		}
+		x.p.IAM, x.p.STS = x.oldIAM, x.oldSTS
+		fallthrough
+	case JournalCreated:
+		if err := deleteKey(x.oldIAM, j.UserName, j.NewCred.AccessKeyID); err != nil {
+			return fail(StepDeleteKey, err)
+		}
+	}
+
+	if err := j.remove(); err != nil {
+		return fail(StepJournal, err)
+	}
+	r.RolledBack = true
+	if cause == nil {
+		return r, nil
+	}
+	return r, cause
+}
+
+// verifyCredential polls STS with exponential backoff until the credential
+// behind the client is accepted or the timeout has passed
+func verifyCredential(svc stsiface.STSAPI, opts RotateOptions) error {
+	accepted := cloud.Poll(opts.verifyOptions(), func() bool {
+		_, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		return err == nil
+	})
+	if !accepted {
+		return ErrKeyNotAccepted
+	}
+	return nil
+}
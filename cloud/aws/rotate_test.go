@@ -0,0 +1,434 @@
+package aws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+const (
+	newAccessKeyID     = "AKIAI44QH8DHBEXAMPLE"
+	newSecretAccessKey = "je7MtGbClwBF/2Zp9Utk/h3yCo8nvbEXAMPLEKEY"
+)
+
+type mockedIAM struct {
+	iamiface.IAMAPI
+	Keys      []*iam.AccessKeyMetadata
+	CreateErr error
+	// NoSecret leaves the secret out of created access keys
+	NoSecret  bool
+	UpdateErr error
+	DeleteErr error
+	LastUsed  map[string]time.Time
+	MFASerial string
+	Calls     *[]string
+}
+
+func (m mockedIAM) record(call string) {
+	if m.Calls != nil {
+		*m.Calls = append(*m.Calls, call)
+	}
+}
+
+func (m mockedIAM) ListAccessKeys(*iam.ListAccessKeysInput) (*iam.ListAccessKeysOutput, error) {
+	m.record("ListAccessKeys")
+	return &iam.ListAccessKeysOutput{AccessKeyMetadata: m.Keys}, nil
+}
+
+func (m mockedIAM) ListMFADevices(*iam.ListMFADevicesInput) (*iam.ListMFADevicesOutput, error) {
+	m.record("ListMFADevices")
+	out := &iam.ListMFADevicesOutput{}
+	if m.MFASerial != "" {
+		out.MFADevices = []*iam.MFADevice{{SerialNumber: aws.String(m.MFASerial)}}
+	}
+	return out, nil
+}
+
+func (m mockedIAM) GetAccessKeyLastUsed(in *iam.GetAccessKeyLastUsedInput) (*iam.GetAccessKeyLastUsedOutput, error) {
+	out := &iam.GetAccessKeyLastUsedOutput{AccessKeyLastUsed: &iam.AccessKeyLastUsed{}}
+	if used, ok := m.LastUsed[aws.StringValue(in.AccessKeyId)]; ok {
+		out.AccessKeyLastUsed.LastUsedDate = aws.Time(used)
+		out.AccessKeyLastUsed.ServiceName = aws.String("s3")
+		out.AccessKeyLastUsed.Region = aws.String("us-east-1")
+	}
+	return out, nil
+}
+
+func (m mockedIAM) CreateAccessKey(in *iam.CreateAccessKeyInput) (*iam.CreateAccessKeyOutput, error) {
+	m.record("CreateAccessKey")
+	if m.CreateErr != nil {
+		return nil, m.CreateErr
+	}
+	key := &iam.AccessKey{
+		AccessKeyId:     aws.String(newAccessKeyID),
+		CreateDate:      aws.Time(time.Now()),
+		SecretAccessKey: aws.String(newSecretAccessKey),
+		Status:          aws.String(iam.StatusTypeActive),
+		UserName:        in.UserName,
+	}
+	if m.NoSecret {
+		key.SecretAccessKey = nil
+	}
+	return &iam.CreateAccessKeyOutput{AccessKey: key}, nil
+}
+
+func (m mockedIAM) UpdateAccessKey(in *iam.UpdateAccessKeyInput) (*iam.UpdateAccessKeyOutput, error) {
+	m.record("UpdateAccessKey " + aws.StringValue(in.AccessKeyId) + " " + aws.StringValue(in.Status))
+	return &iam.UpdateAccessKeyOutput{}, m.UpdateErr
+}
+
+func (m mockedIAM) DeleteAccessKey(in *iam.DeleteAccessKeyInput) (*iam.DeleteAccessKeyOutput, error) {
+	m.record("DeleteAccessKey " + aws.StringValue(in.AccessKeyId))
+	return &iam.DeleteAccessKeyOutput{}, m.DeleteErr
+}
+
+// flakySTS fails GetCallerIdentity a number of times before succeeding
+type flakySTS struct {
+	stsiface.STSAPI
+	Failures int
+	Calls    *int
+}
+
+func (m flakySTS) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	*m.Calls++
+	if *m.Calls <= m.Failures {
+		return nil, awserr.New("InvalidClientTokenId", "The security token included in the request is invalid.", nil)
+	}
+	resp := userIdentity()
+	return &resp, nil
+}
+
+var fastVerify = RotateOptions{
+	VerifyTimeout:     50 * time.Millisecond,
+	VerifyInterval:    time.Millisecond,
+	VerifyMaxInterval: 2 * time.Millisecond,
+	Deliver:           func(Credential) error { return nil },
+}
+
+func userIdentity() sts.GetCallerIdentityOutput {
+	return sts.GetCallerIdentityOutput{
+		Account: aws.String(accountID),
+		Arn:     aws.String("arn:aws:iam::123456789012:user/defaultUser"),
+		UserId:  aws.String(accessKeyID),
+	}
+}
+
+func rotateProfile(newIAM mockedIAM, oldIAM mockedIAM) Profile {
+	return rotateProfileWithSTS(newIAM, oldIAM, mockedSTS{Resp: userIdentity()})
+}
+
+func rotateProfileWithSTS(newIAM mockedIAM, oldIAM mockedIAM, newSTS stsiface.STSAPI) Profile {
+	clientsForCredential = func(*Profile, Credential) (iamiface.IAMAPI, stsiface.STSAPI, error) {
+		return newIAM, newSTS, nil
+	}
+	return Profile{
+		Name:  profileName,
+		Cloud: "aws",
+		Cred: Credential{
+			AccessKeyID:     accessKeyID,
+			SecretAccessKey: secretAccessKey,
+		},
+		Source: "EnvironmentVariable",
+		STS:    mockedSTS{Resp: userIdentity()},
+		IAM:    oldIAM,
+	}
+}
+
+func TestUserName(t *testing.T) {
+	t.Run("user", func(t *testing.T) {
+		p := Profile{GetCallerIdentityOutput: userIdentity()}
+		got, err := p.UserName()
+
+		assertString(t, got, "defaultUser")
+		assertNoError(t, err)
+	})
+	t.Run("user with path", func(t *testing.T) {
+		p := Profile{GetCallerIdentityOutput: sts.GetCallerIdentityOutput{
+			Arn: aws.String("arn:aws:iam::123456789012:user/division/team/pathUser"),
+		}}
+		got, err := p.UserName()
+
+		assertString(t, got, "pathUser")
+		assertNoError(t, err)
+	})
+	t.Run("fail on assumed role", func(t *testing.T) {
+		p := Profile{GetCallerIdentityOutput: sts.GetCallerIdentityOutput{
+			Arn: aws.String("arn:aws:sts::123456789012:assumed-role/default-role/session"),
+		}}
+		_, err := p.UserName()
+
+		assertError(t, err, ErrUnsupportedIdentityType)
+	})
+}
+
+func TestRotateKey(t *testing.T) {
+	oneKey := []*iam.AccessKeyMetadata{{
+		AccessKeyId: aws.String(accessKeyID),
+		Status:      aws.String(iam.StatusTypeActive),
+		UserName:    aws.String("defaultUser"),
+	}}
+
+	t.Run("successful rotation", func(t *testing.T) {
+		var oldCalls, newCalls []string
+		p := rotateProfile(mockedIAM{Calls: &newCalls}, mockedIAM{Keys: oneKey, Calls: &oldCalls})
+
+		got, err := p.RotateKey(fastVerify)
+
+		assertNoError(t, err)
+		assertString(t, got.UserName, "defaultUser")
+		assertString(t, got.OldKeyID, accessKeyID)
+		assertString(t, got.NewKeyID, newAccessKeyID)
+		assertString(t, p.Cred.AccessKeyID, newAccessKeyID)
+		assertCalls(t, oldCalls, []string{"ListAccessKeys", "CreateAccessKey"})
+		assertCalls(t, newCalls, []string{
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		})
+	})
+	t.Run("fail on too many access keys", func(t *testing.T) {
+		var calls []string
+		twoKeys := append(oneKey, &iam.AccessKeyMetadata{AccessKeyId: aws.String(newAccessKeyID)})
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: twoKeys, Calls: &calls})
+
+		_, err := p.RotateKey(fastVerify)
+
+		assertRotateError(t, err, StepListKeys, ErrTooManyAccessKeys)
+		assertCalls(t, calls, []string{"ListAccessKeys"})
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+	})
+	t.Run("fail on create access key", func(t *testing.T) {
+		limit := awserr.New(iam.ErrCodeLimitExceededException, "limit exceeded", nil)
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, CreateErr: limit})
+
+		_, err := p.RotateKey(fastVerify)
+
+		assertRotateError(t, err, StepCreateKey, limit)
+		if code := err.(*RotateError).Code(); code != iam.ErrCodeLimitExceededException {
+			t.Errorf("got code %q, want %q", code, iam.ErrCodeLimitExceededException)
+		}
+	})
+	t.Run("roll back on deactivate old access key", func(t *testing.T) {
+		denied := errors.New("AccessDenied")
+		var oldCalls, newCalls []string
+		p := rotateProfile(mockedIAM{UpdateErr: denied, Calls: &newCalls}, mockedIAM{Keys: oneKey, Calls: &oldCalls})
+
+		got, err := p.RotateKey(fastVerify)
+
+		assertRotateError(t, err, StepDeactivateKey, denied)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+		assertCalls(t, newCalls, []string{"UpdateAccessKey " + accessKeyID + " Inactive"})
+		assertCalls(t, oldCalls, []string{"ListAccessKeys", "CreateAccessKey", "DeleteAccessKey " + newAccessKeyID})
+	})
+	t.Run("wait for new access key to propagate", func(t *testing.T) {
+		var verifyCalls int
+		var newCalls []string
+		p := rotateProfileWithSTS(mockedIAM{Calls: &newCalls}, mockedIAM{Keys: oneKey}, flakySTS{Failures: 3, Calls: &verifyCalls})
+
+		_, err := p.RotateKey(fastVerify)
+
+		assertNoError(t, err)
+		if verifyCalls != 4 {
+			t.Errorf("got %d verification attempts, want 4", verifyCalls)
+		}
+		assertCalls(t, newCalls, []string{
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		})
+	})
+	t.Run("roll back when new access key is never accepted", func(t *testing.T) {
+		var verifyCalls int
+		var oldCalls, newCalls []string
+		p := rotateProfileWithSTS(mockedIAM{Calls: &newCalls}, mockedIAM{Keys: oneKey, Calls: &oldCalls}, flakySTS{Failures: 1000, Calls: &verifyCalls})
+
+		got, err := p.RotateKey(fastVerify)
+
+		assertRotateError(t, err, StepVerify, ErrKeyNotAccepted)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+		assertCalls(t, oldCalls, []string{"ListAccessKeys", "CreateAccessKey", "DeleteAccessKey " + newAccessKeyID})
+		assertCalls(t, newCalls, []string{})
+	})
+	t.Run("deliver new access key before deactivating the old one", func(t *testing.T) {
+		var calls []string
+		p := rotateProfile(mockedIAM{Calls: &calls}, mockedIAM{Keys: oneKey})
+		opts := fastVerify
+		opts.Deliver = func(cred Credential) error {
+			calls = append(calls, "Deliver "+cred.AccessKeyID)
+			return nil
+		}
+
+		_, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertCalls(t, calls, []string{
+			"Deliver " + newAccessKeyID,
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		})
+	})
+	t.Run("fail on environment variables without delivery", func(t *testing.T) {
+		var calls []string
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, Calls: &calls})
+		opts := fastVerify
+		opts.Deliver = nil
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepDeliver, ErrNoDelivery)
+		assertCalls(t, calls, []string{})
+	})
+	t.Run("roll back and deliver old access key when delivery fails", func(t *testing.T) {
+		closed := errors.New("write |1: broken pipe")
+		var delivered []string
+		var oldCalls, newCalls []string
+		p := rotateProfile(mockedIAM{Calls: &newCalls}, mockedIAM{Keys: oneKey, Calls: &oldCalls})
+		opts := fastVerify
+		opts.Deliver = func(cred Credential) error {
+			delivered = append(delivered, cred.AccessKeyID)
+			if cred.AccessKeyID == newAccessKeyID {
+				return closed
+			}
+			return nil
+		}
+
+		got, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepDeliver, closed)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertCalls(t, delivered, []string{newAccessKeyID, accessKeyID})
+		assertCalls(t, newCalls, []string{})
+		assertCalls(t, oldCalls, []string{"ListAccessKeys", "CreateAccessKey", "DeleteAccessKey " + newAccessKeyID})
+	})
+	t.Run("skip access key younger than the minimum age", func(t *testing.T) {
+		var calls []string
+		young := []*iam.AccessKeyMetadata{{
+			AccessKeyId: aws.String(accessKeyID),
+			CreateDate:  aws.Time(time.Now().AddDate(0, 0, -12)),
+		}}
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: young, Calls: &calls})
+		opts := fastVerify
+		opts.MinAge = 90 * 24 * time.Hour
+
+		got, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertString(t, got.Skipped, "access key is 12 days old, younger than 90 days")
+		assertCalls(t, calls, []string{"ListAccessKeys"})
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+	})
+	t.Run("rotate access key older than the minimum age", func(t *testing.T) {
+		old := []*iam.AccessKeyMetadata{{
+			AccessKeyId: aws.String(accessKeyID),
+			CreateDate:  aws.Time(time.Now().AddDate(0, 0, -91)),
+		}}
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: old})
+		opts := fastVerify
+		opts.MinAge = 90 * 24 * time.Hour
+
+		got, err := p.RotateKey(opts)
+
+		assertNoError(t, err)
+		assertString(t, got.Skipped, "")
+		assertString(t, got.NewKeyID, newAccessKeyID)
+	})
+	t.Run("pre-rotate hook vetoes the rotation", func(t *testing.T) {
+		vetoed := errors.New("exit status 1")
+		var calls []string
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, Calls: &calls})
+		opts := fastVerify
+		opts.PreRotate = func(r Rotation) error {
+			assertString(t, r.UserName, "defaultUser")
+			return vetoed
+		}
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepPreRotate, vetoed)
+		assertCalls(t, calls, []string{"ListAccessKeys"})
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+	})
+	t.Run("roll back when post-rotate hook fails", func(t *testing.T) {
+		failed := errors.New("exit status 2")
+		var hooked []string
+		var oldCalls, newCalls []string
+		p := rotateProfile(mockedIAM{Calls: &newCalls}, mockedIAM{Keys: oneKey, Calls: &oldCalls})
+		opts := fastVerify
+		opts.PostRotate = func(r Rotation, cred Credential) error {
+			hooked = append(hooked, r.OldKeyID+" "+cred.AccessKeyID)
+			return failed
+		}
+
+		got, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepPostRotate, failed)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+		assertCalls(t, hooked, []string{accessKeyID + " " + newAccessKeyID})
+		assertString(t, p.Cred.AccessKeyID, accessKeyID)
+		assertCalls(t, newCalls, []string{})
+		assertCalls(t, oldCalls, []string{"ListAccessKeys", "CreateAccessKey", "DeleteAccessKey " + newAccessKeyID})
+	})
+	t.Run("fail on access key of another account", func(t *testing.T) {
+		var calls []string
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey, Calls: &calls})
+		opts := fastVerify
+		opts.Account = "210987654321"
+
+		_, err := p.RotateKey(opts)
+
+		assertRotateError(t, err, StepLookup, &AccountMismatchError{Want: "210987654321", Got: accountID})
+		assertCalls(t, calls, []string{})
+	})
+	t.Run("fail on assumed role", func(t *testing.T) {
+		p := rotateProfile(mockedIAM{}, mockedIAM{Keys: oneKey})
+		p.STS = mockedSTS{Resp: sts.GetCallerIdentityOutput{
+			Arn: aws.String("arn:aws:sts::123456789012:assumed-role/default-role/session"),
+		}}
+
+		_, err := p.RotateKey(fastVerify)
+
+		assertRotateError(t, err, StepLookup, ErrUnsupportedIdentityType)
+	})
+}
+
+func assertRotateError(t *testing.T, got error, step string, want error) {
+	t.Helper()
+	rerr, ok := got.(*RotateError)
+	if !ok {
+		t.Fatalf("got %T (%v), want *RotateError", got, got)
+	}
+	if rerr.Step != step {
+		t.Errorf("got step %q, want %q", rerr.Step, step)
+	}
+	if rerr.Err.Error() != want.Error() {
+		t.Errorf("got %q, want %q", rerr.Err, want)
+	}
+}
+
+func assertCalls(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got calls %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got calls %q, want %q", got, want)
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// SecondKeyPolicy decides what to do when the IAM user already has two access
+// keys, since IAM users can't have more than two
+type SecondKeyPolicy string
+
+// Policies for a second access key
+const (
+	// SecondKeyFail stops the rotation
+	SecondKeyFail SecondKeyPolicy = "fail"
+	// SecondKeyDeleteInactive deletes the other access key if it is inactive
+	SecondKeyDeleteInactive SecondKeyPolicy = "delete-inactive"
+	// SecondKeyDeleteOldestUnused deletes the other access key that was used
+	// least recently, unless it is active and used by another local profile
+	SecondKeyDeleteOldestUnused SecondKeyPolicy = "delete-oldest-unused"
+)
+
+// ParseSecondKeyPolicy converts a policy name to a SecondKeyPolicy
+func ParseSecondKeyPolicy(s string) (SecondKeyPolicy, error) {
+	switch policy := SecondKeyPolicy(s); policy {
+	case SecondKeyFail, SecondKeyDeleteInactive, SecondKeyDeleteOldestUnused:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Unknown second key policy %q (one of %s, %s, %s)", s, SecondKeyFail, SecondKeyDeleteInactive, SecondKeyDeleteOldestUnused)
+}
+
+// makeRoom deletes one of the user's other access keys according to the
+// policy, so that a new access key can be created. It returns the ID of the
+// deleted access key.
+func (p *Profile) makeRoom(userName string, keys []*iam.AccessKeyMetadata, opts RotateOptions) (string, error) {
+	var others []*iam.AccessKeyMetadata
+	for _, key := range keys {
+		if aws.StringValue(key.AccessKeyId) != p.Cred.AccessKeyID {
+			others = append(others, key)
+		}
+	}
+
+	var victim *iam.AccessKeyMetadata
+	switch opts.SecondKey {
+	case SecondKeyDeleteInactive:
+		for _, key := range others {
+			if aws.StringValue(key.Status) == iam.StatusTypeInactive {
+				victim = key
+				break
+			}
+		}
+		if victim == nil {
+			return "", &RotateError{Step: StepListKeys, Err: ErrSecondKeyActive}
+		}
+	case SecondKeyDeleteOldestUnused:
+		var oldest time.Time
+		for _, key := range others {
+			lastUsed, err := p.IAM.GetAccessKeyLastUsed(&iam.GetAccessKeyLastUsedInput{
+				AccessKeyId: key.AccessKeyId,
+			})
+			if err != nil {
+				return "", &RotateError{Step: StepLastUsed, Err: err}
+			}
+			// Keys that were never used count from their creation
+			used := aws.TimeValue(key.CreateDate)
+			if lastUsed.AccessKeyLastUsed != nil && lastUsed.AccessKeyLastUsed.LastUsedDate != nil {
+				used = aws.TimeValue(lastUsed.AccessKeyLastUsed.LastUsedDate)
+			}
+			if victim == nil || used.Before(oldest) {
+				victim, oldest = key, used
+			}
+		}
+		if victim == nil {
+			return "", &RotateError{Step: StepListKeys, Err: ErrTooManyAccessKeys}
+		}
+		if aws.StringValue(victim.Status) == iam.StatusTypeActive {
+			if name, ok := opts.LocalKeys[aws.StringValue(victim.AccessKeyId)]; ok {
+				return "", &RotateError{Step: StepListKeys, Err: &SecondKeyInUseError{AccessKeyID: aws.StringValue(victim.AccessKeyId), Profile: name}}
+			}
+		}
+	default:
+		return "", &RotateError{Step: StepListKeys, Err: ErrTooManyAccessKeys}
+	}
+
+	if err := deleteKey(p.IAM, userName, aws.StringValue(victim.AccessKeyId)); err != nil {
+		return "", &RotateError{Step: StepDeleteKey, Err: err}
+	}
+	return aws.StringValue(victim.AccessKeyId), nil
+}
+
+// LocalAccessKeys maps the access key IDs found in the environment variables
+// and the credentials file to the names of the profiles that use them
+func LocalAccessKeys() map[string]string {
+	keys := make(map[string]string)
+	if p, err := FromEnviron(); err == nil {
+		keys[p.Cred.AccessKeyID] = p.Name
+	}
+	if profiles, err := FromConfigFile(false); err == nil {
+		for _, p := range profiles.Profiles {
+			if p.Cred.AccessKeyID != "" {
+				keys[p.Cred.AccessKeyID] = p.Name
+			}
+		}
+	}
+	return keys
+}
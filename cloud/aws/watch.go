@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+)
+
+// DefaultWatchInterval is how often a watched access key is checked for use
+const DefaultWatchInterval = time.Minute
+
+// WatchPolicy decides what happens when the deactivated old access key is
+// used while it is watched
+type WatchPolicy string
+
+// Watch policies
+const (
+	// WatchReport reports the use, then deletes the old access key as usual
+	WatchReport WatchPolicy = "report"
+	// WatchReactivate reactivates the old access key instead of deleting it
+	WatchReactivate WatchPolicy = "reactivate"
+)
+
+// ParseWatchPolicy checks the name of a watch policy. An empty name is WatchReport.
+func ParseWatchPolicy(s string) (WatchPolicy, error) {
+	switch WatchPolicy(s) {
+	case "", WatchReport:
+		return WatchReport, nil
+	case WatchReactivate:
+		return WatchReactivate, nil
+	}
+	return "", errors.New("Unknown watch policy " + s + ", must be 'report' or 'reactivate'")
+}
+
+// KeyUse is a use of an access key, as reported by GetAccessKeyLastUsed
+type KeyUse struct {
+	Date    time.Time
+	Service string
+	Region  string
+}
+
+// watchKey polls GetAccessKeyLastUsed until the access key is used after
+// since, or the window has passed. It returns nil if the key was not used.
+func watchKey(svc iamiface.IAMAPI, accessKeyID string, since time.Time, window, interval time.Duration) (*KeyUse, error) {
+	deadline := time.Now().Add(window)
+	for {
+		result, err := svc.GetAccessKeyLastUsed(&iam.GetAccessKeyLastUsedInput{
+			AccessKeyId: aws.String(accessKeyID),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if used := result.AccessKeyLastUsed; used != nil && aws.TimeValue(used.LastUsedDate).After(since) {
+			return &KeyUse{
+				Date:    aws.TimeValue(used.LastUsedDate),
+				Service: aws.StringValue(used.ServiceName),
+				Region:  aws.StringValue(used.Region),
+			}, nil
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			return nil, nil
+		}
+		time.Sleep(interval)
+	}
+}
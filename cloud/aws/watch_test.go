@@ -0,0 +1,72 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+func TestRotateKeyWatch(t *testing.T) {
+	oneKey := []*iam.AccessKeyMetadata{{AccessKeyId: aws.String(accessKeyID)}}
+	before := map[string]time.Time{accessKeyID: time.Now().Add(-time.Hour)}
+	after := map[string]time.Time{accessKeyID: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name     string
+		lastUsed map[string]time.Time
+		onUse    WatchPolicy
+		used     bool
+		calls    []string
+	}{
+		{"delete unused access key", before, WatchReactivate, false, []string{
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		}},
+		{"report use and delete", after, WatchReport, true, []string{
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"DeleteAccessKey " + accessKeyID,
+		}},
+		{"reactivate used access key", after, WatchReactivate, true, []string{
+			"UpdateAccessKey " + accessKeyID + " Inactive",
+			"UpdateAccessKey " + accessKeyID + " Active",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var newCalls []string
+			p := rotateProfile(mockedIAM{Calls: &newCalls, LastUsed: tt.lastUsed}, mockedIAM{Keys: oneKey})
+			opts := fastVerify
+			opts.Watch = 3 * time.Millisecond
+			opts.WatchInterval = time.Millisecond
+			opts.OnUse = tt.onUse
+
+			got, err := p.RotateKey(opts)
+
+			assertNoError(t, err)
+			assertCalls(t, newCalls, tt.calls)
+			if (got.OldKeyUse != nil) != tt.used {
+				t.Fatalf("got use %+v, want used %v", got.OldKeyUse, tt.used)
+			}
+			if tt.used {
+				assertString(t, got.OldKeyUse.Service, "s3")
+				assertString(t, got.OldKeyUse.Region, "us-east-1")
+			}
+			if got.Reactivated != (tt.used && tt.onUse == WatchReactivate) {
+				t.Errorf("got reactivated %v", got.Reactivated)
+			}
+		})
+	}
+}
+
+func TestParseWatchPolicy(t *testing.T) {
+	for _, s := range []string{"", "report", "reactivate"} {
+		if _, err := ParseWatchPolicy(s); err != nil {
+			t.Errorf("ParseWatchPolicy(%q) failed: %v", s, err)
+		}
+	}
+	if _, err := ParseWatchPolicy("ignore"); err == nil {
+		t.Error("ParseWatchPolicy(\"ignore\") should fail")
+	}
+}
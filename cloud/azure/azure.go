@@ -0,0 +1,355 @@
+// Package azure rotates the client secrets of Azure service principals, kept
+// in the AZURE_CLIENT_* environment variables or the Azure CLI's
+// service_principal_entries.json.
+package azure
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Name is the name of the Azure provider
+const Name = "azure"
+
+// SourceCLI is the Source of service principals logged in with the Azure CLI
+const SourceCLI = "AzureCLI"
+
+// Kinds of service principal credentials
+const (
+	KindClientSecret      = "client-secret"
+	KindClientCertificate = "client-certificate"
+)
+
+// DefaultSecretExpiry is how long a new client secret is valid by default
+const DefaultSecretExpiry = 180 * 24 * time.Hour
+
+// SecretExpiry is how long a new client secret is valid
+var SecretExpiry = DefaultSecretExpiry
+
+const (
+	entriesFileName = "service_principal_entries.json"
+	profileFileName = "azureProfile.json"
+	// entriesFileMode keeps the secrets private to the user
+	entriesFileMode = 0600
+)
+
+// Provider works with service principal client secrets
+type Provider struct{}
+
+func init() {
+	cloud.Register(Provider{})
+}
+
+// servicePrincipal is what a profile needs to sign in
+type servicePrincipal struct {
+	tenant   string
+	clientID string
+	secret   string
+}
+
+// Name is the name of the provider
+func (Provider) Name() string {
+	return Name
+}
+
+// Profiles finds the service principal in the environment variables and the
+// ones the Azure CLI logged in with. The current one is the environment's,
+// or else the one of the CLI's default subscription.
+func (Provider) Profiles() ([]cloud.Profile, error) {
+	var profiles []cloud.Profile
+	clientID, secret, tenant := os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_CLIENT_SECRET"), os.Getenv("AZURE_TENANT_ID")
+	if clientID != "" && tenant != "" && secret != "" {
+		profiles = append(profiles, profile("", cloud.SourceEnviron, "", true, KindClientSecret, servicePrincipal{tenant, clientID, secret}))
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return profiles, err
+	}
+	file := filepath.Join(dir, entriesFileName)
+	entries, err := readEntries(file)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	}
+	if err != nil {
+		return profiles, err
+	}
+	current := ""
+	if len(profiles) == 0 {
+		// The profile only tells which subscription is the default
+		current, _ = defaultServicePrincipal(filepath.Join(dir, profileFileName))
+	}
+	for _, e := range entries {
+		sp := servicePrincipal{tenant: e.tenant(), clientID: e.clientID(), secret: e.secret()}
+		kind := KindClientSecret
+		if sp.secret == "" {
+			kind = KindClientCertificate
+		}
+		profiles = append(profiles, profile(sp.clientID, SourceCLI, file, sp.clientID == current, kind, sp))
+	}
+	return profiles, nil
+}
+
+// profile makes a profile of a service principal. The user name and the ID
+// of the client secret are only known after Lookup.
+func profile(name, source, file string, isCurrent bool, kind string, sp servicePrincipal) cloud.Profile {
+	return cloud.Profile{
+		Cloud:     Name,
+		Name:      name,
+		Kind:      kind,
+		Account:   sp.tenant,
+		Cred:      cloud.Credential{Secret: sp.secret},
+		Source:    source,
+		File:      file,
+		IsCurrent: isCurrent,
+		Rotatable: kind == KindClientSecret,
+		Data:      servicePrincipal{tenant: sp.tenant, clientID: sp.clientID},
+	}
+}
+
+// configDir is the Azure CLI's configuration directory: $AZURE_CONFIG_DIR, or
+// ~/.azure
+func configDir() (string, error) {
+	if dir := os.Getenv("AZURE_CONFIG_DIR"); dir != "" {
+		return homedir.Expand(dir)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".azure"), nil
+}
+
+// entry is a service principal in service_principal_entries.json. Older
+// versions of the Azure CLI use other names for the same keys. Unknown keys
+// are kept when the file is written.
+type entry map[string]interface{}
+
+func (e entry) value(names ...string) string {
+	for _, name := range names {
+		if s, ok := e[name].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func (e entry) clientID() string { return e.value("client_id", "servicePrincipalId") }
+func (e entry) tenant() string   { return e.value("tenant", "servicePrincipalTenant") }
+func (e entry) secret() string   { return e.value("client_secret", "accessToken") }
+
+// readEntries reads the service principals logged in with the Azure CLI
+func readEntries(path string) ([]entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	if err := json.Unmarshal(bytes.TrimPrefix(data, utf8BOM), &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// utf8BOM starts the JSON files the Azure CLI writes on some systems
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// defaultServicePrincipal gets the client ID of the default subscription in
+// azureProfile.json, if a service principal is logged in to it
+func defaultServicePrincipal(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var profile struct {
+		Subscriptions []struct {
+			IsDefault bool `json:"isDefault"`
+			User      struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"user"`
+		} `json:"subscriptions"`
+	}
+	if err := json.Unmarshal(bytes.TrimPrefix(data, utf8BOM), &profile); err != nil {
+		return "", err
+	}
+	for _, s := range profile.Subscriptions {
+		if s.IsDefault && s.User.Type == "servicePrincipal" {
+			return s.User.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// principal gets the service principal of a profile
+func principal(p cloud.Profile) (servicePrincipal, error) {
+	sp, ok := p.Data.(servicePrincipal)
+	if !ok {
+		return sp, errors.New("Not an Azure profile")
+	}
+	sp.secret = p.Cred.Secret
+	return sp, nil
+}
+
+// Lookup asks Graph for the application's name, and finds the ID of the
+// client secret by its first characters
+func (Provider) Lookup(p *cloud.Profile) error {
+	sp, err := principal(*p)
+	if err != nil {
+		return err
+	}
+	var a app
+	if err := call(sp, "GET", application(sp.clientID)+"?$select=appId,displayName,passwordCredentials", nil, &a); err != nil {
+		return err
+	}
+	// Graph only shows the first three characters of each secret
+	var matches []passwordCredential
+	for _, c := range a.PasswordCredentials {
+		if len(sp.secret) >= 3 && c.Hint == sp.secret[:3] {
+			matches = append(matches, c)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("The local client secret is not one of application %s", sp.clientID)
+	case 1:
+	default:
+		return fmt.Errorf("%d client secrets of application %s start with %s, so cloudkey can't tell which one to replace", len(matches), sp.clientID, sp.secret[:3])
+	}
+	p.Cred.ID = matches[0].KeyID
+	p.UserName = a.DisplayName
+	if p.UserName == "" {
+		p.UserName = a.AppID
+	}
+	return nil
+}
+
+// Keys lists the client secrets of the application. Expired ones are inactive.
+func (Provider) Keys(p cloud.Profile) ([]cloud.Key, error) {
+	sp, err := principal(p)
+	if err != nil {
+		return nil, err
+	}
+	var a app
+	if err := call(sp, "GET", application(sp.clientID)+"?$select=passwordCredentials", nil, &a); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	keys := make([]cloud.Key, 0, len(a.PasswordCredentials))
+	for _, c := range a.PasswordCredentials {
+		keys = append(keys, cloud.Key{
+			ID:      c.KeyID,
+			Active:  c.EndDateTime.IsZero() || c.EndDateTime.After(now),
+			Created: c.StartDateTime,
+		})
+	}
+	return keys, nil
+}
+
+// CreateKey adds a client secret to the application that expires after
+// SecretExpiry
+func (Provider) CreateKey(p cloud.Profile) (cloud.Credential, error) {
+	sp, err := principal(p)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	now := time.Now().UTC()
+	in := map[string]interface{}{
+		"passwordCredential": map[string]interface{}{
+			"displayName": "cloudkey " + now.Format("2006-01-02"),
+			"endDateTime": now.Add(SecretExpiry).Truncate(time.Second),
+		},
+	}
+	var c passwordCredential
+	if err := call(sp, "POST", application(sp.clientID)+"/addPassword", in, &c); err != nil {
+		return cloud.Credential{}, err
+	}
+	return cloud.Credential{ID: c.KeyID, Secret: c.SecretText}, nil
+}
+
+// DeactivateKey is not supported, since client secrets can only be removed
+func (Provider) DeactivateKey(p cloud.Profile, id string) error {
+	return cloud.ErrNotSupported
+}
+
+// DeleteKey removes a client secret from the application
+func (Provider) DeleteKey(p cloud.Profile, id string) error {
+	sp, err := principal(p)
+	if err != nil {
+		return err
+	}
+	return call(sp, "POST", application(sp.clientID)+"/removePassword", map[string]string{"keyId": id}, nil)
+}
+
+// Verify checks whether the service principal can sign in with the secret
+func (Provider) Verify(p cloud.Profile, cred cloud.Credential) error {
+	sp, err := principal(p)
+	if err != nil {
+		return err
+	}
+	_, err = accessToken(sp.tenant, sp.clientID, cred.Secret)
+	return err
+}
+
+// WriteCredential saves the client secret in service_principal_entries.json,
+// or in AZURE_CLIENT_SECRET of this process
+func (Provider) WriteCredential(p *cloud.Profile, cred cloud.Credential) error {
+	sp, err := principal(*p)
+	if err != nil {
+		return err
+	}
+	switch p.Source {
+	case cloud.SourceEnviron:
+		os.Setenv("AZURE_CLIENT_SECRET", cred.Secret)
+	case SourceCLI:
+		if err := writeSecret(p.File, sp, cred.Secret); err != nil {
+			return err
+		}
+	}
+	p.Cred = cred
+	return nil
+}
+
+// EnvVars gives the variable holding the client secret
+func (Provider) EnvVars(cred cloud.Credential) []cloud.EnvVar {
+	return []cloud.EnvVar{{Name: "AZURE_CLIENT_SECRET", Value: cred.Secret}}
+}
+
+// writeSecret replaces the secret of a service principal in
+// service_principal_entries.json
+func writeSecret(path string, sp servicePrincipal, secret string) error {
+	entries, err := readEntries(path)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, e := range entries {
+		if e.clientID() != sp.clientID || e.tenant() != sp.tenant {
+			continue
+		}
+		key := "client_secret"
+		if _, ok := e["accessToken"]; ok {
+			key = "accessToken"
+		}
+		e[key] = secret
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%s: service principal %s is gone", path, sp.clientID)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, data, entriesFileMode)
+}
@@ -0,0 +1,85 @@
+package azure
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+)
+
+const (
+	tenantID  = "11111111-2222-3333-4444-555555555555"
+	clientID  = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	oldSecret = "abc8Q~oldsecretEXAMPLE"
+)
+
+// configDirWith writes the Azure CLI's files to a temporary directory and
+// points AZURE_CONFIG_DIR at it
+func configDirWith(t *testing.T, entries, profile string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{entriesFileName: entries, profileFileName: profile} {
+		if content == "" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("AZURE_CONFIG_DIR", dir)
+	for _, env := range []string{"AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID"} {
+		os.Unsetenv(env)
+	}
+	return dir, func() {
+		os.Unsetenv("AZURE_CONFIG_DIR")
+		for _, env := range []string{"AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID"} {
+			os.Unsetenv(env)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+const entriesJSON = `[{"client_id": "` + clientID + `", "tenant": "` + tenantID + `", "client_secret": "` + oldSecret + `"},` +
+	` {"servicePrincipalId": "cert-app", "servicePrincipalTenant": "` + tenantID + `", "certificateFile": "/certs/app.pem"}]`
+
+func TestProfiles(t *testing.T) {
+	profileJSON := "\xef\xbb\xbf" + `{"subscriptions": [{"isDefault": true, "user": {"name": "` + clientID + `", "type": "servicePrincipal"}}]}`
+	dir, cleanup := configDirWith(t, entriesJSON, profileJSON)
+	defer cleanup()
+	file := filepath.Join(dir, entriesFileName)
+
+	t.Run("Azure CLI", func(t *testing.T) {
+		got, err := Provider{}.Profiles()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		want := []cloud.Profile{
+			{Cloud: Name, Name: clientID, Kind: KindClientSecret, Account: tenantID, Cred: cloud.Credential{Secret: oldSecret}, Source: SourceCLI, File: file, IsCurrent: true, Rotatable: true, Data: servicePrincipal{tenant: tenantID, clientID: clientID}},
+			{Cloud: Name, Name: "cert-app", Kind: KindClientCertificate, Account: tenantID, Source: SourceCLI, File: file, Data: servicePrincipal{tenant: tenantID, clientID: "cert-app"}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v but want %+v", got, want)
+		}
+	})
+	t.Run("environment variables", func(t *testing.T) {
+		os.Setenv("AZURE_CLIENT_ID", clientID)
+		os.Setenv("AZURE_CLIENT_SECRET", "env~secret")
+		os.Setenv("AZURE_TENANT_ID", tenantID)
+
+		got, err := Provider{}.Profiles()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 3 || got[0].Source != cloud.SourceEnviron || !got[0].IsCurrent || got[1].IsCurrent {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
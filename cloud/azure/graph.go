@@ -0,0 +1,70 @@
+package azure
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/internal/httpapi"
+)
+
+// Endpoints of Microsoft Graph and the Microsoft identity platform. Tests,
+// and national clouds, point them elsewhere.
+var (
+	GraphURL = "https://graph.microsoft.com"
+	LoginURL = "https://login.microsoftonline.com"
+)
+
+// accessToken gets a Graph access token for a service principal with the
+// client credentials flow
+func accessToken(tenant, clientID, secret string) (string, error) {
+	endpoint := strings.TrimRight(LoginURL, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := httpapi.PostForm(endpoint, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {secret},
+		"scope":         {strings.TrimRight(GraphURL, "/") + "/.default"},
+	}, &token)
+	return token.AccessToken, err
+}
+
+// call calls Graph as the service principal and decodes the response into
+// out, unless out is nil
+func call(sp servicePrincipal, method, resource string, in, out interface{}) error {
+	token, err := accessToken(sp.tenant, sp.clientID, sp.secret)
+	if err != nil {
+		return err
+	}
+	req, err := httpapi.NewRequest(method, strings.TrimRight(GraphURL, "/")+"/v1.0/"+resource, in)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return httpapi.Do(req, out)
+}
+
+// application is the resource of the service principal's application
+func application(clientID string) string {
+	return "applications(appId='" + url.PathEscape(clientID) + "')"
+}
+
+// passwordCredential is a client secret of an application
+type passwordCredential struct {
+	KeyID         string    `json:"keyId"`
+	DisplayName   string    `json:"displayName"`
+	Hint          string    `json:"hint"`
+	StartDateTime time.Time `json:"startDateTime"`
+	EndDateTime   time.Time `json:"endDateTime"`
+	// SecretText is only set when the secret is added
+	SecretText string `json:"secretText,omitempty"`
+}
+
+// app is the part of an application cloudkey uses
+type app struct {
+	AppID               string               `json:"appId"`
+	DisplayName         string               `json:"displayName"`
+	PasswordCredentials []passwordCredential `json:"passwordCredentials"`
+}
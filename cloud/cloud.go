@@ -0,0 +1,196 @@
+// Package cloud is what cloudkey needs from a cloud provider: finding the local
+// credentials, asking the cloud who they belong to, managing their keys and
+// saving new ones locally. Each provider registers itself from its package's
+// init function, and Rotate replaces a key with any of them.
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credential is a key and its secret. What the secret holds depends on the
+// provider, such as a secret access key or a whole key file.
+type Credential struct {
+	ID     string
+	Secret string
+}
+
+// Profile is a local credential of any cloud provider
+type Profile struct {
+	Cloud string
+	Name  string
+	// Kind tells how the profile gets its credential, in the provider's terms
+	Kind   string
+	Region string
+	// Account is the account, project or tenancy the credential belongs to.
+	// It may only be known after Lookup.
+	Account string
+	// UserName is the user or service account the credential belongs to. It
+	// may only be known after Lookup.
+	UserName string
+	Cred     Credential
+	// Source is where the credential was found, like a file or the
+	// environment variables, and File the file holding it
+	Source string
+	File   string
+	// IsCurrent is set for the profile used by default
+	IsCurrent bool
+	// Rotatable is set if the profile has a key of its own
+	Rotatable bool
+	// Data is the provider's own view of the profile
+	Data interface{}
+}
+
+// Dedupe leaves out the profiles that share a key with an earlier profile,
+// going by the file and the ID of the credential, like a gcloud configuration
+// using the key file of GOOGLE_APPLICATION_CREDENTIALS. Rotating the key of
+// one of them rotates it for all.
+func Dedupe(profiles []Profile) []Profile {
+	type key struct{ file, id string }
+	seen := make(map[key]bool)
+	unique := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		if p.Cred.ID != "" {
+			k := key{file: p.File, id: p.Cred.ID}
+			if p.File != "" {
+				if abs, err := filepath.Abs(p.File); err == nil {
+					k.file = abs
+				}
+			}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		unique = append(unique, p)
+	}
+	return unique
+}
+
+// Key is a key of a user, as the cloud sees it
+type Key struct {
+	ID      string
+	Active  bool
+	Created time.Time
+}
+
+// Provider works with the credentials of one cloud
+type Provider interface {
+	// Name is the name given with --cloud, like "aws"
+	Name() string
+	// Profiles finds the local profiles. It may return the profiles it found
+	// along with an error for the ones it couldn't read.
+	Profiles() ([]Profile, error)
+	// Lookup asks the cloud for the account and user name of the profile. It
+	// also sets the ID of the credential if it can't be known locally.
+	Lookup(p *Profile) error
+	// Keys lists the keys of the profile's user
+	Keys(p Profile) ([]Key, error)
+	// CreateKey creates a new key for the profile's user
+	CreateKey(p Profile) (Credential, error)
+	// DeactivateKey turns a key off without deleting it. It returns
+	// ErrNotSupported if the cloud can't do that.
+	DeactivateKey(p Profile, id string) error
+	// DeleteKey deletes a key of the profile's user
+	DeleteKey(p Profile, id string) error
+	// Verify checks once whether the cloud accepts a credential for the
+	// profile's user
+	Verify(p Profile, cred Credential) error
+	// WriteCredential saves a credential in place of the profile's, where the
+	// profile was found. Later calls use the new credential.
+	WriteCredential(p *Profile, cred Credential) error
+}
+
+// SourceEnviron is the Source of profiles found in environment variables
+const SourceEnviron = "EnvironmentVariable"
+
+// EnvVar is an environment variable holding a credential
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// EnvironProvider is a Provider whose credentials can come from environment
+// variables. WriteCredential can only change cloudkey's own environment, so
+// the caller delivers the variables, for example as statements for the shell.
+type EnvironProvider interface {
+	Provider
+	// EnvVars gives the variables that hold the credential
+	EnvVars(cred Credential) []EnvVar
+}
+
+// Rotator is a Provider that rotates keys its own way, like AWS with its
+// journal, grace periods and MFA. Rotate hands the whole rotation to it.
+type Rotator interface {
+	Provider
+	// RotateKey replaces the key of a profile. Its own options come in
+	// RotateOptions.Options, and its own result goes in Rotation.Data.
+	RotateKey(p *Profile, opts RotateOptions) (Rotation, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]Provider)
+)
+
+// Register makes a provider available by its name. It panics if a provider
+// with the same name is already registered.
+func Register(p Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	if _, ok := providers[p.Name()]; ok {
+		panic("cloud: provider " + p.Name() + " registered twice")
+	}
+	providers[p.Name()] = p
+}
+
+// Get gets a registered provider by its name
+func Get(name string) (Provider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	if p, ok := providers[name]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("Unknown cloud %s, must be one of %s", name, strings.Join(names(), ", "))
+}
+
+// Providers lists the registered providers by name
+func Providers() []Provider {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	list := make([]Provider, 0, len(providers))
+	for _, name := range names() {
+		list = append(list, providers[name])
+	}
+	return list
+}
+
+// names lists the names of the registered providers in order. The caller
+// holds providersMu.
+func names() []string {
+	list := make([]string, 0, len(providers))
+	for name := range providers {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// ErrNotSupported is returned by a provider for something its cloud can't do
+var ErrNotSupported = errors.New("Not supported by this cloud")
+
+// ErrNotRotatable means the profile has no key of its own to rotate
+var ErrNotRotatable = errors.New("The profile has no key of its own to rotate")
+
+// ErrKeyNotAccepted means the cloud never accepted the new key
+var ErrKeyNotAccepted = errors.New("New key was not accepted before the timeout")
+
+// ErrNoDelivery means a new key in environment variables could not reach the
+// user's shell
+var ErrNoDelivery = errors.New("Environment variables can only be rotated if the new key is delivered, for example with eval \"$(cloudkey rotate)\" or --env-file")
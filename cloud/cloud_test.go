@@ -0,0 +1,343 @@
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeProvider keeps the keys of one user in memory
+type fakeProvider struct {
+	name        string
+	keys        map[string]Key
+	written     []string
+	verifyFails int
+	writeErr    error
+	deactivate  bool
+	calls       []string
+	next        int
+}
+
+func newFake(name string, keyCreated time.Time) *fakeProvider {
+	return &fakeProvider{
+		name:       name,
+		keys:       map[string]Key{"old": {ID: "old", Active: true, Created: keyCreated}},
+		deactivate: true,
+	}
+}
+
+func (f *fakeProvider) Name() string { return f.name }
+
+func (f *fakeProvider) Profiles() ([]Profile, error) {
+	return []Profile{{Cloud: f.name, Name: "default", Cred: Credential{ID: "old", Secret: "s0"}, Rotatable: true}}, nil
+}
+
+func (f *fakeProvider) Lookup(p *Profile) error {
+	p.Account, p.UserName = "project", "robot"
+	return nil
+}
+
+func (f *fakeProvider) Keys(p Profile) ([]Key, error) {
+	var keys []Key
+	for _, k := range f.keys {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (f *fakeProvider) CreateKey(p Profile) (Credential, error) {
+	f.next++
+	id := fmt.Sprintf("new%d", f.next)
+	f.keys[id] = Key{ID: id, Active: true, Created: time.Now()}
+	f.calls = append(f.calls, "create "+id)
+	return Credential{ID: id, Secret: "s" + id}, nil
+}
+
+func (f *fakeProvider) DeactivateKey(p Profile, id string) error {
+	if !f.deactivate {
+		return ErrNotSupported
+	}
+	f.calls = append(f.calls, "deactivate "+id+" with "+p.Cred.ID)
+	return nil
+}
+
+func (f *fakeProvider) DeleteKey(p Profile, id string) error {
+	f.calls = append(f.calls, "delete "+id+" with "+p.Cred.ID)
+	delete(f.keys, id)
+	return nil
+}
+
+func (f *fakeProvider) Verify(p Profile, cred Credential) error {
+	if f.verifyFails > 0 {
+		f.verifyFails--
+		return errors.New("not yet")
+	}
+	return nil
+}
+
+func (f *fakeProvider) WriteCredential(p *Profile, cred Credential) error {
+	if f.writeErr != nil {
+		return f.writeErr
+	}
+	f.written = append(f.written, cred.ID)
+	p.Cred = cred
+	return nil
+}
+
+// fakeRotator rotates keys its own way
+type fakeRotator struct {
+	*fakeProvider
+	opts RotateOptions
+}
+
+func (f *fakeRotator) RotateKey(p *Profile, opts RotateOptions) (Rotation, error) {
+	f.opts = opts
+	return Rotation{Cloud: f.name, Profile: p.Name, Data: "own"}, nil
+}
+
+// fakeEnviron keeps its credentials in environment variables
+type fakeEnviron struct {
+	*fakeProvider
+}
+
+func (f *fakeEnviron) Profiles() ([]Profile, error) {
+	return []Profile{{Cloud: f.name, Cred: Credential{ID: "old", Secret: "s0"}, Source: SourceEnviron, Rotatable: true}}, nil
+}
+
+func (f *fakeEnviron) EnvVars(cred Credential) []EnvVar {
+	return []EnvVar{{Name: "FAKE_KEY", Value: cred.ID}}
+}
+
+var fastVerify = RotateOptions{
+	VerifyTimeout:     50 * time.Millisecond,
+	VerifyInterval:    time.Millisecond,
+	VerifyMaxInterval: 2 * time.Millisecond,
+}
+
+func profileOf(t *testing.T, pr Provider) Profile {
+	t.Helper()
+	profiles, err := pr.Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return profiles[0]
+}
+
+func TestRegistry(t *testing.T) {
+	Register(newFake("registry-b", time.Time{}))
+	Register(newFake("registry-a", time.Time{}))
+
+	if _, err := Get("registry-a"); err != nil {
+		t.Errorf("got error %q but didn't want one", err)
+	}
+	if _, err := Get("nope"); err == nil {
+		t.Errorf("got no error for an unknown cloud")
+	}
+	var got []string
+	for _, p := range Providers() {
+		// The contract tests register the real providers too
+		if strings.HasPrefix(p.Name(), "registry-") {
+			got = append(got, p.Name())
+		}
+	}
+	if want := []string{"registry-a", "registry-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got providers %v but want %v", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering a provider twice didn't panic")
+		}
+	}()
+	Register(newFake("registry-a", time.Time{}))
+}
+
+func TestDedupe(t *testing.T) {
+	profiles := []Profile{
+		{Name: "", Source: SourceEnviron, File: "/keys/robot.json", Cred: Credential{ID: "k1"}},
+		{Name: "robot", File: "/keys/../keys/robot.json", Cred: Credential{ID: "k1"}},
+		{Name: "DEFAULT", File: "/home/me/.oci/config", Cred: Credential{ID: "f1"}},
+		{Name: "inherits", File: "/home/me/.oci/config", Cred: Credential{ID: "f1"}},
+		{Name: "copy", File: "/backup/robot.json", Cred: Credential{ID: "k1"}},
+		{Name: "lookup-a"},
+		{Name: "lookup-b"},
+	}
+
+	var got []string
+	for _, p := range Dedupe(profiles) {
+		got = append(got, p.Name)
+	}
+
+	if want := []string{"", "DEFAULT", "copy", "lookup-a", "lookup-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got profiles %q but want %q", got, want)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	old := time.Now().Add(-100 * 24 * time.Hour)
+
+	t.Run("successful rotation", func(t *testing.T) {
+		f := newFake("fake", old)
+		f.verifyFails = 2
+		p := profileOf(t, f)
+
+		got, err := Rotate(f, &p, fastVerify)
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if got.UserName != "robot" || got.OldKeyID != "old" || got.NewKeyID != "new1" || got.Finished.IsZero() {
+			t.Errorf("got %+v", got)
+		}
+		if p.Cred.ID != "new1" {
+			t.Errorf("got credential %q but want new1", p.Cred.ID)
+		}
+		want := []string{"create new1", "deactivate old with new1", "delete old with new1"}
+		if !reflect.DeepEqual(f.calls, want) {
+			t.Errorf("got calls %q but want %q", f.calls, want)
+		}
+	})
+	t.Run("delete without deactivating", func(t *testing.T) {
+		f := newFake("fake", old)
+		f.deactivate = false
+		p := profileOf(t, f)
+
+		_, err := Rotate(f, &p, fastVerify)
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if want := []string{"create new1", "delete old with new1"}; !reflect.DeepEqual(f.calls, want) {
+			t.Errorf("got calls %q but want %q", f.calls, want)
+		}
+	})
+	t.Run("skip key younger than the minimum age", func(t *testing.T) {
+		f := newFake("fake", time.Now().Add(-time.Hour))
+		p := profileOf(t, f)
+		opts := fastVerify
+		opts.MinAge = 24 * time.Hour
+
+		got, err := Rotate(f, &p, opts)
+
+		if err != nil || got.Skipped == "" || len(f.calls) != 0 {
+			t.Errorf("got %+v, %v and calls %q but want a skipped rotation", got, err, f.calls)
+		}
+	})
+	t.Run("roll back when new key is never accepted", func(t *testing.T) {
+		f := newFake("fake", old)
+		f.verifyFails = 1000
+		p := profileOf(t, f)
+
+		got, err := Rotate(f, &p, fastVerify)
+
+		assertRotateError(t, err, StepVerify, ErrKeyNotAccepted)
+		if !got.RolledBack || p.Cred.ID != "old" {
+			t.Errorf("got %+v with credential %q but want a rollback", got, p.Cred.ID)
+		}
+		if want := []string{"create new1", "delete new1 with old"}; !reflect.DeepEqual(f.calls, want) {
+			t.Errorf("got calls %q but want %q", f.calls, want)
+		}
+	})
+	t.Run("roll back when writing the credential fails", func(t *testing.T) {
+		f := newFake("fake", old)
+		f.writeErr = errors.New("read-only file")
+		p := profileOf(t, f)
+
+		got, err := Rotate(f, &p, fastVerify)
+
+		assertRotateError(t, err, StepWriteCredential, f.writeErr)
+		if !got.RolledBack {
+			t.Errorf("rotation was not rolled back")
+		}
+	})
+	t.Run("restore old credential when post-rotate hook fails", func(t *testing.T) {
+		f := newFake("fake", old)
+		p := profileOf(t, f)
+		failed := errors.New("hook failed")
+		opts := fastVerify
+		opts.PostRotate = func(Rotation, Credential) error { return failed }
+
+		got, err := Rotate(f, &p, opts)
+
+		assertRotateError(t, err, StepPostRotate, failed)
+		if !got.RolledBack || p.Cred.ID != "old" {
+			t.Errorf("got %+v with credential %q but want a rollback", got, p.Cred.ID)
+		}
+		if want := []string{"new1", "old"}; !reflect.DeepEqual(f.written, want) {
+			t.Errorf("got writes %q but want %q", f.written, want)
+		}
+	})
+	t.Run("deliver environment variables", func(t *testing.T) {
+		f := &fakeEnviron{newFake("fake", old)}
+		p := profileOf(t, f)
+		var delivered []string
+		opts := fastVerify
+		opts.Deliver = func(cred Credential, vars []EnvVar) error {
+			delivered = append(delivered, vars[0].Name+"="+vars[0].Value)
+			return nil
+		}
+		failed := errors.New("hook failed")
+		opts.PostRotate = func(Rotation, Credential) error { return failed }
+
+		_, err := Rotate(f, &p, opts)
+
+		assertRotateError(t, err, StepPostRotate, failed)
+		if want := []string{"FAKE_KEY=new1", "FAKE_KEY=old"}; !reflect.DeepEqual(delivered, want) {
+			t.Errorf("got deliveries %q but want %q", delivered, want)
+		}
+	})
+	t.Run("fail on environment variables without delivery", func(t *testing.T) {
+		f := &fakeEnviron{newFake("fake", old)}
+		p := profileOf(t, f)
+
+		_, err := Rotate(f, &p, fastVerify)
+
+		assertRotateError(t, err, StepDeliver, ErrNoDelivery)
+		if len(f.calls) > 0 {
+			t.Errorf("got calls %q but want none", f.calls)
+		}
+	})
+	t.Run("fail on profile without a key", func(t *testing.T) {
+		f := newFake("fake", old)
+		p := profileOf(t, f)
+		p.Rotatable = false
+
+		_, err := Rotate(f, &p, fastVerify)
+
+		assertRotateError(t, err, StepLookup, ErrNotRotatable)
+	})
+	t.Run("rotator rotates itself", func(t *testing.T) {
+		f := &fakeRotator{fakeProvider: newFake("fake", old)}
+		p := profileOf(t, f)
+		opts := fastVerify
+		opts.Options = "options"
+
+		got, err := Rotate(f, &p, opts)
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if got.Data != "own" || f.opts.Options != "options" {
+			t.Errorf("got %+v with options %v but want the rotator's rotation", got, f.opts.Options)
+		}
+		if len(f.calls) > 0 {
+			t.Errorf("got calls %q but want none", f.calls)
+		}
+	})
+}
+
+func assertRotateError(t *testing.T, got error, step string, want error) {
+	t.Helper()
+	rerr, ok := got.(*RotateError)
+	if !ok {
+		t.Fatalf("got %T (%v), want *RotateError", got, got)
+	}
+	if rerr.Step != step {
+		t.Errorf("got step %q, want %q", rerr.Step, step)
+	}
+	if rerr.Err.Error() != want.Error() {
+		t.Errorf("got %q, want %q", rerr.Err, want)
+	}
+}
@@ -0,0 +1,365 @@
+package cloud_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/cloud/alibaba"
+	"github.com/buzzsurfr/cloudkey/cloud/azure"
+	"github.com/buzzsurfr/cloudkey/cloud/gcp"
+	"github.com/buzzsurfr/cloudkey/cloud/oci"
+	"github.com/buzzsurfr/cloudkey/internal/backup"
+	"github.com/buzzsurfr/cloudkey/internal/fakecloud"
+)
+
+// fixture is a profile with a key at a fake cloud
+type fixture struct {
+	profile string
+	user    string
+	keyID   string
+	// check checks what is special about the provider after a rotation
+	check   func(t *testing.T, p cloud.Profile)
+	cleanup func()
+}
+
+// providers are the providers that rotate with cloud.Rotate's own steps.
+// Each setup starts a fake of the cloud with a key created at the given
+// time, and writes the local files of a profile with it to dir.
+var providers = []struct {
+	name  string
+	pr    cloud.Provider
+	setup func(t *testing.T, dir string, created time.Time) fixture
+}{
+	{gcp.Name, gcp.Provider{}, setupGoogle},
+	{azure.Name, azure.Provider{}, setupAzure},
+	{oci.Name, oci.Provider{}, setupOCI},
+	{alibaba.Name, alibaba.Provider{}, setupAlibaba},
+}
+
+func setupGoogle(t *testing.T, dir string, created time.Time) fixture {
+	f := fakecloud.NewGoogle()
+	gcp.BaseURL = f.URL
+	key := f.AddKey(created)
+	keyFile := filepath.Join(dir, "robot.json")
+	gcloud := filepath.Join(dir, "gcloud")
+	writeFile(t, keyFile, f.KeyFile(key))
+	writeFile(t, filepath.Join(gcloud, "active_config"), []byte("ci\n"))
+	writeFile(t, filepath.Join(gcloud, "configurations", "config_ci"), []byte("[auth]\ncredential_file_override = "+keyFile+"\n"))
+	os.Setenv("CLOUDSDK_CONFIG", gcloud)
+	os.Unsetenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+	return fixture{
+		profile: "ci",
+		user:    f.Email,
+		keyID:   key.ID,
+		check: func(t *testing.T, p cloud.Profile) {
+			if info, err := os.Stat(keyFile); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("got key file %v, %v but want mode 0600", info, err)
+			}
+		},
+		cleanup: func() {
+			f.Close()
+			gcp.BaseURL = "https://iam.googleapis.com"
+			os.Unsetenv("CLOUDSDK_CONFIG")
+		},
+	}
+}
+
+func setupAzure(t *testing.T, dir string, created time.Time) fixture {
+	f := fakecloud.NewAzure()
+	azure.GraphURL, azure.LoginURL = f.URL, f.URL
+	key := f.AddKey(created)
+	// The Azure CLI keeps certificates of other applications next to the secret
+	entries := `[{"client_id": "` + f.ClientID + `", "tenant": "` + f.Tenant + `", "client_secret": "` + key.Secret + `"},` +
+		` {"servicePrincipalId": "cert-app", "servicePrincipalTenant": "` + f.Tenant + `", "certificateFile": "/certs/app.pem"}]`
+	entriesFile := filepath.Join(dir, "service_principal_entries.json")
+	writeFile(t, entriesFile, []byte(entries))
+	os.Setenv("AZURE_CONFIG_DIR", dir)
+	for _, env := range []string{"AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_TENANT_ID"} {
+		os.Unsetenv(env)
+	}
+	return fixture{
+		profile: f.ClientID,
+		user:    f.DisplayName,
+		keyID:   key.ID,
+		check: func(t *testing.T, p cloud.Profile) {
+			if d := time.Until(f.Expiry) - azure.SecretExpiry; d > time.Minute || d < -time.Minute {
+				t.Errorf("got expiry %v but want %v from now", f.Expiry, azure.SecretExpiry)
+			}
+			data, err := ioutil.ReadFile(entriesFile)
+			if err != nil || !strings.Contains(string(data), "/certs/app.pem") {
+				t.Errorf("got entries %s, %v but want the certificate kept", data, err)
+			}
+		},
+		cleanup: func() {
+			f.Close()
+			azure.GraphURL, azure.LoginURL = "https://graph.microsoft.com", "https://login.microsoftonline.com"
+			os.Unsetenv("AZURE_CONFIG_DIR")
+		},
+	}
+}
+
+func setupOCI(t *testing.T, dir string, created time.Time) fixture {
+	f := fakecloud.NewOCI()
+	oci.Endpoint = f.URL
+	oci.BackupDir = filepath.Join(dir, "backups")
+	key := f.AddKey(created)
+	keyFile := filepath.Join(dir, "oci_api_key.pem")
+	otherKeyFile := filepath.Join(dir, "other.pem")
+	config := filepath.Join(dir, "config")
+	writeFile(t, keyFile, []byte(key.Secret))
+	writeFile(t, otherKeyFile, []byte("other key"))
+	writeFile(t, config, []byte(`[DEFAULT]
+region = us-ashburn-1
+
+[deploy]
+user = `+f.User+`
+tenancy = `+f.Tenancy+`
+fingerprint = `+key.ID+`
+key_file = `+keyFile+`
+
+[other]
+user = ocid1.user.oc1..other
+fingerprint = 00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00
+key_file = `+otherKeyFile+`
+`))
+	os.Setenv("OCI_CLI_CONFIG_FILE", config)
+	os.Unsetenv("OCI_CLI_PROFILE")
+	return fixture{
+		profile: "deploy",
+		user:    f.User,
+		keyID:   key.ID,
+		check: func(t *testing.T, p cloud.Profile) {
+			// The backup of the config file still points at the old key file
+			if _, err := os.Stat(keyFile); err != nil {
+				t.Errorf("got %v but want the old key file kept for the backup", err)
+			}
+			if _, err := os.Stat(otherKeyFile); err != nil {
+				t.Errorf("got %v but want the key file of another profile kept", err)
+			}
+			if backups, err := backup.List(oci.BackupDir, config); err != nil || len(backups) != 1 {
+				t.Errorf("got backups %v, %v but want one of the config file", backups, err)
+			}
+			files, _ := filepath.Glob(filepath.Join(dir, "oci_api_key-*.pem"))
+			if len(files) != 1 {
+				t.Fatalf("got key files %q but want one new key file", files)
+			}
+			if info, err := os.Stat(files[0]); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("got key file %v, %v but want mode 0600", info, err)
+			}
+		},
+		cleanup: func() {
+			f.Close()
+			oci.Endpoint, oci.BackupDir = "", ""
+			os.Unsetenv("OCI_CLI_CONFIG_FILE")
+		},
+	}
+}
+
+func setupAlibaba(t *testing.T, dir string, created time.Time) fixture {
+	f := fakecloud.NewAlibaba()
+	alibaba.RAMURL, alibaba.STSURL = f.URL+"/ram", f.URL+"/sts"
+	key := f.AddKey(created)
+	alibaba.ConfigFile = filepath.Join(dir, "config.json")
+	writeFile(t, alibaba.ConfigFile, []byte(`{
+	"current": "default",
+	"profiles": [
+		{
+			"name": "default",
+			"mode": "AK",
+			"access_key_id": "`+key.ID+`",
+			"access_key_secret": "`+key.Secret+`",
+			"region_id": "cn-hangzhou",
+			"retry_count": 0
+		},
+		{
+			"name": "sts",
+			"mode": "StsToken",
+			"access_key_id": "STS.temporary",
+			"access_key_secret": "temporary",
+			"sts_token": "token",
+			"region_id": "cn-shanghai"
+		}
+	],
+	"meta_path": ""
+}`))
+	for _, env := range []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_PROFILE"} {
+		os.Unsetenv(env)
+	}
+	return fixture{
+		profile: "default",
+		user:    f.User,
+		keyID:   key.ID,
+		check: func(t *testing.T, p cloud.Profile) {
+			data, err := ioutil.ReadFile(alibaba.ConfigFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, kept := range []string{`"retry_count": 0`, `"sts_token": "token"`, `"current": "default"`} {
+				if !strings.Contains(string(data), kept) {
+					t.Errorf("got config %s but want %s kept", data, kept)
+				}
+			}
+			if info, err := os.Stat(alibaba.ConfigFile); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("got config file %v, %v but want mode 0600", info, err)
+			}
+		},
+		cleanup: func() {
+			f.Close()
+			alibaba.RAMURL, alibaba.STSURL = "https://ram.aliyuncs.com", "https://sts.aliyuncs.com"
+			alibaba.ConfigFile = ""
+		},
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// profileNamed reads the profiles of a provider and finds one
+func profileNamed(t *testing.T, pr cloud.Provider, name string) cloud.Profile {
+	t.Helper()
+	profiles, err := pr.Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	t.Fatalf("got no profile %q in %+v", name, profiles)
+	return cloud.Profile{}
+}
+
+// keyIDs lists the IDs of the keys of the profile's user
+func keyIDs(t *testing.T, pr cloud.Provider, p cloud.Profile) []string {
+	t.Helper()
+	keys, err := pr.Keys(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return ids
+}
+
+func TestRotateGoogleEnviron(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := setupGoogle(t, dir, time.Now().Add(-100*24*time.Hour))
+	defer f.cleanup()
+	keyFile := filepath.Join(dir, "robot.json")
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", keyFile)
+	defer os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+	p := profileNamed(t, gcp.Provider{}, "")
+	if p.Source != cloud.SourceEnviron {
+		t.Fatalf("got source %q but want %q", p.Source, cloud.SourceEnviron)
+	}
+
+	// The key file is rewritten in place, so there is nothing to deliver
+	r, err := cloud.Rotate(gcp.Provider{}, &p, cloud.RotateOptions{VerifyTimeout: time.Second, VerifyInterval: time.Millisecond})
+
+	if err != nil {
+		t.Fatalf("got error %q but didn't want one", err)
+	}
+	if saved := profileNamed(t, gcp.Provider{}, ""); saved.Cred.ID != r.NewKeyID {
+		t.Errorf("got key %q in %s but want the new key %q", saved.Cred.ID, keyFile, r.NewKeyID)
+	}
+}
+
+func TestProviderRotate(t *testing.T) {
+	opts := cloud.RotateOptions{VerifyTimeout: time.Second, VerifyInterval: time.Millisecond}
+	old := time.Now().Add(-100 * 24 * time.Hour)
+
+	for _, tt := range providers {
+		t.Run(tt.name, func(t *testing.T) {
+			run := func(name string, created time.Time, test func(t *testing.T, pr cloud.Provider, p *cloud.Profile, f fixture)) {
+				t.Run(name, func(t *testing.T) {
+					dir, err := ioutil.TempDir("", "cloudkey")
+					if err != nil {
+						t.Fatal(err)
+					}
+					defer os.RemoveAll(dir)
+					f := tt.setup(t, dir, created)
+					defer f.cleanup()
+					p := profileNamed(t, tt.pr, f.profile)
+					test(t, tt.pr, &p, f)
+				})
+			}
+
+			run("new key replaces the old one", old, func(t *testing.T, pr cloud.Provider, p *cloud.Profile, f fixture) {
+				r, err := cloud.Rotate(pr, p, opts)
+
+				if err != nil {
+					t.Fatalf("got error %q but didn't want one", err)
+				}
+				if r.Cloud != tt.name || r.OldKeyID != f.keyID || r.NewKeyID == "" || r.NewKeyID == f.keyID || r.UserName != f.user {
+					t.Errorf("got %+v", r)
+				}
+				if p.Cred.ID != r.NewKeyID {
+					t.Errorf("got credential %q but want the new key %q", p.Cred.ID, r.NewKeyID)
+				}
+				if got := keyIDs(t, pr, *p); !reflect.DeepEqual(got, []string{r.NewKeyID}) {
+					t.Errorf("got keys %q but want only the new key", got)
+				}
+				if saved := profileNamed(t, pr, f.profile); saved.Cred.Secret != p.Cred.Secret {
+					t.Errorf("the new key was not saved to profile %q", f.profile)
+				}
+				f.check(t, *p)
+			})
+			run("young key is skipped", time.Now(), func(t *testing.T, pr cloud.Provider, p *cloud.Profile, f fixture) {
+				young := opts
+				young.MinAge = 24 * time.Hour
+
+				r, err := cloud.Rotate(pr, p, young)
+
+				if err != nil || r.Skipped == "" {
+					t.Fatalf("got %+v, %v but want the rotation skipped", r, err)
+				}
+				if got := keyIDs(t, pr, *p); !reflect.DeepEqual(got, []string{f.keyID}) {
+					t.Errorf("got keys %q but want only the old key", got)
+				}
+			})
+			run("failing post-rotate hook rolls back", old, func(t *testing.T, pr cloud.Provider, p *cloud.Profile, f fixture) {
+				secret := p.Cred.Secret
+				failed := errors.New("hook failed")
+				hooked := opts
+				hooked.PostRotate = func(cloud.Rotation, cloud.Credential) error { return failed }
+
+				r, err := cloud.Rotate(pr, p, hooked)
+
+				if rerr, ok := err.(*cloud.RotateError); !ok || rerr.Step != cloud.StepPostRotate || rerr.Err != failed {
+					t.Fatalf("got error %v but want the hook's", err)
+				}
+				if !r.RolledBack || p.Cred.ID != f.keyID {
+					t.Errorf("got %+v with credential %q but want a rollback", r, p.Cred.ID)
+				}
+				if got := keyIDs(t, pr, *p); !reflect.DeepEqual(got, []string{f.keyID}) {
+					t.Errorf("got keys %q but want only the old key", got)
+				}
+				if saved := profileNamed(t, pr, f.profile); saved.Cred.Secret != secret {
+					t.Errorf("the old key was not restored to profile %q", f.profile)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Package gcp rotates Google Cloud service account keys: the JSON key files
+// that GOOGLE_APPLICATION_CREDENTIALS, application default credentials and
+// gcloud configurations point at.
+package gcp
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	"github.com/buzzsurfr/cloudkey/internal/inifile"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// Name is the name of the Google Cloud provider
+const Name = "gcp"
+
+// Sources of a key file
+const (
+	SourceEnviron            = cloud.SourceEnviron
+	SourceApplicationDefault = "ApplicationDefault"
+	SourceConfiguration      = "GcloudConfiguration"
+)
+
+// keyFileMode keeps key files private to the user
+const keyFileMode = 0600
+
+// Provider works with service account keys
+type Provider struct{}
+
+func init() {
+	cloud.Register(Provider{})
+}
+
+// Name is the name of the provider
+func (Provider) Name() string {
+	return Name
+}
+
+// Profiles finds the key files of GOOGLE_APPLICATION_CREDENTIALS, the gcloud
+// configurations with auth/credential_file_override and the application
+// default credentials. The current one is the first of these that is set,
+// taking the active gcloud configuration.
+func (Provider) Profiles() ([]cloud.Profile, error) {
+	var profiles []cloud.Profile
+	var errs []string
+	add := func(p cloud.Profile, err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+			return
+		}
+		profiles = append(profiles, p)
+	}
+
+	current := false
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		add(fromKeyFile("", SourceEnviron, path, true))
+		current = true
+	}
+
+	dir, err := configDir()
+	if err != nil {
+		return profiles, err
+	}
+	configs, err := configurations(dir)
+	if err != nil {
+		errs = append(errs, err.Error())
+	}
+	for _, c := range configs {
+		p, err := fromKeyFile(c.name, SourceConfiguration, c.keyFile, !current && c.active)
+		if err == nil {
+			if p.Account == "" {
+				p.Account = c.project
+			}
+			p.Region = c.region
+			current = current || p.IsCurrent
+		}
+		add(p, err)
+	}
+
+	adc := filepath.Join(dir, "application_default_credentials.json")
+	if _, err := os.Stat(adc); err == nil {
+		add(fromKeyFile("application-default", SourceApplicationDefault, adc, !current))
+	}
+
+	if len(errs) > 0 {
+		return profiles, errors.New(strings.Join(errs, "\n"))
+	}
+	return profiles, nil
+}
+
+// fromKeyFile makes a profile of a credential file
+func fromKeyFile(name, source, path string, isCurrent bool) (cloud.Profile, error) {
+	if expanded, err := homedir.Expand(path); err == nil {
+		path = expanded
+	}
+	k, data, err := readKeyFile(path)
+	if err != nil {
+		return cloud.Profile{}, err
+	}
+	kind := strings.Replace(k.Type, "_", "-", -1)
+	return cloud.Profile{
+		Cloud:     Name,
+		Name:      name,
+		Kind:      kind,
+		Account:   k.ProjectID,
+		UserName:  k.ClientEmail,
+		Cred:      cloud.Credential{ID: k.PrivateKeyID, Secret: string(data)},
+		Source:    source,
+		File:      path,
+		IsCurrent: isCurrent,
+		Rotatable: k.Type == typeServiceAccount && k.PrivateKeyID != "" && k.PrivateKey != "",
+	}, nil
+}
+
+// configDir is gcloud's configuration directory: $CLOUDSDK_CONFIG, or
+// ~/.config/gcloud
+func configDir() (string, error) {
+	if dir := os.Getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return homedir.Expand(dir)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "gcloud"), nil
+}
+
+// configuration is a gcloud configuration that uses a key file
+type configuration struct {
+	name    string
+	active  bool
+	keyFile string
+	project string
+	region  string
+}
+
+// configurations reads the gcloud configurations in dir that set
+// auth/credential_file_override. The others use gcloud's own credential
+// store, which cloudkey leaves alone.
+func configurations(dir string) ([]configuration, error) {
+	active := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if active == "" {
+		active = "default"
+		if b, err := ioutil.ReadFile(filepath.Join(dir, "active_config")); err == nil && len(strings.TrimSpace(string(b))) > 0 {
+			active = strings.TrimSpace(string(b))
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "configurations", "config_*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var configs []configuration
+	for _, file := range files {
+		sections, err := inifile.ParseFile(file)
+		if err != nil {
+			return configs, err
+		}
+		c := configuration{name: strings.TrimPrefix(filepath.Base(file), "config_")}
+		c.active = c.name == active
+		for _, s := range sections {
+			switch s.Name {
+			case "auth":
+				c.keyFile, _ = s.Get("credential_file_override")
+			case "core":
+				c.project, _ = s.Get("project")
+			case "compute":
+				c.region, _ = s.Get("region")
+			}
+		}
+		if c.keyFile != "" {
+			configs = append(configs, c)
+		}
+	}
+	return configs, nil
+}
+
+// key parses the profile's key file
+func key(p cloud.Profile) (keyFile, error) {
+	return parseKeyFile([]byte(p.Cred.Secret))
+}
+
+// Lookup asks IAM for the project and email of the key's service account
+func (Provider) Lookup(p *cloud.Profile) error {
+	k, err := key(*p)
+	if err != nil {
+		return err
+	}
+	var sa struct {
+		ProjectID string `json:"projectId"`
+		Email     string `json:"email"`
+	}
+	if err := call(k, "GET", serviceAccount(k.ClientEmail), nil, &sa); err != nil {
+		return err
+	}
+	p.Account, p.UserName = sa.ProjectID, sa.Email
+	return nil
+}
+
+// Keys lists the user-managed keys of the service account
+func (Provider) Keys(p cloud.Profile) ([]cloud.Key, error) {
+	k, err := key(p)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		Keys []serviceAccountKey `json:"keys"`
+	}
+	if err := call(k, "GET", serviceAccount(p.UserName)+"/keys?keyTypes=USER_MANAGED", nil, &out); err != nil {
+		return nil, err
+	}
+	keys := make([]cloud.Key, 0, len(out.Keys))
+	for _, sk := range out.Keys {
+		created, _ := time.Parse(time.RFC3339, sk.ValidAfterTime)
+		keys = append(keys, cloud.Key{ID: sk.id(), Active: !sk.Disabled, Created: created})
+	}
+	return keys, nil
+}
+
+// CreateKey creates a new key for the service account. Its secret is the
+// whole key file.
+func (Provider) CreateKey(p cloud.Profile) (cloud.Credential, error) {
+	k, err := key(p)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	var sk serviceAccountKey
+	in := map[string]string{
+		"privateKeyType": "TYPE_GOOGLE_CREDENTIALS_FILE",
+		"keyAlgorithm":   "KEY_ALG_RSA_2048",
+	}
+	if err := call(k, "POST", serviceAccount(p.UserName)+"/keys", in, &sk); err != nil {
+		return cloud.Credential{}, err
+	}
+	data, err := base64.StdEncoding.DecodeString(sk.PrivateKeyData)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	return cloud.Credential{ID: sk.id(), Secret: string(data)}, nil
+}
+
+// DeactivateKey disables a key of the service account
+func (Provider) DeactivateKey(p cloud.Profile, id string) error {
+	k, err := key(p)
+	if err != nil {
+		return err
+	}
+	return call(k, "POST", serviceAccount(p.UserName)+"/keys/"+id+":disable", struct{}{}, nil)
+}
+
+// DeleteKey deletes a key of the service account
+func (Provider) DeleteKey(p cloud.Profile, id string) error {
+	k, err := key(p)
+	if err != nil {
+		return err
+	}
+	return call(k, "DELETE", serviceAccount(p.UserName)+"/keys/"+id, nil, nil)
+}
+
+// Verify checks whether Google hands out access tokens for the key
+func (Provider) Verify(p cloud.Profile, cred cloud.Credential) error {
+	k, err := parseKeyFile([]byte(cred.Secret))
+	if err != nil {
+		return err
+	}
+	_, err = accessToken(k)
+	return err
+}
+
+// WriteCredential replaces the profile's key file with the new one
+func (Provider) WriteCredential(p *cloud.Profile, cred cloud.Credential) error {
+	if err := atomicfile.WriteFile(p.File, []byte(cred.Secret), keyFileMode); err != nil {
+		return err
+	}
+	p.Cred = cred
+	return nil
+}
@@ -0,0 +1,197 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+)
+
+const (
+	email     = "robot@my-project.iam.gserviceaccount.com"
+	oldKeyID  = "0123456789abcdef0123456789abcdef01234567"
+	projectID = "my-project"
+)
+
+var (
+	pemOnce sync.Once
+	testPEM string
+)
+
+// privateKeyPEM is a PKCS #8 RSA key, like in the key files from Google
+func privateKeyPEM(t *testing.T) string {
+	t.Helper()
+	pemOnce.Do(func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	})
+	return testPEM
+}
+
+func keyFileJSON(t *testing.T, id, tokenURI string) []byte {
+	t.Helper()
+	b, err := json.MarshalIndent(map[string]string{
+		"type":           typeServiceAccount,
+		"project_id":     projectID,
+		"private_key_id": id,
+		"private_key":    privateKeyPEM(t),
+		"client_email":   email,
+		"token_uri":      tokenURI,
+	}, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// tempDir makes a temporary directory and points CLOUDSDK_CONFIG into it
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("CLOUDSDK_CONFIG", filepath.Join(dir, "gcloud"))
+	os.Unsetenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+	return dir, func() {
+		os.Unsetenv("CLOUDSDK_CONFIG")
+		os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+		os.RemoveAll(dir)
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	keyPath := filepath.Join(dir, "robot.json")
+	writeFile(t, keyPath, keyFileJSON(t, oldKeyID, ""))
+	gcloud := filepath.Join(dir, "gcloud")
+	writeFile(t, filepath.Join(gcloud, "active_config"), []byte("ci\n"))
+	writeFile(t, filepath.Join(gcloud, "configurations", "config_ci"), []byte("[core]\nproject = other-project\n[auth]\ncredential_file_override = "+keyPath+"\n[compute]\nregion = europe-west1\n"))
+	writeFile(t, filepath.Join(gcloud, "configurations", "config_default"), []byte("[core]\naccount = me@example.com\n"))
+	writeFile(t, filepath.Join(gcloud, "application_default_credentials.json"), []byte(`{"type":"authorized_user","client_id":"x","refresh_token":"y"}`))
+
+	t.Run("gcloud configuration is current", func(t *testing.T) {
+		got, err := Provider{}.Profiles()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("got %d profiles but want 2: %+v", len(got), got)
+		}
+		ci, adc := got[0], got[1]
+		want := cloud.Profile{
+			Cloud: Name, Name: "ci", Kind: "service-account", Region: "europe-west1", Account: projectID, UserName: email,
+			Cred: cloud.Credential{ID: oldKeyID, Secret: ci.Cred.Secret}, Source: SourceConfiguration, File: keyPath,
+			IsCurrent: true, Rotatable: true,
+		}
+		if !reflect.DeepEqual(ci, want) {
+			t.Errorf("got %+v but want %+v", ci, want)
+		}
+		if adc.Name != "application-default" || adc.Kind != "authorized-user" || adc.Rotatable || adc.IsCurrent {
+			t.Errorf("got %+v", adc)
+		}
+	})
+	t.Run("environment variable is current", func(t *testing.T) {
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", keyPath)
+		defer os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+
+		got, err := Provider{}.Profiles()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 3 || got[0].Source != SourceEnviron || !got[0].IsCurrent || got[1].IsCurrent {
+			t.Errorf("got %+v", got)
+		}
+	})
+	t.Run("missing key file", func(t *testing.T) {
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(dir, "missing.json"))
+		defer os.Unsetenv("GOOGLE_APPLICATION_CREDENTIALS")
+
+		got, err := Provider{}.Profiles()
+
+		if err == nil || len(got) != 2 {
+			t.Errorf("got %d profiles and error %v but want the other 2 and an error", len(got), err)
+		}
+	})
+}
+
+func TestAccessToken(t *testing.T) {
+	var assertion string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertion = r.FormValue("assertion")
+		fmt.Fprint(w, `{"access_token":"token","token_type":"Bearer","expires_in":3600}`)
+	}))
+	defer server.Close()
+	k, err := parseKeyFile(keyFileJSON(t, oldKeyID, server.URL+"/token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := k.rsaKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := accessToken(k)
+
+	if err != nil || token != "token" {
+		t.Fatalf("got %q, %v but want the access token", token, err)
+	}
+	// The assertion is a JWT signed with the key, for the key's account
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("got assertion %q but want a JWT", assertion)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("got a bad signature: %v", err)
+	}
+	var header, claims map[string]interface{}
+	headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	json.Unmarshal(headerJSON, &header)
+	json.Unmarshal(claimsJSON, &claims)
+	if header["alg"] != "RS256" || header["kid"] != oldKeyID {
+		t.Errorf("got header %v", header)
+	}
+	if claims["iss"] != email || claims["aud"] != server.URL+"/token" || claims["scope"] != scope {
+		t.Errorf("got claims %v", claims)
+	}
+}
@@ -0,0 +1,93 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/internal/httpapi"
+)
+
+// BaseURL is the endpoint of the IAM API. Tests point it at a local fake.
+var BaseURL = "https://iam.googleapis.com"
+
+// scope is the OAuth scope of the access tokens
+const scope = "https://www.googleapis.com/auth/cloud-platform"
+
+// accessToken exchanges a signed JWT for an access token of the key's
+// service account
+func accessToken(k keyFile) (string, error) {
+	key, err := k.rsaKey()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": k.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   k.ClientEmail,
+		"scope": scope,
+		"aud":   k.tokenURI(),
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = httpapi.PostForm(k.tokenURI(), url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}, &token)
+	return token.AccessToken, err
+}
+
+// call calls the IAM API as the key's service account and decodes the
+// response into out, unless out is nil
+func call(k keyFile, method, resource string, in, out interface{}) error {
+	token, err := accessToken(k)
+	if err != nil {
+		return err
+	}
+	req, err := httpapi.NewRequest(method, strings.TrimRight(BaseURL, "/")+"/v1/"+resource, in)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return httpapi.Do(req, out)
+}
+
+// serviceAccount is the resource name of the key's service account. The
+// project is taken from the account's email.
+func serviceAccount(email string) string {
+	return "projects/-/serviceAccounts/" + url.PathEscape(email)
+}
+
+// serviceAccountKey is a key of a service account in the IAM API
+type serviceAccountKey struct {
+	Name           string `json:"name"`
+	ValidAfterTime string `json:"validAfterTime"`
+	KeyType        string `json:"keyType"`
+	Disabled       bool   `json:"disabled"`
+	// PrivateKeyData is the base64-encoded key file, only set when the key
+	// is created
+	PrivateKeyData string `json:"privateKeyData"`
+}
+
+// id is the private_key_id of the key, the last part of its name
+func (k serviceAccountKey) id() string {
+	return path.Base(k.Name)
+}
@@ -0,0 +1,74 @@
+package gcp
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+)
+
+// Types of credential files
+const (
+	typeServiceAccount = "service_account"
+	typeAuthorizedUser = "authorized_user"
+)
+
+// defaultTokenURI is where a key is exchanged for an access token, if the key
+// file doesn't say
+const defaultTokenURI = "https://oauth2.googleapis.com/token"
+
+// keyFile is a service account key file, as downloaded from the console or
+// created by the IAM API
+type keyFile struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// ErrNoPrivateKey means a key file has no usable RSA private key
+var ErrNoPrivateKey = errors.New("No RSA private key in the key file")
+
+// parseKeyFile parses the contents of a credential file
+func parseKeyFile(data []byte) (keyFile, error) {
+	var k keyFile
+	err := json.Unmarshal(data, &k)
+	return k, err
+}
+
+// readKeyFile reads a credential file along with its contents
+func readKeyFile(path string) (keyFile, []byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return keyFile{}, nil, err
+	}
+	k, err := parseKeyFile(data)
+	return k, data, err
+}
+
+// tokenURI is where the key is exchanged for an access token
+func (k keyFile) tokenURI() string {
+	if k.TokenURI != "" {
+		return k.TokenURI
+	}
+	return defaultTokenURI
+}
+
+// rsaKey parses the private key, which is PKCS #8 in files from Google
+func (k keyFile) rsaKey() (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, ErrNoPrivateKey
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, ErrNoPrivateKey
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
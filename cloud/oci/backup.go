@@ -0,0 +1,68 @@
+package oci
+
+import (
+	"os"
+
+	"github.com/buzzsurfr/cloudkey/internal/backup"
+)
+
+// BackupDir is where a copy of the config file is saved before cloudkey
+// changes it. When empty, no backups are saved.
+var BackupDir string
+
+// BackupKeep is how many backups of each file are kept. Zero keeps every backup.
+var BackupKeep = 10
+
+// backupFile saves a copy of the config file in BackupDir before it is
+// changed. Key files replaced by a rotation are kept while a backup points at
+// them, so that any backup can be restored; once the last of those backups is
+// pruned, they are removed.
+func backupFile(file string) error {
+	if BackupDir == "" {
+		return nil
+	}
+	before, err := backedUpKeyFiles(file)
+	if err != nil {
+		return err
+	}
+	if _, err := backup.Save(BackupDir, file, BackupKeep); err != nil {
+		return err
+	}
+	// The backup just saved is the config file as it is now, so its key
+	// files are kept too
+	after, err := backedUpKeyFiles(file)
+	if err != nil {
+		return err
+	}
+	for keyFile := range before {
+		if after[keyFile] {
+			continue
+		}
+		if err := os.Remove(keyFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// backedUpKeyFiles gets the key files that the backups of the config file
+// point at
+func backedUpKeyFiles(file string) (map[string]bool, error) {
+	backups, err := backup.List(BackupDir, file)
+	if err != nil {
+		return nil, err
+	}
+	keyFiles := make(map[string]bool)
+	for _, b := range backups {
+		configs, err := parseConfigFile(b.Path)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range configs {
+			if c.keyFile != "" {
+				keyFiles[c.keyFile] = true
+			}
+		}
+	}
+	return keyFiles, nil
+}
@@ -0,0 +1,132 @@
+package oci
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	"github.com/buzzsurfr/cloudkey/internal/inifile"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// defaultProfile is the profile used unless OCI_CLI_PROFILE says otherwise.
+// Its keys are also the defaults of every other profile.
+const defaultProfile = "DEFAULT"
+
+// configFileMode keeps the config file private to the user
+const configFileMode = 0600
+
+// config is a profile of the OCI config file, with the keys it takes from
+// the DEFAULT profile
+type config struct {
+	name        string
+	user        string
+	tenancy     string
+	region      string
+	fingerprint string
+	keyFile     string
+	passPhrase  string
+	// securityToken is set for session profiles from "oci session authenticate"
+	securityToken string
+	file          string
+	// original is the profile before WriteCredential changed its key
+	original *config
+}
+
+// ConfigFilename gets the path of the OCI config file: OCI_CLI_CONFIG_FILE,
+// then ~/.oci/config
+func ConfigFilename() (string, error) {
+	if path := os.Getenv("OCI_CLI_CONFIG_FILE"); path != "" {
+		return homedir.Expand(path)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".oci", "config"), nil
+}
+
+// currentProfile is the name of the profile used by default
+func currentProfile() string {
+	if name := os.Getenv("OCI_CLI_PROFILE"); name != "" {
+		return name
+	}
+	return defaultProfile
+}
+
+// parseConfigFile parses the OCI config file. Keys missing from a profile are
+// taken from the DEFAULT profile, like the OCI SDKs do.
+func parseConfigFile(path string) ([]config, error) {
+	sections, err := inifile.ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return configsOf(sections, path), nil
+}
+
+// configsOf gets the profiles of the sections of the config file at path
+func configsOf(sections []inifile.Section, path string) []config {
+	var defaults inifile.Section
+	for _, s := range sections {
+		if s.Name == defaultProfile {
+			defaults = s
+		}
+	}
+	get := func(s inifile.Section, name string) string {
+		if v, ok := s.Get(name); ok {
+			return v
+		}
+		v, _ := defaults.Get(name)
+		return v
+	}
+
+	configs := make([]config, 0, len(sections))
+	for _, s := range sections {
+		c := config{
+			name:          s.Name,
+			user:          get(s, "user"),
+			tenancy:       get(s, "tenancy"),
+			region:        get(s, "region"),
+			fingerprint:   get(s, "fingerprint"),
+			keyFile:       get(s, "key_file"),
+			passPhrase:    get(s, "pass_phrase"),
+			securityToken: get(s, "security_token_file"),
+			file:          path,
+		}
+		if expanded, err := homedir.Expand(c.keyFile); err == nil {
+			c.keyFile = expanded
+		}
+		configs = append(configs, c)
+	}
+	return configs
+}
+
+// setKey changes the key file and fingerprint of every profile that signs
+// with the old key, in one write
+func setKey(path, oldKeyFile, oldFingerprint, keyFile, fingerprint string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sections, err := inifile.Parse(data)
+	if err != nil {
+		return err
+	}
+	for i, c := range configsOf(sections, path) {
+		if c.fingerprint != oldFingerprint || c.keyFile != oldKeyFile {
+			continue
+		}
+		// Profiles that inherit both keys change with DEFAULT
+		_, hasFingerprint := sections[i].Get("fingerprint")
+		_, hasKeyFile := sections[i].Get("key_file")
+		if !hasFingerprint && !hasKeyFile {
+			continue
+		}
+		data = inifile.Set(data, c.name, []inifile.Key{
+			{Name: "fingerprint", Value: fingerprint},
+			{Name: "key_file", Value: keyFile},
+		})
+	}
+	return atomicfile.WriteFile(path, data, configFileMode)
+}
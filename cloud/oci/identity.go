@@ -0,0 +1,194 @@
+package oci
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/internal/httpapi"
+)
+
+// Endpoint overrides the Identity endpoint, which is otherwise that of the
+// profile's region. Tests point it at a local fake.
+var Endpoint string
+
+// apiVersion is the version of the Identity API
+const apiVersion = "20160918"
+
+// keyBits is the size of new RSA keys, as "oci setup keys" makes them
+const keyBits = 2048
+
+// ErrNoPrivateKey means a key file has no usable RSA private key
+var ErrNoPrivateKey = errors.New("No RSA private key in the key file")
+
+// ErrPassPhraseNeeded means the key file is encrypted and the profile has no
+// pass_phrase
+var ErrPassPhraseNeeded = errors.New("The key file is encrypted, but the profile has no pass_phrase")
+
+// signer signs requests as a user with one of its API keys
+type signer struct {
+	tenancy     string
+	user        string
+	fingerprint string
+	key         *rsa.PrivateKey
+}
+
+// parsePrivateKey parses a PEM private key, decrypting it with passPhrase if
+// it is encrypted
+func parsePrivateKey(data []byte, passPhrase string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPrivateKey
+	}
+	der := block.Bytes
+	//lint:ignore SA1019 OCI still writes legacy encrypted PEM files
+	if x509.IsEncryptedPEMBlock(block) {
+		if passPhrase == "" {
+			return nil, ErrPassPhraseNeeded
+		}
+		var err error
+		//lint:ignore SA1019 OCI still writes legacy encrypted PEM files
+		if der, err = x509.DecryptPEMBlock(block, []byte(passPhrase)); err != nil {
+			return nil, err
+		}
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+			return rsaKey, nil
+		}
+		return nil, ErrNoPrivateKey
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+// newKey generates an RSA key pair. The private key is PEM encoded, and
+// encrypted with passPhrase if it is set.
+func newKey(passPhrase string) (*rsa.PrivateKey, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passPhrase != "" {
+		//lint:ignore SA1019 OCI still reads legacy encrypted PEM files
+		if block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passPhrase), x509.PEMCipherAES256); err != nil {
+			return nil, nil, err
+		}
+	}
+	return key, pem.EncodeToMemory(block), nil
+}
+
+// publicKeyPEM encodes the public key the way UploadApiKey takes it
+func publicKeyPEM(key *rsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// fingerprint is the MD5 of the public key, as OCI shows it
+func fingerprint(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := md5.Sum(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":"), nil
+}
+
+// endpoint is the Identity endpoint of a region
+func endpoint(region string) string {
+	if Endpoint != "" {
+		return strings.TrimRight(Endpoint, "/")
+	}
+	return "https://identity." + region + ".oraclecloud.com"
+}
+
+// call calls the Identity API and decodes the response into out, unless out
+// is nil
+func (s signer) call(region, method, resource string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, endpoint(region)+"/"+apiVersion+"/"+resource, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := s.sign(req, body); err != nil {
+		return err
+	}
+	return httpapi.Do(req, out)
+}
+
+// sign adds the Date and Authorization headers of OCI's HTTP signatures.
+// Requests with a body also sign its length, type and hash.
+func (s signer) sign(req *http.Request, body []byte) error {
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	headers := []string{"(request-target)", "date", "host"}
+	if req.Method == "POST" || req.Method == "PUT" {
+		sum := sha256.Sum256(body)
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		req.Header.Set("X-Content-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+		headers = append(headers, "content-length", "content-type", "x-content-sha256")
+	}
+
+	lines := make([]string, len(headers))
+	for i, h := range headers {
+		switch h {
+		case "(request-target)":
+			lines[i] = h + ": " + strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			lines[i] = h + ": " + req.URL.Host
+		default:
+			lines[i] = h + ": " + req.Header.Get(h)
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`Signature version="1",keyId="%s/%s/%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		s.tenancy, s.user, s.fingerprint, strings.Join(headers, " "), base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// users is the resource of a user
+func users(user string) string {
+	return "users/" + url.PathEscape(user)
+}
+
+// apiKey is an API signing key of a user
+type apiKey struct {
+	KeyID          string    `json:"keyId"`
+	Fingerprint    string    `json:"fingerprint"`
+	KeyValue       string    `json:"keyValue"`
+	LifecycleState string    `json:"lifecycleState"`
+	TimeCreated    time.Time `json:"timeCreated"`
+}
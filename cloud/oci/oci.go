@@ -0,0 +1,269 @@
+// Package oci rotates the API signing keys of Oracle Cloud Infrastructure
+// users, referenced by key_file and fingerprint in the OCI config file.
+package oci
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+)
+
+// Name is the name of the Oracle Cloud provider
+const Name = "oci"
+
+// SourceConfigFile is the Source of profiles in the OCI config file
+const SourceConfigFile = "ConfigFile"
+
+// Kinds of OCI profiles
+const (
+	KindAPIKey       = "api-key"
+	KindSessionToken = "session-token"
+)
+
+// keyFileMode keeps key files private to the user
+const keyFileMode = 0600
+
+// Provider works with API signing keys
+type Provider struct{}
+
+func init() {
+	cloud.Register(Provider{})
+}
+
+// Name is the name of the provider
+func (Provider) Name() string {
+	return Name
+}
+
+// Profiles lists the profiles of the OCI config file. The current one is
+// OCI_CLI_PROFILE, or DEFAULT.
+func (Provider) Profiles() ([]cloud.Profile, error) {
+	path, err := ConfigFilename()
+	if err != nil {
+		return nil, err
+	}
+	configs, err := parseConfigFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	current := currentProfile()
+	profiles := make([]cloud.Profile, 0, len(configs))
+	var errs []string
+	for i := range configs {
+		c := &configs[i]
+		p := cloud.Profile{
+			Cloud:     Name,
+			Name:      c.name,
+			Kind:      KindAPIKey,
+			Region:    c.region,
+			Account:   c.tenancy,
+			UserName:  c.user,
+			Cred:      cloud.Credential{ID: c.fingerprint},
+			Source:    SourceConfigFile,
+			File:      path,
+			IsCurrent: c.name == current,
+			Data:      c,
+		}
+		if c.securityToken != "" {
+			p.Kind = KindSessionToken
+		}
+		if c.keyFile != "" {
+			data, err := ioutil.ReadFile(c.keyFile)
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			p.Cred.Secret = string(data)
+		}
+		p.Rotatable = p.Kind == KindAPIKey && c.user != "" && c.tenancy != "" && c.fingerprint != "" && p.Cred.Secret != ""
+		profiles = append(profiles, p)
+	}
+	if len(errs) > 0 {
+		return profiles, errors.New(strings.Join(errs, "\n"))
+	}
+	return profiles, nil
+}
+
+// configOf gets the config of a profile
+func configOf(p cloud.Profile) (*config, error) {
+	c, ok := p.Data.(*config)
+	if !ok {
+		return nil, errors.New("Not an OCI profile")
+	}
+	return c, nil
+}
+
+// signerFor signs as the profile's user with a credential
+func signerFor(p cloud.Profile, cred cloud.Credential) (signer, *config, error) {
+	c, err := configOf(p)
+	if err != nil {
+		return signer{}, nil, err
+	}
+	key, err := parsePrivateKey([]byte(cred.Secret), c.passPhrase)
+	if err != nil {
+		return signer{}, nil, err
+	}
+	return signer{tenancy: c.tenancy, user: c.user, fingerprint: cred.ID, key: key}, c, nil
+}
+
+// Lookup checks that the user of the profile exists. Its OCID and the
+// fingerprint of the key are already known from the config file.
+func (Provider) Lookup(p *cloud.Profile) error {
+	s, c, err := signerFor(*p, p.Cred)
+	if err != nil {
+		return err
+	}
+	var user struct {
+		ID string `json:"id"`
+	}
+	if err := s.call(c.region, "GET", users(c.user), nil, &user); err != nil {
+		return err
+	}
+	p.UserName = user.ID
+	return nil
+}
+
+// Keys lists the API keys of the user
+func (Provider) Keys(p cloud.Profile) ([]cloud.Key, error) {
+	s, c, err := signerFor(p, p.Cred)
+	if err != nil {
+		return nil, err
+	}
+	var apiKeys []apiKey
+	if err := s.call(c.region, "GET", users(c.user)+"/apiKeys/", nil, &apiKeys); err != nil {
+		return nil, err
+	}
+	keys := make([]cloud.Key, 0, len(apiKeys))
+	for _, k := range apiKeys {
+		keys = append(keys, cloud.Key{ID: k.Fingerprint, Active: k.LifecycleState == "ACTIVE", Created: k.TimeCreated})
+	}
+	return keys, nil
+}
+
+// CreateKey generates an RSA key pair and uploads its public key. The
+// credential's ID is the fingerprint and its secret the PEM private key,
+// encrypted with the profile's pass_phrase if it has one.
+func (Provider) CreateKey(p cloud.Profile) (cloud.Credential, error) {
+	s, c, err := signerFor(p, p.Cred)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	key, data, err := newKey(c.passPhrase)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	id, err := fingerprint(&key.PublicKey)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	public, err := publicKeyPEM(key)
+	if err != nil {
+		return cloud.Credential{}, err
+	}
+	if err := s.call(c.region, "POST", users(c.user)+"/apiKeys/", map[string]string{"key": public}, nil); err != nil {
+		return cloud.Credential{}, err
+	}
+	return cloud.Credential{ID: id, Secret: string(data)}, nil
+}
+
+// DeactivateKey is not supported, since API keys can only be deleted
+func (Provider) DeactivateKey(p cloud.Profile, id string) error {
+	return cloud.ErrNotSupported
+}
+
+// DeleteKey deletes an API key of the user by its fingerprint. Once the key
+// replaced by WriteCredential is deleted, its key file is removed too, unless
+// a profile of the config file still uses it. With backups, the key file is
+// left for the backups of the config file that point at it, and removed when
+// they are pruned.
+func (Provider) DeleteKey(p cloud.Profile, id string) error {
+	s, c, err := signerFor(p, p.Cred)
+	if err != nil {
+		return err
+	}
+	if err := s.call(c.region, "DELETE", users(c.user)+"/apiKeys/"+id, nil, nil); err != nil {
+		return err
+	}
+	if BackupDir != "" || c.original == nil || id != c.original.fingerprint || c.original.keyFile == c.keyFile {
+		return nil
+	}
+	configs, err := parseConfigFile(c.file)
+	if err != nil {
+		return err
+	}
+	for _, other := range configs {
+		if other.keyFile == c.original.keyFile {
+			return nil
+		}
+	}
+	if err := os.Remove(c.original.keyFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Verify checks whether the user can sign requests with the key
+func (Provider) Verify(p cloud.Profile, cred cloud.Credential) error {
+	s, c, err := signerFor(p, cred)
+	if err != nil {
+		return err
+	}
+	return s.call(c.region, "GET", users(c.user), nil, nil)
+}
+
+// WriteCredential saves the private key next to the profile's key file and
+// points key_file and fingerprint at it, in every profile of the config file
+// that used the old key. The config file is backed up first. The old key
+// file is left in place at least until DeleteKey deletes the old key, so
+// writing its credential back only changes the config file.
+func (Provider) WriteCredential(p *cloud.Profile, cred cloud.Credential) error {
+	c, err := configOf(*p)
+	if err != nil {
+		return err
+	}
+	var keyFile string
+	if c.original != nil && cred.ID == c.original.fingerprint {
+		keyFile = c.original.keyFile
+	} else {
+		keyFile = newKeyFilename(c.keyFile, time.Now())
+		if err := atomicfile.WriteFile(keyFile, []byte(cred.Secret), keyFileMode); err != nil {
+			return err
+		}
+	}
+	if err := backupFile(c.file); err != nil {
+		return err
+	}
+	if err := setKey(c.file, c.keyFile, c.fingerprint, keyFile, cred.ID); err != nil {
+		return err
+	}
+	if c.original == nil {
+		original := *c
+		c.original = &original
+	}
+	c.keyFile, c.fingerprint = keyFile, cred.ID
+	p.Cred = cred
+	return nil
+}
+
+// timestampSuffix is the suffix newKeyFilename adds to key files
+var timestampSuffix = regexp.MustCompile(`-\d{14}$`)
+
+// newKeyFilename names a new key file after the old one and the time
+func newKeyFilename(old string, now time.Time) string {
+	ext := filepath.Ext(old)
+	stem := timestampSuffix.ReplaceAllString(strings.TrimSuffix(old, ext), "")
+	if ext == "" {
+		ext = ".pem"
+	}
+	return stem + "-" + now.UTC().Format("20060102150405") + ext
+}
@@ -0,0 +1,231 @@
+package oci
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	tenancyID = "ocid1.tenancy.oc1..aaaaexample"
+	userID    = "ocid1.user.oc1..aaaaexample"
+)
+
+func mustFingerprint(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	id, err := fingerprint(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// configDirWith writes a key file and an OCI config file to a temporary
+// directory and points OCI_CLI_CONFIG_FILE at it. The config file can refer
+// to the key file as {{key_file}}.
+func configDirWith(t *testing.T, key *rsa.PrivateKey, content string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "oci_api_key.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	content = strings.Replace(content, "{{key_file}}", keyFile, -1)
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("OCI_CLI_CONFIG_FILE", filepath.Join(dir, "config"))
+	os.Unsetenv("OCI_CLI_PROFILE")
+	return dir, func() {
+		os.Unsetenv("OCI_CLI_CONFIG_FILE")
+		os.Unsetenv("OCI_CLI_PROFILE")
+		os.RemoveAll(dir)
+	}
+}
+
+const configFile = `[DEFAULT]
+user = ` + userID + `
+tenancy = ` + tenancyID + `
+region = us-ashburn-1
+fingerprint = {{fingerprint}}
+key_file = {{key_file}}
+
+[PHOENIX]
+region = us-phoenix-1
+
+[SESSION]
+fingerprint = 11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff:00
+key_file = {{key_file}}
+security_token_file = /tmp/token
+`
+
+func TestProfiles(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := mustFingerprint(t, key)
+	dir, cleanup := configDirWith(t, key, strings.Replace(configFile, "{{fingerprint}}", id, -1))
+	defer cleanup()
+	os.Setenv("OCI_CLI_PROFILE", "PHOENIX")
+
+	got, err := Provider{}.Profiles()
+
+	if err != nil {
+		t.Fatalf("got error %q but didn't want one", err)
+	}
+	type summary struct {
+		Name, Kind, Region, Account, UserName, KeyID string
+		IsCurrent, Rotatable                         bool
+	}
+	var summaries []summary
+	for _, p := range got {
+		if p.File != filepath.Join(dir, "config") || p.Source != SourceConfigFile || p.Cred.Secret == "" {
+			t.Errorf("got profile %s with file %q, source %q and secret %q", p.Name, p.File, p.Source, p.Cred.Secret)
+		}
+		summaries = append(summaries, summary{p.Name, p.Kind, p.Region, p.Account, p.UserName, p.Cred.ID, p.IsCurrent, p.Rotatable})
+	}
+	want := []summary{
+		{"DEFAULT", KindAPIKey, "us-ashburn-1", tenancyID, userID, id, false, true},
+		{"PHOENIX", KindAPIKey, "us-phoenix-1", tenancyID, userID, id, true, true},
+		{"SESSION", KindSessionToken, "us-ashburn-1", tenancyID, userID, "11:22:33:44:55:66:77:88:99:aa:bb:cc:dd:ee:ff:00", false, false},
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("got %+v but want %+v", summaries, want)
+	}
+}
+
+func TestSign(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := signer{tenancy: tenancyID, user: userID, fingerprint: mustFingerprint(t, key), key: key}
+	body := []byte(`{"key":"public"}`)
+	req, err := http.NewRequest("POST", "https://identity.us-ashburn-1.oraclecloud.com/20160918/users/"+userID+"/apiKeys/", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if err := s.sign(req, body); err != nil {
+		t.Fatalf("got error %q but didn't want one", err)
+	}
+
+	m := regexp.MustCompile(`^Signature version="1",keyId="([^"]*)",algorithm="rsa-sha256",headers="([^"]*)",signature="([^"]*)"$`).FindStringSubmatch(req.Header.Get("Authorization"))
+	if m == nil {
+		t.Fatalf("got Authorization %q", req.Header.Get("Authorization"))
+	}
+	if want := tenancyID + "/" + userID + "/" + s.fingerprint; m[1] != want {
+		t.Errorf("got keyId %q but want %q", m[1], want)
+	}
+	if want := "(request-target) date host content-length content-type x-content-sha256"; m[2] != want {
+		t.Errorf("got headers %q but want %q", m[2], want)
+	}
+	sum := sha256.Sum256(body)
+	if got, want := req.Header.Get("X-Content-Sha256"), base64.StdEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("got X-Content-Sha256 %q but want %q", got, want)
+	}
+	signed := strings.Join([]string{
+		"(request-target): post /20160918/users/" + userID + "/apiKeys/",
+		"date: " + req.Header.Get("Date"),
+		"host: identity.us-ashburn-1.oraclecloud.com",
+		"content-length: " + strconv.Itoa(len(body)),
+		"content-type: application/json",
+		"x-content-sha256: " + req.Header.Get("X-Content-Sha256"),
+	}, "\n")
+	sig, _ := base64.StdEncoding.DecodeString(m[3])
+	sum = sha256.Sum256([]byte(signed))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("got a signature that doesn't verify: %v", err)
+	}
+}
+
+func TestPassPhrase(t *testing.T) {
+	key, data, err := newKey("s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parsePrivateKey(data, ""); err != ErrPassPhraseNeeded {
+		t.Errorf("got error %v but want %v", err, ErrPassPhraseNeeded)
+	}
+	got, err := parsePrivateKey(data, "s3cret")
+	if err != nil {
+		t.Fatalf("got error %q but didn't want one", err)
+	}
+	if !reflect.DeepEqual(got.PublicKey, key.PublicKey) {
+		t.Error("got another key than the one written")
+	}
+}
+
+func TestNewKeyFilename(t *testing.T) {
+	now := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	tests := map[string]string{
+		"/home/u/.oci/oci_api_key.pem":                "/home/u/.oci/oci_api_key-20261018093000.pem",
+		"/home/u/.oci/oci_api_key-20250101000000.pem": "/home/u/.oci/oci_api_key-20261018093000.pem",
+		"/home/u/.oci/key":                            "/home/u/.oci/key-20261018093000.pem",
+	}
+	for old, want := range tests {
+		if got := newKeyFilename(old, now); got != want {
+			t.Errorf("got %q for %q but want %q", got, old, want)
+		}
+	}
+}
+
+func TestBackupFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudkey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	BackupDir, BackupKeep = filepath.Join(dir, "backups"), 1
+	defer func() { BackupDir, BackupKeep = "", 10 }()
+
+	config := filepath.Join(dir, "config")
+	writeConfig := func(keyFile string) {
+		data := "[DEFAULT]\nfingerprint = 00:00\nkey_file = " + keyFile + "\n"
+		if err := ioutil.WriteFile(config, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyFile, []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first, second := filepath.Join(dir, "first.pem"), filepath.Join(dir, "second.pem")
+
+	writeConfig(first)
+	if err := backupFile(config); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(second)
+	if err := backupFile(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Errorf("got %v but want the key file of the pruned backup removed", err)
+	}
+	if _, err := os.Stat(second); err != nil {
+		t.Errorf("got %v but want the key file of the kept backup kept", err)
+	}
+}
@@ -0,0 +1,242 @@
+package cloud
+
+import (
+	"fmt"
+	"time"
+)
+
+// Defaults for verifying that a new key has propagated
+const (
+	DefaultVerifyTimeout     = 2 * time.Minute
+	DefaultVerifyInterval    = time.Second
+	DefaultVerifyMaxInterval = 10 * time.Second
+)
+
+// Steps of a key rotation, reported in a RotateError
+const (
+	StepLookup          = "Lookup"
+	StepListKeys        = "ListKeys"
+	StepPreRotate       = "PreRotate"
+	StepCreateKey       = "CreateKey"
+	StepVerify          = "Verify"
+	StepWriteCredential = "WriteCredential"
+	StepDeliver         = "Deliver"
+	StepPostRotate      = "PostRotate"
+	StepDeactivateKey   = "DeactivateKey"
+	StepDeleteKey       = "DeleteKey"
+)
+
+// RotateError is returned when a step of a key rotation fails
+type RotateError struct {
+	Step string
+	Err  error
+}
+
+func (e *RotateError) Error() string {
+	return e.Step + ": " + e.Err.Error()
+}
+
+// RotateOptions changes how Rotate replaces a key
+type RotateOptions struct {
+	// VerifyTimeout is how long to wait for the new key to be accepted
+	VerifyTimeout time.Duration
+	// VerifyInterval is the delay before retrying verification. It doubles
+	// after every failed attempt, up to VerifyMaxInterval.
+	VerifyInterval    time.Duration
+	VerifyMaxInterval time.Duration
+	// MinAge skips the rotation if the key is younger. Zero always rotates.
+	MinAge time.Duration
+	// PreRotate runs before anything is changed. An error stops the rotation.
+	PreRotate func(Rotation) error
+	// PostRotate runs once the new key is saved locally, before the old key
+	// is deactivated. An error rolls the rotation back.
+	PostRotate func(Rotation, Credential) error
+	// Deliver hands the variables of a new key to the user for profiles of
+	// an EnvironProvider in environment variables, since cloudkey cannot
+	// change the environment of the shell that started it. It is also called
+	// with the old key if the rotation is rolled back. Rotating such a
+	// profile fails without it, before anything is changed.
+	Deliver func(Credential, []EnvVar) error
+	// Options are the provider's own options for a Rotator, like
+	// aws.RotateOptions
+	Options interface{}
+}
+
+// WithDefaults fills in the default verify timings. Providers with their own
+// options, like aws.RotateOptions, use it for theirs too.
+func (o RotateOptions) WithDefaults() RotateOptions {
+	if o.VerifyTimeout <= 0 {
+		o.VerifyTimeout = DefaultVerifyTimeout
+	}
+	if o.VerifyInterval <= 0 {
+		o.VerifyInterval = DefaultVerifyInterval
+	}
+	if o.VerifyMaxInterval < o.VerifyInterval {
+		o.VerifyMaxInterval = DefaultVerifyMaxInterval
+		if o.VerifyMaxInterval < o.VerifyInterval {
+			o.VerifyMaxInterval = o.VerifyInterval
+		}
+	}
+	return o
+}
+
+// Rotation is the result of rotating the key of a profile
+type Rotation struct {
+	Cloud    string
+	Profile  string
+	UserName string
+	OldKeyID string
+	NewKeyID string
+	Started  time.Time
+	Finished time.Time
+	// OldKeyCreated is when the old key was created, if the cloud knows
+	OldKeyCreated time.Time
+	// RolledBack is set when the new key was deleted after a failure
+	RolledBack bool
+	// Skipped explains why the key was not rotated, such as being younger
+	// than RotateOptions.MinAge. Nothing is changed if it is set.
+	Skipped string
+	// Data is a Rotator's own result, like aws.Rotation
+	Data interface{}
+}
+
+// Rotate replaces the key of a profile: it creates a new key, waits until the
+// cloud accepts it, saves it locally, then deactivates and deletes the old
+// key with the new one. If a step fails before the old key is deactivated,
+// the new key is deleted and the old key is kept.
+//
+// A Rotator rotates the key itself, and its errors are its own rather than a
+// *RotateError.
+func Rotate(pr Provider, p *Profile, opts RotateOptions) (Rotation, error) {
+	if rr, ok := pr.(Rotator); ok {
+		return rr.RotateKey(p, opts)
+	}
+	opts = opts.WithDefaults()
+	r := Rotation{Cloud: pr.Name(), Profile: p.Name, OldKeyID: p.Cred.ID, Started: time.Now()}
+	if !p.Rotatable {
+		return r, &RotateError{Step: StepLookup, Err: ErrNotRotatable}
+	}
+	// Only environment variables of an EnvironProvider need delivering. Other
+	// providers, like Google Cloud with its key file, write them in place.
+	ep, delivers := pr.(EnvironProvider)
+	delivers = delivers && p.Source == SourceEnviron
+	if delivers && opts.Deliver == nil {
+		return r, &RotateError{Step: StepDeliver, Err: ErrNoDelivery}
+	}
+	if p.UserName == "" {
+		if err := pr.Lookup(p); err != nil {
+			return r, &RotateError{Step: StepLookup, Err: err}
+		}
+	}
+	// Lookup may only now have found the ID of the key
+	r.UserName, r.OldKeyID = p.UserName, p.Cred.ID
+
+	// Leave keys that are not due yet
+	keys, err := pr.Keys(*p)
+	if err != nil {
+		return r, &RotateError{Step: StepListKeys, Err: err}
+	}
+	for _, key := range keys {
+		if key.ID == p.Cred.ID {
+			r.OldKeyCreated = key.Created
+		}
+	}
+	if opts.MinAge > 0 && !r.OldKeyCreated.IsZero() {
+		if age := r.Started.Sub(r.OldKeyCreated); age < opts.MinAge {
+			r.Skipped = fmt.Sprintf("key is %s old, younger than %s", FormatAge(age), FormatAge(opts.MinAge))
+			return r, nil
+		}
+	}
+
+	if opts.PreRotate != nil {
+		if err := opts.PreRotate(r); err != nil {
+			return r, &RotateError{Step: StepPreRotate, Err: err}
+		}
+	}
+
+	cred, err := pr.CreateKey(*p)
+	if err != nil {
+		return r, &RotateError{Step: StepCreateKey, Err: err}
+	}
+	r.NewKeyID = cred.ID
+	rollback := func(step string, cause error) (Rotation, error) {
+		if err := pr.DeleteKey(*p, cred.ID); err != nil {
+			return r, &RotateError{Step: step, Err: fmt.Errorf("%v (rollback failed at %s: %v)", cause, StepDeleteKey, err)}
+		}
+		r.RolledBack = true
+		return r, &RotateError{Step: step, Err: cause}
+	}
+
+	if !Poll(opts, func() bool { return pr.Verify(*p, cred) == nil }) {
+		return rollback(StepVerify, ErrKeyNotAccepted)
+	}
+	old := p.Cred
+	if err := pr.WriteCredential(p, cred); err != nil {
+		return rollback(StepWriteCredential, err)
+	}
+	// restore writes the old key back, and delivers it too if the new one
+	// may have been delivered
+	restore := func(step string, cause error, deliver bool) (Rotation, error) {
+		if err := pr.WriteCredential(p, old); err != nil {
+			return r, &RotateError{Step: step, Err: fmt.Errorf("%v (rollback failed at %s: %v)", cause, StepWriteCredential, err)}
+		}
+		if deliver {
+			if err := opts.Deliver(old, ep.EnvVars(old)); err != nil {
+				return r, &RotateError{Step: step, Err: fmt.Errorf("%v (rollback failed at %s: %v)", cause, StepDeliver, err)}
+			}
+		}
+		return rollback(step, cause)
+	}
+	if delivers {
+		if err := opts.Deliver(cred, ep.EnvVars(cred)); err != nil {
+			return restore(StepDeliver, err, false)
+		}
+	}
+	if opts.PostRotate != nil {
+		if err := opts.PostRotate(r, cred); err != nil {
+			return restore(StepPostRotate, err, delivers)
+		}
+	}
+
+	// The new key is in use now, so there is no rolling back after this
+	if err := pr.DeactivateKey(*p, old.ID); err != nil && err != ErrNotSupported {
+		return r, &RotateError{Step: StepDeactivateKey, Err: err}
+	}
+	if err := pr.DeleteKey(*p, old.ID); err != nil {
+		return r, &RotateError{Step: StepDeleteKey, Err: err}
+	}
+	r.Finished = time.Now()
+	return r, nil
+}
+
+// Poll calls accepted with exponential backoff until it returns true, or
+// reports false once opts.VerifyTimeout has passed
+func Poll(opts RotateOptions, accepted func() bool) bool {
+	deadline := time.Now().Add(opts.VerifyTimeout)
+	interval := opts.VerifyInterval
+	for {
+		if accepted() {
+			return true
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return false
+		}
+		time.Sleep(interval)
+		interval *= 2
+		if interval > opts.VerifyMaxInterval {
+			interval = opts.VerifyMaxInterval
+		}
+	}
+}
+
+// FormatAge formats a key age in days, or as a duration if shorter than a day
+func FormatAge(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d < day:
+		return d.Round(time.Second).String()
+	case d < 2*day:
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", d/day)
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/history"
+)
+
+// rotateResult is the outcome of rotating one profile of a bulk rotation
+type rotateResult struct {
+	Profile  string
+	Rotation cloudAWS.Rotation
+	Checks   []cloudAWS.RoleCheck
+	Skipped  string
+	Err      error
+}
+
+// failed checks whether the rotation, or a check of a role using it, failed
+func (r rotateResult) failed() bool {
+	if r.Err != nil {
+		return true
+	}
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func bulkRotateFunc() {
+	if profileName != "" {
+		fmt.Fprintln(os.Stderr, "--profile can't be used with --all or --profiles")
+		os.Exit(1)
+	}
+	if concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "--concurrency must be at least 1")
+		os.Exit(1)
+	}
+
+	profiles, err := cloudAWS.FromConfigFile(false)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	opts, err := rotateOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	s, _ := appSettings()
+	excluded := func(name string) bool {
+		return s.Policy(cloudAWS.Name, name).ExcludeFromBulk
+	}
+	targets, results, err := selectProfiles(profiles, rotateAll, profileNames, excluded)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	results = append(results, rotateProfiles(targets, profiles, opts, concurrency, nil)...)
+	if !renderRotateResults(results) {
+		os.Exit(1)
+	}
+}
+
+// selectProfiles picks the profiles to rotate: every profile with an access key
+// if all is set, otherwise the profiles matching the patterns. Role profiles
+// are followed to their source profile, and each source profile is only
+// rotated once. Profiles named without a pattern that can't be rotated are
+// returned as failed results; pattern matches that can't be rotated are left out.
+// Profiles excluded from bulk rotations are only rotated when named without a
+// pattern.
+func selectProfiles(profiles cloudAWS.Profiles, all bool, patterns []string, excluded func(string) bool) ([]cloudAWS.Profile, []rotateResult, error) {
+	var targets []cloudAWS.Profile
+	var failed []rotateResult
+	selected := make(map[string]bool)
+	add := func(p cloudAWS.Profile) {
+		if !selected[p.Name] {
+			selected[p.Name] = true
+			targets = append(targets, p)
+		}
+	}
+
+	if all {
+		for _, p := range profiles.Profiles {
+			if p.Rotatable() && !excluded(p.Name) {
+				add(p)
+			}
+		}
+		return targets, nil, nil
+	}
+
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, nil, fmt.Errorf("Bad profile pattern %q: %v", pattern, err)
+		}
+		isPattern := strings.ContainsAny(pattern, `*?[\`)
+		matched := false
+		for _, p := range profiles.Profiles {
+			if ok, _ := path.Match(pattern, p.Name); !ok {
+				continue
+			}
+			matched = true
+			chain, err := profiles.SourceChain(p.Name)
+			if err != nil {
+				if !isPattern {
+					failed = append(failed, rotateResult{Profile: p.Name, Err: err})
+				}
+				continue
+			}
+			source := chain[len(chain)-1]
+			if isPattern && excluded(source.Name) {
+				continue
+			}
+			add(source)
+		}
+		if !matched {
+			return nil, nil, fmt.Errorf("No profile matches %q", pattern)
+		}
+	}
+	return targets, failed, nil
+}
+
+// rotateProfiles rotates the profiles with up to concurrency rotations at a
+// time. The results are in the same order as the profiles. Once stop is
+// closed, the rotations already started are finished and the rest are skipped.
+func rotateProfiles(targets []cloudAWS.Profile, profiles cloudAWS.Profiles, opts cloudAWS.RotateOptions, concurrency int, stop <-chan struct{}) []rotateResult {
+	results := make([]rotateResult, len(targets))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				p := targets[i]
+				rotation, checks, err := rotateProfile(p, profiles, opts)
+				results[i] = rotateResult{Profile: p.Name, Rotation: rotation, Checks: checks, Skipped: rotation.Skipped, Err: err}
+				switch {
+				case err != nil:
+					fmt.Fprintf(statusOutput, "Failed to rotate %s: %v\n", displayName(p.Name), err)
+				case rotation.Skipped != "":
+					fmt.Fprintf(statusOutput, "Skipped %s: %s\n", displayName(p.Name), rotation.Skipped)
+				default:
+					fmt.Fprintf(statusOutput, "Rotated %s\n", displayName(p.Name))
+				}
+			}
+		}()
+	}
+	stopped := false
+	for i, p := range targets {
+		if !stopped {
+			select {
+			case jobs <- i:
+				continue
+			case <-stop:
+				stopped = true
+			}
+		}
+		rotation := cloudAWS.Rotation{Profile: p.Name, OldKeyID: p.Cred.AccessKeyID, Skipped: "stopped before rotating"}
+		results[i] = rotateResult{Profile: p.Name, Rotation: rotation, Skipped: rotation.Skipped}
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+// renderRotateResults prints a summary of a bulk rotation. It returns false
+// if any profile failed.
+func renderRotateResults(results []rotateResult) bool {
+	table := newTable()
+	table.SetHeader([]string{"Profile", "Result", "Old Access Key ID", "New Access Key ID", "Detail"})
+
+	ok := true
+	for _, r := range results {
+		result, detail := rotateOutcome(r.Rotation, r.Err), ""
+		switch {
+		case r.Skipped != "":
+			detail = r.Skipped
+		case r.Err != nil:
+			detail = r.Err.Error()
+		default:
+			var notes []string
+			if use := keyUseMessage(r.Rotation); use != "" {
+				notes = append(notes, use)
+			}
+			if !r.Rotation.DeleteAfter.IsZero() {
+				notes = append(notes, "old key deactivated until "+r.Rotation.DeleteAfter.Format(time.RFC3339))
+			}
+			for _, check := range r.Checks {
+				switch {
+				case check.Err != nil:
+					result = history.Failed
+					notes = append(notes, check.Profile+" cannot assume role: "+check.Err.Error())
+				case check.Skipped != "":
+					notes = append(notes, check.Profile+" not checked: "+check.Skipped)
+				}
+			}
+			detail = strings.Join(notes, "; ")
+		}
+		// Keep multi-line AWS errors on one row
+		detail = strings.Join(strings.Fields(detail), " ")
+		if r.failed() {
+			ok = false
+		}
+		table.Append([]string{
+			r.Profile,
+			result,
+			obfuscateString(r.Rotation.OldKeyID, 4),
+			obfuscateString(r.Rotation.NewKeyID, 4),
+			detail,
+		})
+	}
+	table.Render()
+	return ok
+}
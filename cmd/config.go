@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with cloudkey's config file",
+	Long: `Cloudkey reads its settings from ~/.cloudkey.yaml, or the file given with
+--config. The defaults apply to every AWS profile, and a profile's own
+settings under profiles override them. The profiles of other clouds have their
+own defaults and profiles under clouds, without the AWS-only grace_period,
+require_mfa and account_id:
+
+  defaults:
+    max_key_age: 90d         # like --older-than
+    grace_period: 7d         # like --grace
+    require_mfa: false       # like --mfa
+    exclude_from_bulk: false # leave out of rotate --all and patterns
+    account_id: "123456789012"
+    hooks:
+      pre: []
+      post: []
+  backups:
+    keep: 10
+  profiles:
+    lab:
+      max_key_age: 30d
+      require_mfa: true
+  clouds:
+    gcp:
+      defaults:
+        max_key_age: 30d
+
+Durations are Go durations like 12h, or whole days and weeks like 90d and 2w.`,
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for unknown keys and bad values",
+	Long: `Validate reads the config file and reports every unknown key and bad value,
+then exits with an error if there were any. Rotate refuses to run with a bad
+config file, so run validate after changing it.`,
+	Run: configValidateFunc,
+}
+
+func configValidateFunc(cmd *cobra.Command, args []string) {
+	if err := viper.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			fmt.Fprintln(os.Stderr, "No config file found")
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	filename := viper.ConfigFileUsed()
+	if _, err := loadSettings(); err != nil {
+		if serr, ok := err.(*settings.Error); ok {
+			for _, p := range serr.Problems {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", filename, p)
+			}
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid\n", filename)
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+}
@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/schedule"
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+	"github.com/spf13/cobra"
+)
+
+// daemonCmd represents the daemon command
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Rotate access keys on a schedule",
+	Long: `Daemon keeps running and checks the access keys of every profile on a schedule,
+rotating the ones older than their max_key_age in ~/.cloudkey.yaml. Profiles
+without a max_key_age, with require_mfa or with exclude_from_bulk are left
+alone, since nobody is around to decide or to enter an MFA code.
+
+The --schedule is a cron expression like "30 3 * * *" (minute, hour, day of
+month, month, day of week), one of @hourly, @daily, @weekly or @monthly, or an
+interval like 6h or 1d. Daemon checks once when it starts, then on the
+schedule. --jitter waits a random time of up to the given duration before each
+check, so that many machines don't rotate at the same moment.
+
+Everything the daemon does is written to ~/.cloudkey/daemon.log (or the file
+given with --audit-log) as well as stdout, and each rotation is also recorded
+in the history. On SIGTERM or an interrupt, the daemon finishes the rotations
+already started, skips the rest and stops.
+
+Use --once to check once and exit, which is what the timer or crontab entry of
+"cloudkey schedule install" runs.`,
+	Run: daemonFunc,
+}
+
+func daemonFunc(cmd *cobra.Command, args []string) {
+	sched, err := parseSchedule(scheduleSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if concurrency < 1 {
+		fmt.Fprintln(os.Stderr, "--concurrency must be at least 1")
+		os.Exit(1)
+	}
+	opts, err := rotateOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	path := auditLog
+	if path == "" {
+		if path, err = cloudkeyPath(auditLogFileName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	audit := log.New(io.MultiWriter(os.Stdout, f), "", log.LstdFlags)
+	statusOutput = auditWriter{audit}
+
+	// Stop between rotations on SIGTERM or an interrupt
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		s := <-signals
+		audit.Printf("Received %s, stopping once the rotations already started are done", s)
+		close(stop)
+	}()
+
+	if once {
+		if !waitUntil(schedule.Jitter(time.Now(), jitter), stop) {
+			return
+		}
+		if !daemonCheck(opts, audit, stop) {
+			f.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
+	audit.Printf("Started with schedule %s", sched)
+	next := time.Now()
+	for {
+		if !waitUntil(schedule.Jitter(next, jitter), stop) {
+			break
+		}
+		daemonCheck(opts, audit, stop)
+		if stopping(stop) {
+			break
+		}
+		if next = sched.Next(time.Now()); next.IsZero() {
+			audit.Printf("Schedule %s never comes up again", sched)
+			break
+		}
+		audit.Printf("Next check at %s", next.Format(time.RFC3339))
+	}
+	audit.Print("Stopped")
+}
+
+// daemonCheck rotates the access keys that are due. It returns false if any
+// rotation failed.
+func daemonCheck(opts cloudAWS.RotateOptions, audit *log.Logger, stop <-chan struct{}) bool {
+	audit.Print("Checking access keys")
+	profiles, err := cloudAWS.FromConfigFile(false)
+	if err != nil {
+		audit.Printf("Failed to read the profiles: %v", err)
+		return false
+	}
+	// Earlier checks may have rotated keys that local profiles now use
+	opts.LocalKeys = cloudAWS.LocalAccessKeys()
+	s, _ := appSettings()
+	excluded := func(name string) bool {
+		return s.Policy(cloudAWS.Name, name).ExcludeFromBulk
+	}
+	candidates, _, err := selectProfiles(profiles, true, nil, excluded)
+	if err != nil {
+		audit.Printf("Failed to select the profiles: %v", err)
+		return false
+	}
+
+	var targets []cloudAWS.Profile
+	skipped := 0
+	for _, p := range candidates {
+		policy := s.Policy(cloudAWS.Name, p.Name)
+		switch {
+		case policy.MaxKeyAge == 0:
+			audit.Printf("Skipped %s: no max_key_age set", displayName(p.Name))
+		case policy.RequireMFA:
+			audit.Printf("Skipped %s: require_mfa needs someone to enter an MFA code", displayName(p.Name))
+		default:
+			targets = append(targets, p)
+			continue
+		}
+		skipped++
+	}
+
+	// rotateProfiles reports each profile as it is done, so only add the
+	// details here
+	rotated, failed := 0, 0
+	for _, r := range rotateProfiles(targets, profiles, opts, concurrency, stop) {
+		if r.Err == nil && r.Skipped == "" {
+			rotated++
+			audit.Printf("Replaced %s with %s for %s", obfuscateString(r.Rotation.OldKeyID, 4), obfuscateString(r.Rotation.NewKeyID, 4), displayName(r.Profile))
+			if use := keyUseMessage(r.Rotation); use != "" {
+				audit.Print(use)
+			}
+		}
+		if r.Skipped != "" {
+			skipped++
+		}
+		for _, check := range r.Checks {
+			if check.Err != nil {
+				audit.Printf("Profile %s cannot assume %s: %v", check.Profile, check.RoleARN, check.Err)
+			}
+		}
+		if r.failed() {
+			failed++
+		}
+	}
+	audit.Printf("Checked %d profiles: %d rotated, %d skipped, %d failed", len(candidates), rotated, skipped, failed)
+	return failed == 0
+}
+
+// waitUntil waits until the given time. It returns false if stop was closed
+// first. The wall clock is checked every minute, since a timer doesn't run
+// while a laptop sleeps.
+func waitUntil(t time.Time, stop <-chan struct{}) bool {
+	for {
+		d := time.Until(t)
+		if d <= 0 {
+			return true
+		}
+		if d > time.Minute {
+			d = time.Minute
+		}
+		select {
+		case <-time.After(d):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// stopping checks whether stop was closed
+func stopping(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseSchedule parses a cron expression, or an interval like 6h or 1d
+func parseSchedule(spec string) (schedule.Schedule, error) {
+	if d, err := settings.ParseDuration(spec); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("Bad schedule %q: the interval must be longer than 0", spec)
+		}
+		return schedule.Interval(d), nil
+	}
+	return schedule.Parse(spec)
+}
+
+// auditWriter writes each line to the audit log
+type auditWriter struct {
+	log *log.Logger
+}
+
+func (w auditWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.log.Print(line)
+	}
+	return len(p), nil
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().StringVar(&scheduleSpec, "schedule", "@daily", "When to check the access keys. A cron expression like '30 3 * * *', or an interval like '6h' or '1d'.")
+	daemonCmd.Flags().DurationVar(&jitter, "jitter", 0, "Wait a random time of up to this long before each check")
+	daemonCmd.Flags().BoolVar(&once, "once", false, "Check once, after the jitter, and exit")
+	daemonCmd.Flags().StringVar(&auditLog, "audit-log", "", "Log file (default is $HOME/.cloudkey/daemon.log)")
+	daemonCmd.Flags().IntVar(&concurrency, "concurrency", 4, "How many profiles to rotate at the same time")
+	daemonCmd.Flags().StringVar(&onSecondKey, "on-second-key", string(cloudAWS.SecondKeyFail), "What to do when the user already has two access keys. One of 'fail', 'delete-inactive' or 'delete-oldest-unused'.")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	"github.com/buzzsurfr/cloudkey/internal/shellenv"
+	"github.com/mattn/go-isatty"
+	"github.com/mitchellh/go-homedir"
+)
+
+// statusOutput is where progress messages go. It is stderr while a credential
+// is delivered on stdout, so that stdout can be evaluated by the shell.
+var statusOutput io.Writer = os.Stdout
+
+// deliverToShell sends status messages to stderr, since stdout is used to
+// deliver credentials of the given source
+func deliverToShell(source string) {
+	if source == "EnvironmentVariable" {
+		statusOutput = os.Stderr
+	}
+}
+
+// deliverCredential prints the credential as statements for the user's shell
+// on stdout, so that eval "$(cloudkey rotate)" picks it up, and writes the same
+// statements to --env-file if set. It only fails if the credential reached
+// neither.
+func deliverCredential(cred cloudAWS.Credential) error {
+	return deliverVars(cred.AccessKeyID, []shellenv.Var{
+		{Name: "AWS_ACCESS_KEY_ID", Value: cred.AccessKeyID},
+		{Name: "AWS_SECRET_ACCESS_KEY", Value: cred.SecretAccessKey},
+	})
+}
+
+// canDeliver tells whether a credential can reach the user's shell: stdout is
+// evaluated by the shell rather than shown on a terminal, or --env-file is set
+func canDeliver() bool {
+	return envFile != "" || !isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// deliverVars delivers the variables of a credential like deliverCredential.
+// Statements only shown on a terminal don't reach the shell, so without
+// --env-file it fails with ErrNoDelivery there.
+func deliverVars(id string, vars []shellenv.Var) error {
+	if !canDeliver() {
+		return cloudAWS.ErrNoDelivery
+	}
+	shell := envShell
+	if shell == "" {
+		shell = shellenv.Detect()
+	}
+	exports, err := shellenv.Format(shell, vars)
+	if err != nil {
+		return err
+	}
+
+	delivered := false
+	if envFile != "" {
+		path, err := homedir.Expand(envFile)
+		if err != nil {
+			return err
+		}
+		if err := atomicfile.WriteFile(path, []byte(exports), 0600); err != nil {
+			return err
+		}
+		fmt.Fprintf(statusOutput, "Wrote %s to %s\n", obfuscateString(id, 4), path)
+		delivered = true
+	}
+
+	if _, err := io.WriteString(os.Stdout, exports); err != nil && !delivered {
+		return err
+	}
+	return nil
+}
+
+// deliverEnvVars delivers the variables of a new key of another cloud like
+// deliverCredential
+func deliverEnvVars(cred cloud.Credential, envVars []cloud.EnvVar) error {
+	var vars []shellenv.Var
+	for _, v := range envVars {
+		vars = append(vars, shellenv.Var{Name: v.Name, Value: v.Value})
+	}
+	return deliverVars(cred.ID, vars)
+}
@@ -1,26 +1,52 @@
 package cmd
 
 import (
-	"errors"
-	"strings"
+	"os"
+	"path/filepath"
 
-	"github.com/aws/aws-sdk-go/aws/arn"
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	homedir "github.com/mitchellh/go-homedir"
 )
 
-// UserName gets the user name from the ARN (passed as string)
-func UserName(a string) (string, error) {
-	// Parse ARN
-	resultArn, err := arn.Parse(a)
+// cloudkeyPath gets the path of a file in cloudkey's own directory
+// (~/.cloudkey), creating the directories leading to it if needed
+func cloudkeyPath(elem ...string) (string, error) {
+	home, err := homedir.Dir()
 	if err != nil {
 		return "", err
 	}
-
-	// Verify is a user
-	s := strings.Split(resultArn.Resource, "/")
-	if s[0] != "user" {
-		return "", errors.New("Not a user")
+	path := filepath.Join(append([]string{home, ".cloudkey"}, elem...)...)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
 	}
-	userName := s[1]
+	return path, nil
+}
+
+// journalDir is the directory holding a journal for each rotation in progress
+const journalDir = "journal"
+
+// graceFileName is the file recording old access keys kept for a grace period
+const graceFileName = "grace.json"
+
+// backupDir is the directory holding backups of the credentials and config files
+const backupDir = "backups"
 
-	return userName, nil
+// historyFileName is the log of past rotations
+const historyFileName = "history.jsonl"
+
+// auditLogFileName is the log of what the daemon did
+const auditLogFileName = "daemon.log"
+
+// journalPath gets the path of the journal for rotating a profile
+func journalPath(profile string) (string, error) {
+	return cloudkeyPath(journalDir, cloudAWS.JournalName(profile))
+}
+
+// displayName names a profile in messages. Environment variable credentials
+// have no profile name.
+func displayName(profile string) string {
+	if profile == "" {
+		return "environment variables"
+	}
+	return "profile " + profile
 }
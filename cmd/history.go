@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/history"
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show past rotations",
+	Long: `History shows every rotation recorded in ~/.cloudkey/history.jsonl, oldest
+first. Rotate and recover add an entry for each profile they rotate, whether
+it was rotated, skipped, rolled back or failed. Access key IDs are only
+recorded masked.
+
+Use --profile, --outcome and --since to filter the entries. --since takes a
+date like 2020-03-01, a time like 2020-03-01T09:00:00Z, or an age like 30d.
+Use -o json to print the entries as JSON, one per line.`,
+	Run: historyFunc,
+}
+
+func historyFunc(cmd *cobra.Command, args []string) {
+	var filter history.Filter
+	if cmd.Flags().Changed("profile") {
+		filter.Profile, filter.ProfileSet = profileName, true
+	}
+	filter.Outcome = outcome
+	if since != "" {
+		t, err := parseSince(since, time.Now())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		filter.Since = t
+	}
+	if historyOutput != "table" && historyOutput != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %s, must be 'table' or 'json'\n", historyOutput)
+		os.Exit(1)
+	}
+
+	entries, err := readHistory()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	entries = history.Select(entries, filter)
+
+	if historyOutput == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			enc.Encode(e)
+		}
+		return
+	}
+	table := newTable()
+	table.SetHeader([]string{"Started", "Cloud", "Profile", "Account", "UserName", "Outcome", "Old Access Key ID", "New Access Key ID", "Error"})
+	for _, e := range entries {
+		table.Append([]string{
+			e.Started.Format(time.RFC3339),
+			e.Cloud,
+			e.Profile,
+			e.Account,
+			e.UserName,
+			e.Outcome,
+			e.OldKeyID,
+			e.NewKeyID,
+			// Keep multi-line AWS errors on one row
+			strings.Join(strings.Fields(e.Error), " "),
+		})
+	}
+	table.Render()
+}
+
+// parseSince parses a date, a time or an age before now
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	age, err := settings.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Bad --since %q: must be a date, a time or an age", s)
+	}
+	return now.Add(-age), nil
+}
+
+// readHistory reads the rotation history
+func readHistory() ([]history.Entry, error) {
+	path, err := cloudkeyPath(historyFileName)
+	if err != nil {
+		return nil, err
+	}
+	return history.Read(path)
+}
+
+// rotateOutcome sums up how a rotation ended
+func rotateOutcome(r cloudAWS.Rotation, err error) string {
+	return outcomeOf(r.Skipped, r.RolledBack, err)
+}
+
+// outcomeOf sums up how a rotation of any cloud ended
+func outcomeOf(skipped string, rolledBack bool, err error) string {
+	switch {
+	case skipped != "":
+		return history.Skipped
+	case rolledBack:
+		return history.RolledBack
+	case err != nil:
+		return history.Failed
+	}
+	return history.Rotated
+}
+
+// recordRotation adds a rotation to the history
+func recordRotation(source, account string, r cloudAWS.Rotation, err error) {
+	appendHistory(history.Entry{
+		Cloud:    cloudAWS.Name,
+		Profile:  r.Profile,
+		Source:   source,
+		Account:  account,
+		UserName: r.UserName,
+		OldKeyID: obfuscateString(r.OldKeyID, 4),
+		NewKeyID: obfuscateString(r.NewKeyID, 4),
+		Started:  r.Started,
+		Finished: r.Finished,
+		Outcome:  rotateOutcome(r, err),
+	}, err)
+}
+
+// recordProviderRotation adds a rotation of another cloud to the history
+func recordProviderRotation(p cloud.Profile, r cloud.Rotation, err error) {
+	appendHistory(history.Entry{
+		Cloud:    r.Cloud,
+		Profile:  r.Profile,
+		Source:   p.Source,
+		Account:  p.Account,
+		UserName: r.UserName,
+		OldKeyID: obfuscateString(r.OldKeyID, 4),
+		NewKeyID: obfuscateString(r.NewKeyID, 4),
+		Started:  r.Started,
+		Finished: r.Finished,
+		Outcome:  outcomeOf(r.Skipped, r.RolledBack, err),
+	}, err)
+}
+
+// appendHistory adds an entry to the history. Failing to record it only
+// prints a warning, since the rotation itself is done either way.
+func appendHistory(e history.Entry, err error) {
+	if e.Finished.IsZero() {
+		e.Finished = time.Now()
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	path, herr := cloudkeyPath(historyFileName)
+	if herr == nil {
+		herr = history.Append(path, e)
+	}
+	if herr != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record the rotation of %s in the history: %v\n", displayName(e.Profile), herr)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVarP(&profileName, "profile", "p", "", "Only show rotations of this profile (an empty name is the environment variables)")
+	historyCmd.Flags().StringVar(&outcome, "outcome", "", "Only show rotations with this outcome. One of 'rotated', 'skipped', 'rolled back' or 'failed'.")
+	historyCmd.Flags().StringVar(&since, "since", "", "Only show rotations started since this date, time or age")
+	historyCmd.Flags().StringVarP(&historyOutput, "output", "o", "table", "Output format. One of 'table' or 'json'.")
+}
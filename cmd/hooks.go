@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/hook"
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+)
+
+// hookInput is what a hook gets on stdin. The new access key is only set for
+// post-rotate hooks.
+type hookInput struct {
+	Hook            string `json:"hook"`
+	Cloud           string `json:"cloud"`
+	Profile         string `json:"profile"`
+	UserName        string `json:"userName"`
+	OldAccessKeyID  string `json:"oldAccessKeyId"`
+	AccessKeyID     string `json:"accessKeyId,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+}
+
+// env passes the input to a hook in environment variables
+func (in hookInput) env() []string {
+	env := []string{
+		"CLOUDKEY_HOOK=" + in.Hook,
+		"CLOUDKEY_CLOUD=" + in.Cloud,
+		"CLOUDKEY_PROFILE=" + in.Profile,
+		"CLOUDKEY_USER_NAME=" + in.UserName,
+		"CLOUDKEY_OLD_ACCESS_KEY_ID=" + in.OldAccessKeyID,
+	}
+	if in.AccessKeyID != "" {
+		env = append(env,
+			"CLOUDKEY_ACCESS_KEY_ID="+in.AccessKeyID,
+			"CLOUDKEY_SECRET_ACCESS_KEY="+in.SecretAccessKey,
+		)
+	}
+	return env
+}
+
+// runHooks runs commands one after the other, stopping at the first failure
+func runHooks(commands []string, in hookInput) error {
+	stdin, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	stdin = append(stdin, '\n')
+	for _, command := range commands {
+		fmt.Fprintf(statusOutput, "Running %s-rotate hook for %s: %s\n", in.Hook, displayName(in.Profile), command)
+		h := hook.Hook{
+			Command: command,
+			Env:     in.env(),
+			Stdin:   stdin,
+			Stdout:  statusOutput,
+			Stderr:  os.Stderr,
+		}
+		if err := h.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setHooks adds the pre- and post-rotate hooks of a profile to the rotation
+// options
+func setHooks(opts *cloudAWS.RotateOptions, hooks settings.Hooks) {
+	opts.PreRotate, opts.PostRotate = nil, nil
+	if len(hooks.Pre) > 0 {
+		opts.PreRotate = func(r cloudAWS.Rotation) error {
+			return runHooks(hooks.Pre, hookInput{
+				Hook:           "pre",
+				Cloud:          cloudAWS.Name,
+				Profile:        r.Profile,
+				UserName:       r.UserName,
+				OldAccessKeyID: r.OldKeyID,
+			})
+		}
+	}
+	if len(hooks.Post) > 0 {
+		opts.PostRotate = func(r cloudAWS.Rotation, cred cloudAWS.Credential) error {
+			return runHooks(hooks.Post, hookInput{
+				Hook:            "post",
+				Cloud:           cloudAWS.Name,
+				Profile:         r.Profile,
+				UserName:        r.UserName,
+				OldAccessKeyID:  r.OldKeyID,
+				AccessKeyID:     cred.AccessKeyID,
+				SecretAccessKey: cred.SecretAccessKey,
+			})
+		}
+	}
+}
+
+// setProviderHooks adds the pre- and post-rotate hooks of a profile to the
+// rotation options of another cloud. Hooks get its new key in the same
+// variables as an AWS access key.
+func setProviderHooks(opts *cloud.RotateOptions, hooks settings.Hooks) {
+	opts.PreRotate, opts.PostRotate = nil, nil
+	if len(hooks.Pre) > 0 {
+		opts.PreRotate = func(r cloud.Rotation) error {
+			return runHooks(hooks.Pre, hookInput{
+				Hook:           "pre",
+				Cloud:          r.Cloud,
+				Profile:        r.Profile,
+				UserName:       r.UserName,
+				OldAccessKeyID: r.OldKeyID,
+			})
+		}
+	}
+	if len(hooks.Post) > 0 {
+		opts.PostRotate = func(r cloud.Rotation, cred cloud.Credential) error {
+			return runHooks(hooks.Post, hookInput{
+				Hook:            "post",
+				Cloud:           r.Cloud,
+				Profile:         r.Profile,
+				UserName:        r.UserName,
+				OldAccessKeyID:  r.OldKeyID,
+				AccessKeyID:     cred.ID,
+				SecretAccessKey: cred.Secret,
+			})
+		}
+	}
+}
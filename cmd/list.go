@@ -2,13 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"sort"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sts"
-	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/internal/history"
 	"github.com/mattn/go-colorable"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
@@ -18,169 +17,159 @@ import (
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "Lists all cloud access keys",
-	Long: `List pulls the credentials from environment variables and the credentials file
-and outputs them into a table. The "active" profile (which will be rotated by
-default or used with AWS CLI commands) will be in yellow text.
+	Long: `List pulls the credentials of every cloud provider and outputs them into a
+table, or only those of one provider with --cloud. For AWS, these come from
+environment variables, the credentials file and the shared config file. The
+"active" profile of each provider (which will be rotated by default or used
+with its CLI) will be in yellow text.
+
+The kind shows how each profile gets its credentials. For AWS it is static (a
+long-lived access key, the only kind that can be rotated), assume-role, sso,
+process or web-identity.
 
 Example Output:
-CLOUD   NAME      ACCESS KEY ID          SOURCE
-aws               AKIA************MPLE   EnvironmentVariable
-aws     default   AKIA************G7UP   ConfigFile
+CLOUD   NAME      KIND          REGION      ACCESS KEY ID          SOURCE
+aws               static        us-east-1   AKIA************MPLE   EnvironmentVariable
+aws     admin     assume-role   us-west-2                          ConfigFile
+aws     default   static        us-west-2   AKIA************G7UP   ConfigFile
 `,
 	Run: listFunc,
 }
 
 func listFunc(cmd *cobra.Command, args []string) {
-	// fmt.Println("list called")
-	var profiles cloudAWS.Profiles
-
-	// Check for and add environment variable credentials
-	envProfile, err := cloudAWS.FromEnviron()
-	if err == nil { // we found a profile in env
-		profiles.Profiles = append(profiles.Profiles, envProfile)
+	providers := cloud.Providers()
+	if cloudName != "" {
+		pr, err := cloud.Get(cloudName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		providers = []cloud.Provider{pr}
 	}
 
-	// Parse ~/.aws/credentials file (INI format) for profiles and credentials
-	configProfiles, err := cloudAWS.FromConfigFile(err != nil)
-	if err == nil { // we found profile(s) in config file
-		pChan := make(chan cloudAWS.Profile)
-		for _, p := range configProfiles.Profiles {
-			go func(profile cloudAWS.Profile) {
-				if output == "wide" {
-					profile.NewSession()
-					profile.NewSTS()
-					err := profile.Lookup()
-					if err != nil {
+	var profiles []cloud.Profile
+	for _, pr := range providers {
+		found, err := pr.Profiles()
+		if err != nil {
+			// Warn that some credentials couldn't be read, but continue
+			fmt.Println(err)
+		}
+		pChan := make(chan cloud.Profile)
+		for _, p := range found {
+			go func(pr cloud.Provider, profile cloud.Profile) {
+				// Only profiles with their own key belong to a user
+				if output == "wide" && profile.Rotatable {
+					if err := pr.Lookup(&profile); err != nil {
 						// Warn that this profile couldn't be looked up, but continue
 						fmt.Println(err)
 					}
 				}
 				pChan <- profile
-			}(p)
+			}(pr, p)
 		}
-		for _ = range configProfiles.Profiles {
-			profiles.Profiles = append(profiles.Profiles, <-pChan)
+		for range found {
+			profiles = append(profiles, <-pChan)
 		}
 	}
 
-	// Sort by profile name
-	sort.Slice(profiles.Profiles, func(i, j int) bool { return profiles.Profiles[i].Name < profiles.Profiles[j].Name })
-
-	renderTable(profiles.Profiles) // DEBUG
-}
-
-func getSessionContext(sess *session.Session) (string, error) {
-	var userName string
-
-	// AWS sts:GetCallerIdentity API
-	svc := sts.New(sess)
-	result, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+	// Sort by cloud, then profile name
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].Cloud != profiles[j].Cloud {
+			return profiles[i].Cloud < profiles[j].Cloud
+		}
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	// The history knows when each profile was last rotated, without asking
+	// the cloud
+	lastRotated := make(map[string]map[string]time.Time)
+	if output == "wide" {
+		entries, err := readHistory()
+		if err != nil {
+			// Warn that the history couldn't be read, but continue
+			fmt.Println(err)
+		}
+		for _, pr := range providers {
+			lastRotated[pr.Name()] = history.LastRotated(entries, pr.Name())
 		}
-		return "", err
 	}
 
-	// Parse ARN
-	userName, err = UserName(aws.StringValue(result.Arn))
-	if err != nil {
-		return "", err
-	}
-	return userName, nil
+	renderTable(profiles, lastRotated)
 }
 
-func renderTable(profiles []cloudAWS.Profile) error {
-	table := tablewriter.NewWriter(colorable.NewColorableStdout())
+func renderTable(profiles []cloud.Profile, lastRotated map[string]map[string]time.Time) error {
+	table := newTable()
 	headers := make([]string, 0)
 	switch output {
 	case "wide":
-		headers = []string{"Cloud", "Name", "Account", "UserName", "Access Key ID", "Source"}
+		headers = []string{"Cloud", "Name", "Kind", "Region", "Account", "UserName", "Access Key ID", "Last Rotated", "Source", "File"}
 	default:
-		headers = []string{"Cloud", "Name", "Access Key ID", "Source"}
+		headers = []string{"Cloud", "Name", "Kind", "Region", "Access Key ID", "Source"}
 	}
 	table.SetHeader(headers)
-	table.SetAutoWrapText(false)
-	table.SetAutoFormatHeaders(true)
-	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
-	table.SetAlignment(tablewriter.ALIGN_LEFT)
-	table.SetCenterSeparator("")
-	table.SetColumnSeparator("")
-	table.SetRowSeparator("")
-	table.SetHeaderLine(false)
-	table.SetBorder(false)
-	table.SetTablePadding("   ") // pad with tabs
-	table.SetNoWhiteSpace(true)
 
 	for _, profile := range profiles {
+		var row []string
 		switch output {
 		case "wide":
-			userName, _ := UserName(aws.StringValue(profile.Arn))
-			// Eventually we'll log errors to debug, but this isn't a big deal
-			// if err != nil {
-			// 	fmt.Println(err)
-			// }
-			if profile.IsCurrent {
-				table.Rich([]string{
-					profile.Cloud,
-					profile.Name,
-					aws.StringValue(profile.Account),
-					userName,
-					obfuscateString(profile.Cred.AccessKeyID, 4),
-					profile.Source,
-				}, []tablewriter.Colors{
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-				})
-			} else {
-				table.Append([]string{
-					profile.Cloud,
-					profile.Name,
-					aws.StringValue(profile.Account),
-					userName,
-					obfuscateString(profile.Cred.AccessKeyID, 4),
-					profile.Source,
-				})
+			var rotated string
+			if t, ok := lastRotated[profile.Cloud][profile.Name]; ok {
+				rotated = t.Format(time.RFC3339)
+			}
+			row = []string{
+				profile.Cloud,
+				profile.Name,
+				profile.Kind,
+				profile.Region,
+				profile.Account,
+				profile.UserName,
+				obfuscateString(profile.Cred.ID, 4),
+				rotated,
+				profile.Source,
+				profile.File,
 			}
 		default:
-			if profile.IsCurrent {
-				table.Rich([]string{
-					profile.Cloud,
-					profile.Name,
-					obfuscateString(profile.Cred.AccessKeyID, 4),
-					profile.Source,
-				}, []tablewriter.Colors{
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-					tablewriter.Color(tablewriter.FgYellowColor),
-				})
-			} else {
-				table.Append([]string{
-					profile.Cloud,
-					profile.Name,
-					obfuscateString(profile.Cred.AccessKeyID, 4),
-					profile.Source,
-				})
+			row = []string{
+				profile.Cloud,
+				profile.Name,
+				profile.Kind,
+				profile.Region,
+				obfuscateString(profile.Cred.ID, 4),
+				profile.Source,
 			}
 		}
+		if profile.IsCurrent {
+			colors := make([]tablewriter.Colors, len(row))
+			for i := range colors {
+				colors[i] = tablewriter.Color(tablewriter.FgYellowColor)
+			}
+			table.Rich(row, colors)
+		} else {
+			table.Append(row)
+		}
 	}
 	table.Render()
 
 	return nil
 }
 
+// newTable creates a borderless table on stdout, the way cloudkey prints lists
+func newTable() *tablewriter.Table {
+	table := tablewriter.NewWriter(colorable.NewColorableStdout())
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("   ") // pad with tabs
+	table.SetNoWhiteSpace(true)
+	return table
+}
+
 func obfuscateString(s string, n int) string {
 	var ret string
 	for i, v := range s {
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// mfaInput reads MFA codes typed by the user
+var mfaInput = bufio.NewReader(os.Stdin)
+
+// mfaPrompt keeps concurrent rotations from asking for MFA codes at the same time
+var mfaPrompt sync.Mutex
+
+// promptMFACode asks the user for a code of the MFA device, on stderr so that
+// stdout can still be evaluated by the shell
+func promptMFACode(profile string) func(serial string) (string, error) {
+	return func(serial string) (string, error) {
+		mfaPrompt.Lock()
+		defer mfaPrompt.Unlock()
+		fmt.Fprintf(os.Stderr, "MFA code for %s (%s): ", serial, displayName(profile))
+		line, err := mfaInput.ReadString('\n')
+		code := strings.TrimSpace(line)
+		if code == "" {
+			if err != nil {
+				return "", fmt.Errorf("No MFA code entered: %v", err)
+			}
+			return "", errors.New("No MFA code entered")
+		}
+		if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+			return "", errors.New("MFA code must be 6 digits")
+		}
+		return code, nil
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	"github.com/buzzsurfr/cloudkey/cloud/azure"
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+	"github.com/buzzsurfr/cloudkey/internal/shellenv"
+	"github.com/spf13/cobra"
+)
+
+// awsOnlyFlags are the rotate options only AWS has, since it keeps its own
+// rotation with journals, grace periods, MFA and role checks
+var awsOnlyFlags = []string{"grace", "watch", "watch-interval", "on-use", "mfa", "on-second-key", "concurrency"}
+
+// providerRotateFunc rotates the keys of a cloud other than AWS
+func providerRotateFunc(cmd *cobra.Command, pr cloud.Provider) {
+	for _, name := range awsOnlyFlags {
+		if cmd.Flags().Changed(name) {
+			fmt.Fprintf(os.Stderr, "--%s can only be used with AWS\n", name)
+			os.Exit(1)
+		}
+	}
+	if profileName != "" && (rotateAll || len(profileNames) > 0) {
+		fmt.Fprintln(os.Stderr, "--profile can't be used with --all or --profiles")
+		os.Exit(1)
+	}
+	if cmd.Flags().Changed("secret-expiry") && pr.Name() != azure.Name {
+		fmt.Fprintln(os.Stderr, "--secret-expiry can only be used with Azure")
+		os.Exit(1)
+	}
+	if envShell != "" {
+		if _, err := shellenv.Parse(envShell); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	s, err := appSettings()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if secretExpiry != "" {
+		if azure.SecretExpiry, err = settings.ParseDuration(secretExpiry); err != nil || azure.SecretExpiry <= 0 {
+			fmt.Fprintf(os.Stderr, "Bad --secret-expiry %q: must be a duration like '90d'\n", secretExpiry)
+			os.Exit(1)
+		}
+	}
+	var minAge time.Duration
+	if olderThan != "" {
+		if minAge, err = settings.ParseDuration(olderThan); err != nil {
+			fmt.Fprintf(os.Stderr, "Bad --older-than: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	profiles, err := pr.Profiles()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	excluded := func(name string) bool {
+		return s.Policy(pr.Name(), name).ExcludeFromBulk
+	}
+	targets, err := selectProviderProfiles(pr.Name(), profiles, excluded)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// Credentials in environment variables are delivered on stdout
+	if _, ok := pr.(cloud.EnvironProvider); ok {
+		for _, p := range targets {
+			deliverToShell(p.Source)
+		}
+	}
+
+	failed := false
+	for _, p := range targets {
+		policy := s.Policy(pr.Name(), p.Name)
+		opts := cloud.RotateOptions{
+			VerifyTimeout:     verifyTimeout,
+			VerifyInterval:    verifyInterval,
+			VerifyMaxInterval: verifyMaxInterval,
+			MinAge:            minAge,
+		}
+		if olderThan == "" {
+			opts.MinAge = policy.MaxKeyAge
+		}
+		setProviderHooks(&opts, policy.Hooks)
+		// Without Deliver, profiles in environment variables fail before any
+		// key is created
+		if canDeliver() {
+			opts.Deliver = deliverEnvVars
+		}
+
+		r, err := cloud.Rotate(pr, &p, opts)
+		recordProviderRotation(p, r, err)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to rotate %s: %v\n", displayName(p.Name), err)
+			if r.RolledBack {
+				fmt.Fprintln(os.Stderr, "The new key was removed and the old key was kept.")
+			}
+			failed = true
+		case r.Skipped != "":
+			fmt.Fprintf(statusOutput, "Skipped %s: %s\n", displayName(p.Name), r.Skipped)
+		default:
+			fmt.Fprintf(statusOutput, "Rotated %s to %s for %s\n", obfuscateString(r.OldKeyID, 4), obfuscateString(r.NewKeyID, 4), r.UserName)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// selectProviderProfiles picks the profiles to rotate the same way as for
+// AWS: the one named with --profile, every profile with a key of its own with
+// --all, the ones matching --profiles, or else the current one. Each key is
+// only rotated once, for the first profile that uses it.
+func selectProviderProfiles(name string, profiles []cloud.Profile, excluded func(string) bool) ([]cloud.Profile, error) {
+	var targets []cloud.Profile
+	switch {
+	case profileName != "":
+		for _, p := range profiles {
+			if p.Name == profileName {
+				return []cloud.Profile{p}, nil
+			}
+		}
+		return nil, fmt.Errorf("No %s credential with profile name %s found", name, profileName)
+	case rotateAll:
+		for _, p := range profiles {
+			if p.Rotatable && !excluded(p.Name) {
+				targets = append(targets, p)
+			}
+		}
+		return cloud.Dedupe(targets), nil
+	case len(profileNames) > 0:
+		selected := make(map[string]bool)
+		for _, pattern := range profileNames {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Bad profile pattern %q: %v", pattern, err)
+			}
+			isPattern := strings.ContainsAny(pattern, `*?[\`)
+			matched := false
+			for _, p := range profiles {
+				if ok, _ := path.Match(pattern, p.Name); !ok {
+					continue
+				}
+				matched = true
+				// Named profiles that can't be rotated fail in cloud.Rotate
+				if isPattern && (!p.Rotatable || excluded(p.Name)) || selected[p.Name] {
+					continue
+				}
+				selected[p.Name] = true
+				targets = append(targets, p)
+			}
+			if !matched {
+				return nil, fmt.Errorf("No profile matches %q", pattern)
+			}
+		}
+		return cloud.Dedupe(targets), nil
+	}
+	for _, p := range profiles {
+		if p.IsCurrent {
+			return []cloud.Profile{p}, nil
+		}
+	}
+	return nil, fmt.Errorf("No %s credential found", name)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/spf13/cobra"
+)
+
+// pruneCmd represents the prune command
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old access keys whose grace period is over",
+	Long: `Prune deletes the old access keys that rotate --grace deactivated, once their
+grace period is over. Each access key is deleted with the current access key of
+the profile it was rotated from. Access keys that were reactivated since are
+kept.
+
+Use --dry-run to list the deactivated access keys without deleting anything,
+and --force to delete them before their grace period is over.`,
+	Run: pruneFunc,
+}
+
+func pruneFunc(cmd *cobra.Command, args []string) {
+	graceFile, err := cloudkeyPath(graceFileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	keys, err := cloudAWS.ReadGraceKeys(graceFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(keys) == 0 {
+		fmt.Println("No deactivated access keys found")
+		return
+	}
+
+	now := time.Now()
+	failed := false
+	for _, key := range keys {
+		name := displayName(key.Profile)
+		if !key.Due(now) && !force {
+			fmt.Printf("Keeping %s of %s until %s\n", obfuscateString(key.AccessKeyID, 4), name, key.DeleteAfter.Format(time.RFC3339))
+			continue
+		}
+		if dryRun {
+			fmt.Printf("Would delete %s of %s\n", obfuscateString(key.AccessKeyID, 4), name)
+			continue
+		}
+
+		p, err := graceKeyProfile(key)
+		if err == nil {
+			err = p.PruneKey(key)
+		}
+		if err == nil {
+			err = cloudAWS.RemoveGraceKey(graceFile, key.AccessKeyID)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete %s of %s: %v\n", obfuscateString(key.AccessKeyID, 4), name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Deleted %s of %s\n", obfuscateString(key.AccessKeyID, 4), name)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// graceKeyProfile gets the profile an old access key was rotated from, with an
+// IAM client using its current access key
+func graceKeyProfile(key cloudAWS.GraceKey) (cloudAWS.Profile, error) {
+	var p cloudAWS.Profile
+	var err error
+	if key.Source == "EnvironmentVariable" {
+		p, err = cloudAWS.FromEnviron()
+	} else {
+		p, err = cloudAWS.GetByName(key.Profile)
+	}
+	if err != nil {
+		return p, err
+	}
+	if err := p.NewSession(); err != nil {
+		return p, err
+	}
+	p.NewIAM()
+	return p, nil
+}
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the deactivated access keys without deleting them")
+	pruneCmd.Flags().BoolVar(&force, "force", false, "Delete the deactivated access keys even if their grace period is not over")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/spf13/cobra"
+)
+
+// reactivateCmd represents the reactivate command
+var reactivateCmd = &cobra.Command{
+	Use:   "reactivate [access-key-id...]",
+	Short: "Reactivate old access keys kept for a grace period",
+	Long: `Reactivate turns an old access key that rotate --grace deactivated back on, for
+when something still needs it. Name the access keys to reactivate, or use
+--profile to reactivate the old access keys of a profile (an empty name is the
+environment variables).
+
+A reactivated access key is no longer deleted by prune. The IAM user then has
+two active access keys again, so delete the one you don't need before the next
+rotation.`,
+	Run: reactivateFunc,
+}
+
+func reactivateFunc(cmd *cobra.Command, args []string) {
+	byProfile := cmd.Flags().Changed("profile")
+	if len(args) == 0 && !byProfile {
+		fmt.Fprintln(os.Stderr, "Name the access keys to reactivate, or use --profile")
+		os.Exit(1)
+	}
+	wanted := make(map[string]bool)
+	for _, id := range args {
+		wanted[id] = true
+	}
+
+	graceFile, err := cloudkeyPath(graceFileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	keys, err := cloudAWS.ReadGraceKeys(graceFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	found, failed := false, false
+	for _, key := range keys {
+		if !wanted[key.AccessKeyID] && !(byProfile && key.Profile == profileName) {
+			continue
+		}
+		found = true
+		delete(wanted, key.AccessKeyID)
+		name := displayName(key.Profile)
+
+		p, err := graceKeyProfile(key)
+		if err == nil {
+			err = p.ReactivateKey(key)
+		}
+		if err == nil {
+			err = cloudAWS.RemoveGraceKey(graceFile, key.AccessKeyID)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to reactivate %s of %s: %v\n", obfuscateString(key.AccessKeyID, 4), name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("Reactivated %s of %s\n", obfuscateString(key.AccessKeyID, 4), name)
+	}
+	for id := range wanted {
+		fmt.Fprintf(os.Stderr, "No deactivated access key %s found\n", obfuscateString(id, 4))
+		failed = true
+	}
+	if !found && byProfile {
+		fmt.Fprintf(os.Stderr, "No deactivated access keys of %s found\n", displayName(profileName))
+		failed = true
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(reactivateCmd)
+
+	reactivateCmd.Flags().StringVarP(&profileName, "profile", "p", "", "Reactivate the old access keys of this profile")
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/spf13/cobra"
+)
+
+// recoverCmd represents the recover command
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Finish or roll back an interrupted rotation",
+	Long: `Recover reads the journals left behind by interrupted rotations
+(~/.cloudkey/journal) and finishes each rotation from the last recorded step.
+Use --profile to recover only one profile. If finishing fails, or the
+--rollback option is used, the new access key is deleted and the old access key
+is restored instead.
+
+A rotation can no longer be rolled back once the old access key was deleted.
+Use --mfa if your IAM policies require MFA for changing access keys.
+
+For environment variables, the access key that is kept is printed on stdout
+the same way as by rotate, so run eval "$(cloudkey recover)".`,
+	Run: recoverFunc,
+}
+
+func recoverFunc(cmd *cobra.Command, args []string) {
+	opts, err := rotateOptions()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	dir, err := cloudkeyPath(journalDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	journals, err := cloudAWS.ReadJournals(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	found, failed := false, false
+	for _, j := range journals {
+		if cmd.Flags().Changed("profile") && j.Profile != profileName {
+			continue
+		}
+		found = true
+		deliverToShell(j.Source)
+		fmt.Fprintf(statusOutput, "Recovering rotation of %s (%s) interrupted at step %q\n", displayName(j.Profile), j.UserName, j.Step)
+
+		applyPolicy(&opts, j.Profile)
+		rotation, err := j.Recover(rollback, opts)
+		recordRotation(j.Source, "", rotation, err)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+			continue
+		}
+		if rotation.RolledBack {
+			fmt.Fprintf(statusOutput, "Rolled back to %s\n", obfuscateString(rotation.OldKeyID, 4))
+			continue
+		}
+		fmt.Fprintf(statusOutput, "Rotated %s to %s for %s\n", obfuscateString(rotation.OldKeyID, 4), obfuscateString(rotation.NewKeyID, 4), rotation.UserName)
+	}
+	if !found {
+		fmt.Fprintln(os.Stderr, "No interrupted rotation found")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+
+	recoverCmd.Flags().StringVarP(&profileName, "profile", "p", "", "Only recover the rotation of this profile (an empty name is the environment variables)")
+	recoverCmd.Flags().BoolVar(&useMFA, "mfa", false, "Ask for an MFA code and change the access keys with an MFA session")
+	recoverCmd.Flags().BoolVar(&rollback, "rollback", false, "Roll back the rotation instead of finishing it")
+	recoverCmd.Flags().StringVar(&envShell, "shell", "", "Shell syntax for environment variable credentials. One of 'bash', 'zsh', 'fish' or 'powershell' (default detected from $SHELL)")
+	recoverCmd.Flags().StringVar(&envFile, "env-file", "", "Also write environment variable credentials to this file")
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	cloudOCI "github.com/buzzsurfr/cloudkey/cloud/oci"
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	"github.com/buzzsurfr/cloudkey/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the credentials file from a backup",
+	Long: `Before cloudkey changes the credentials file, the config file or the OCI config
+file, it saves a copy in ~/.cloudkey/backups. The newest 10 backups of each
+file are kept, which backups.keep in ~/.cloudkey.yaml changes (0 keeps every
+backup).
+
+Restore puts a backup back in place of the credentials file (or the config
+file with --file config, or the OCI config file with --file oci). The private
+key files that an OCI backup points at are kept until the backup is pruned.
+It restores the newest backup, or with --at the
+newest backup taken at or before a date like 2020-03-14, a time like
+2020-03-14T09:00:00Z, or an age like 2h. Access key IDs and secrets are
+masked in the differences it shows. The file being replaced is backed up
+first, so a restore can be undone the same way.
+
+Use --list to list the backups, and --dry-run to only show the differences.`,
+	Run: restoreFunc,
+}
+
+func restoreFunc(cmd *cobra.Command, args []string) {
+	var filename string
+	var err error
+	switch restoreFile {
+	case "credentials":
+		filename, err = cloudAWS.CredentialsFilename()
+	case "config":
+		filename, err = cloudAWS.ConfigFilename()
+	case "oci":
+		filename, err = cloudOCI.ConfigFilename()
+	default:
+		err = fmt.Errorf("Unknown file %s, must be 'credentials', 'config' or 'oci'", restoreFile)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	backups, err := backup.List(cloudAWS.BackupDir, filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(backups) == 0 {
+		fmt.Fprintf(os.Stderr, "No backups of %s found\n", filename)
+		os.Exit(1)
+	}
+	if listBackups {
+		table := newTable()
+		table.SetHeader([]string{"Taken", "Backup"})
+		for _, b := range backups {
+			table.Append([]string{b.Time.Format(time.RFC3339), b.Path})
+		}
+		table.Render()
+		return
+	}
+
+	at := time.Now()
+	if restoreAt != "" {
+		if at, err = parseSince(restoreAt, time.Now()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	b, ok := backup.Find(backups, at)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "No backup of %s taken at or before %s\n", filename, at.Format(time.RFC3339))
+		os.Exit(1)
+	}
+
+	current, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	saved, err := ioutil.ReadFile(b.Path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if string(current) == string(saved) {
+		fmt.Printf("%s is the same as the backup taken at %s\n", filename, b.Time.Format(time.RFC3339))
+		return
+	}
+	fmt.Printf("--- %s\n+++ backup taken at %s\n", filename, b.Time.Format(time.RFC3339))
+	printDiff(backup.Diff(current, saved))
+	if dryRun {
+		return
+	}
+
+	// Back up the file being replaced, so the restore can be undone
+	if _, err := backup.Save(cloudAWS.BackupDir, filename, cloudAWS.BackupKeep); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := atomicfile.WriteFile(filename, saved, 0600); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Restored %s from the backup taken at %s\n", filename, b.Time.Format(time.RFC3339))
+}
+
+// printDiff prints the changed lines of a diff, each under the section it
+// belongs to, with access key IDs and secrets masked
+func printDiff(lines []backup.Line) {
+	var section string
+	for _, l := range lines {
+		isSection := strings.HasPrefix(strings.TrimSpace(l.Text), "[")
+		if l.Op == backup.Same {
+			if isSection {
+				section = l.Text
+			}
+			continue
+		}
+		if section != "" {
+			fmt.Printf(" %s\n", section)
+			section = ""
+		}
+		fmt.Printf("%c%s\n", l.Op, maskLine(l.Text))
+	}
+}
+
+// maskLine masks the value of an access key ID, secret or pass phrase in a
+// line of the credentials, config or OCI config file
+func maskLine(line string) string {
+	i := strings.Index(line, "=")
+	if i < 0 {
+		return line
+	}
+	key := strings.ToLower(strings.TrimSpace(line[:i]))
+	value := strings.TrimSpace(line[i+1:])
+	switch {
+	case key == "aws_access_key_id":
+		value = obfuscateString(value, 4)
+	case strings.Contains(key, "secret"), strings.Contains(key, "token"), key == "pass_phrase":
+		value = strings.Repeat("*", len(value))
+	default:
+		return line
+	}
+	return line[:i+1] + " " + value
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringVar(&restoreAt, "at", "", "Restore the newest backup taken at or before this date, time or age (default is the newest backup)")
+	restoreCmd.Flags().StringVar(&restoreFile, "file", "credentials", "File to restore. One of 'credentials', 'config' or 'oci'.")
+	restoreCmd.Flags().BoolVar(&listBackups, "list", false, "List the backups instead of restoring one")
+	restoreCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the differences to the backup")
+}
@@ -3,15 +3,23 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	cloudOCI "github.com/buzzsurfr/cloudkey/cloud/oci"
 	"github.com/spf13/cobra"
 
+	// Register the other cloud providers
+	_ "github.com/buzzsurfr/cloudkey/cloud/alibaba"
+	_ "github.com/buzzsurfr/cloudkey/cloud/azure"
+	_ "github.com/buzzsurfr/cloudkey/cloud/gcp"
+
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
-var cloud string
+var cloudName string
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -43,7 +51,8 @@ func init() {
 	// will be global for your application.
 
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.cloudkey.yaml)")
-	rootCmd.PersistentFlags().StringVar(&cloud, "cloud", "aws", "Cloud Provider")
+	rootCmd.PersistentFlags().StringVar(&cloudName, "cloud", "", "Cloud provider, like aws (default is every provider for list, aws otherwise)")
+	rootCmd.PersistentFlags().StringVar(&cloudAWS.CredentialsFile, "credentials-file", "", "AWS credentials file (default is $AWS_SHARED_CREDENTIALS_FILE or $HOME/.aws/credentials)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
@@ -72,6 +81,17 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		// stderr, since stdout may be evaluated by the shell
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
+	}
+
+	// Back up the credentials and config files before changing them
+	if home, err := homedir.Dir(); err == nil {
+		cloudAWS.BackupDir = filepath.Join(home, ".cloudkey", backupDir)
+		cloudOCI.BackupDir = cloudAWS.BackupDir
 	}
+	// Bad settings are reported by the commands that use them
+	s, _ := appSettings()
+	cloudAWS.BackupKeep = s.BackupsKeep
+	cloudOCI.BackupKeep = s.BackupsKeep
 }
@@ -1,18 +1,15 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/iam"
-	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/buzzsurfr/cloudkey/cloud"
 	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+	"github.com/buzzsurfr/cloudkey/internal/shellenv"
 	"github.com/spf13/cobra"
 )
 
@@ -21,203 +18,312 @@ var rotateCmd = &cobra.Command{
 	Use:   "rotate",
 	Short: "Rotate the cloud access key",
 	Long: `Rotate uses the "active" access key (or the access key found with the --profile
-option) to request a new access key, applies the access key locally, then uses
-the new access key to remove the old access key.
+option) to request a new access key, waits until the new access key is accepted,
+applies the access key locally, then uses the new access key to remove the old
+access key. If the new access key is never accepted, it is deleted and the
+old access key is kept.
 
-Rotate will replace the access key in the same destination as the source, so
-environment variables are replaced or the config file (credentials file) is
-modified.`,
+If the profile is a role profile, rotate follows its source_profile chain to
+the profile that owns the access key and rotates that key. Afterwards, every
+role profile that uses the rotated key is checked by assuming its role with the
+new access key. Roles that need an MFA token are skipped.
+
+An IAM user can only have two access keys. If the user already has a second
+access key, the --on-second-key option decides what happens:
+  fail                   stop without changing anything (default)
+  delete-inactive        delete the other access key if it is inactive
+  delete-oldest-unused   delete the other access key that was used least
+                         recently, unless it is active and used by another
+                         local profile
+
+Use --all to rotate every profile with an access key, or --profiles to rotate
+a list of profiles, where names can be glob patterns (role profiles are
+followed to their source profile). Up to --concurrency profiles are rotated at
+the same time, and a summary is printed at the end. Rotate exits with an error
+if any profile failed.
+
+Use --older-than (or max_key_age in ~/.cloudkey.yaml) to only rotate access
+keys older than the given age, like 90d. Younger access keys are skipped, which
+is reported along with the key's age.
+
+Use --grace (or grace_period in ~/.cloudkey.yaml) to only deactivate the old
+access key instead of deleting it. The old access key is recorded in
+~/.cloudkey/grace.json; "cloudkey prune" deletes it once the grace period is
+over, and "cloudkey reactivate" turns it back on if something still needs it.
+
+Use --watch with a window like 15m to keep checking the deactivated old access
+key for use before it is deleted. If anything uses it in that window, rotate
+reports the service and region it was used for and deletes it anyway, or with
+--on-use reactivate turns it back on instead. AWS can take a while to report
+the last use of an access key, so a longer window catches more.
+
+If your IAM policies require MFA for changing access keys, use --mfa (or
+require_mfa in ~/.cloudkey.yaml). Rotate
+takes the MFA device from mfa_serial in the profile, or the IAM user's first
+MFA device, and asks for a code. The access keys are then created, deactivated
+and deleted with an MFA session from GetSessionToken, while the new access key
+is still verified on its own.
+
+Hooks for a profile in ~/.cloudkey.yaml run commands before and after its
+rotation. Pre-rotate hooks run before anything is changed, and a failing one
+stops the rotation. Post-rotate hooks run once the new access key is saved
+locally, before the old one is deactivated, and a failing one rolls the
+rotation back. Hooks get the new access key in CLOUDKEY_ACCESS_KEY_ID and
+CLOUDKEY_SECRET_ACCESS_KEY and as JSON on stdin, never as arguments.
+
+Settings in ~/.cloudkey.yaml apply to the profile that owns the access key, and
+are described in "cloudkey config --help". With account_id set, rotate refuses
+to rotate an access key of any other account. Profiles with exclude_from_bulk
+are left out of --all and glob patterns, but are rotated when named exactly.
+
+Every step is recorded in a journal in ~/.cloudkey/journal before it is
+attempted. If a step fails, rotate undoes what it can. If rotate is
+interrupted, run "cloudkey recover" to finish or roll back the rotation.
+
+Rotate will replace the access key in the same destination as the source. The
+credentials file (or config file) is modified in place. Environment variables
+can't be changed from here, so for them rotate prints statements that set the
+new access key on stdout, and everything else on stderr. Run it with
+
+  eval "$(cloudkey rotate)"                  # bash, zsh
+  cloudkey rotate --shell fish | source      # fish
+  cloudkey rotate --shell powershell | iex   # PowerShell
+
+to update your shell. The --env-file option also writes the statements to a
+file. The old access key is only deactivated after the new one was printed or
+written.
+
+Rotate works with AWS unless --cloud names another provider (see "cloudkey
+list"). For other providers, rotate creates a new key, waits until it is
+accepted, saves it where the old one was found, then deactivates (where the
+cloud can) and deletes the old key. --profile, --all, --profiles, --older-than,
+the --verify options, --shell, --env-file, hooks, max_key_age and
+exclude_from_bulk work the same way, set under clouds in ~/.cloudkey.yaml; the
+journal, grace periods, --watch, MFA, role checks and the other AWS options
+don't apply. Profiles sharing a key, like a gcloud configuration using the key
+file in GOOGLE_APPLICATION_CREDENTIALS, are rotated once. New Azure client secrets expire after --secret-expiry. OCI
+writes the new private key next to the old key file and backs up the config
+file before pointing it at the new key; the old key file is kept until the
+last backup of the config file that points at it is pruned.`,
 	Run: rotateFunc,
 }
 
 func rotateFunc(cmd *cobra.Command, args []string) {
-	// fmt.Println("rotate called")
+	name := cloudName
+	if name == "" {
+		name = cloudAWS.Name
+	}
+	pr, err := cloud.Get(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	// AWS finds profiles by following source_profile and checks roles after,
+	// and its own rotation options are given to cloud.Rotate
+	if pr.Name() != cloudAWS.Name {
+		providerRotateFunc(cmd, pr)
+		return
+	}
+	if cmd.Flags().Changed("secret-expiry") {
+		fmt.Fprintln(os.Stderr, "--secret-expiry can only be used with Azure")
+		os.Exit(1)
+	}
+	if rotateAll || len(profileNames) > 0 {
+		bulkRotateFunc()
+		return
+	}
+
 	var p cloudAWS.Profile
-	var err error
 	if profileName != "" {
 		p, err = cloudAWS.GetByName(profileName)
 	} else {
 		p, err = cloudAWS.Current()
 	}
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	// fmt.Printf("Profile: %s\n", p.String())
+	deliverToShell(p.Source)
 
-	err = p.NewSession()
-	if err != nil {
-		panic(err)
+	// Follow source_profile from a role profile to the profile that owns the key
+	var profiles cloudAWS.Profiles
+	if p.Source == "ConfigFile" {
+		profiles, err = cloudAWS.FromConfigFile(false)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		chain, err := profiles.SourceChain(p.Name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if len(chain) > 1 {
+			names := make([]string, len(chain))
+			for i, c := range chain {
+				names[i] = c.Name
+			}
+			fmt.Fprintf(statusOutput, "Following source_profile: %s\n", strings.Join(names, " -> "))
+			p = chain[len(chain)-1]
+		}
 	}
 
-	// List Access Keys
-	userName, err := SessionUserName(p.Session)
+	opts, err := rotateOptions()
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	// Get Access Keys
-	oldIamSvc := iam.New(p.Session)
-	result, err := oldIamSvc.ListAccessKeys(&iam.ListAccessKeysInput{
-		UserName: aws.String(userName),
-	})
+	rotation, checks, err := rotateProfile(p, profiles, opts)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case iam.ErrCodeNoSuchEntityException:
-				fmt.Println(iam.ErrCodeNoSuchEntityException, aerr.Error())
-			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+		fmt.Fprintln(os.Stderr, err)
+		if rotation.RolledBack {
+			fmt.Fprintln(os.Stderr, "The new access key was removed and the old access key was kept.")
 		}
-		return
+		os.Exit(1)
 	}
-	// fmt.Printf("ListAccessKeys: %+v\n", result)
-	if len(result.AccessKeyMetadata) != 1 {
-		fmt.Println("Too many access keys")
+	if rotation.Skipped != "" {
+		fmt.Fprintf(statusOutput, "Skipped %s: %s\n", displayName(rotation.Profile), rotation.Skipped)
 		return
 	}
+	if rotation.RemovedKeyID != "" {
+		fmt.Fprintf(statusOutput, "Deleted other access key %s\n", obfuscateString(rotation.RemovedKeyID, 4))
+	}
+	fmt.Fprintf(statusOutput, "Rotated %s to %s for %s\n", obfuscateString(rotation.OldKeyID, 4), obfuscateString(rotation.NewKeyID, 4), rotation.UserName)
+	if use := keyUseMessage(rotation); use != "" {
+		fmt.Fprintln(statusOutput, use)
+	}
+	if !rotation.DeleteAfter.IsZero() {
+		fmt.Fprintf(statusOutput, "Deactivated %s until %s. Run cloudkey prune to delete it after that, or cloudkey reactivate if it is still needed.\n", obfuscateString(rotation.OldKeyID, 4), rotation.DeleteAfter.Format(time.RFC3339))
+	}
 
-	// Create new access key
-	newAccessKey, err := oldIamSvc.CreateAccessKey(&iam.CreateAccessKeyInput{
-		UserName: aws.String(userName),
-	})
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case iam.ErrCodeNoSuchEntityException:
-				fmt.Println(iam.ErrCodeNoSuchEntityException, aerr.Error())
-			case iam.ErrCodeLimitExceededException:
-				fmt.Println(iam.ErrCodeLimitExceededException, aerr.Error())
-			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+	// Report whether the role profiles using this key still work
+	failed := false
+	for _, check := range checks {
+		switch {
+		case check.Err != nil:
+			fmt.Fprintf(statusOutput, "Profile %s cannot assume %s: %v\n", check.Profile, check.RoleARN, check.Err)
+			failed = true
+		case check.Skipped != "":
+			fmt.Fprintf(statusOutput, "Skipped profile %s: %s\n", check.Profile, check.Skipped)
+		default:
+			fmt.Fprintf(statusOutput, "Profile %s can assume %s\n", check.Profile, check.RoleARN)
 		}
-		return
 	}
-	// fmt.Printf("CreateAccessKey: %+v\n", newAccessKey)
-
-	// Create new credential from access key
-	cred, err := cloudAWS.FromAccessKey(*newAccessKey.AccessKey)
-	if err != nil {
-		panic(err)
+	if failed {
+		os.Exit(1)
 	}
+}
 
-	// Save old access key
-	// oldSess := p.Session
-	oldCred := p.Cred
+// keyUseMessage describes a use of the old access key while it was watched
+func keyUseMessage(r cloudAWS.Rotation) string {
+	if r.OldKeyUse == nil {
+		return ""
+	}
+	msg := fmt.Sprintf("Old access key %s was used", obfuscateString(r.OldKeyID, 4))
+	if r.OldKeyUse.Service != "" {
+		msg += " for " + r.OldKeyUse.Service
+	}
+	if r.OldKeyUse.Region != "" {
+		msg += " in " + r.OldKeyUse.Region
+	}
+	msg += " at " + r.OldKeyUse.Date.Format(time.RFC3339)
+	if r.Reactivated {
+		return msg + ", so it was reactivated. Delete it once nothing needs it anymore."
+	}
+	return msg + " after it was deactivated"
+}
 
-	// Save cred to profile
-	p.UpdateCredential(cred)
+// rotateProfile rotates the access key of a profile with cloud.Rotate, then
+// checks the role profiles among profiles that use it
+func rotateProfile(p cloudAWS.Profile, profiles cloudAWS.Profiles, opts cloudAWS.RotateOptions) (rotation cloudAWS.Rotation, checks []cloudAWS.RoleCheck, err error) {
+	rotation = cloudAWS.Rotation{Profile: p.Name, OldKeyID: p.Cred.AccessKeyID, Started: time.Now()}
+	cp := cloudAWS.CloudProfile(p)
+	ap := cp.Data.(*cloudAWS.Profile)
+	defer func() {
+		recordRotation(p.Source, cp.Account, rotation, err)
+	}()
+	pr, err := cloud.Get(cloudAWS.Name)
+	if err != nil {
+		return rotation, nil, err
+	}
+	if err := ap.NewSession(); err != nil {
+		return rotation, nil, err
+	}
+	ap.NewSTS()
+	ap.NewIAM()
 
-	// Create new AWS session
-	err = p.NewSession()
+	journal, err := journalPath(p.Name)
 	if err != nil {
-		panic(err)
+		return rotation, nil, err
 	}
+	opts.Journal = journal
+	applyPolicy(&opts, p.Name)
 
-	// Sleep for 15 seconds to allow access key to activate
-	time.Sleep(15 * time.Second)
+	r, err := cloud.Rotate(pr, &cp, cloud.RotateOptions{Options: opts})
+	if ar, ok := r.Data.(cloudAWS.Rotation); ok {
+		rotation = ar
+	}
+	if err != nil || rotation.Skipped != "" {
+		return rotation, nil, err
+	}
+	// The AWS profile behind cp has the new access key and its clients now
+	return rotation, profiles.VerifyRoles(ap, profiles.Dependents(p.Name)), nil
+}
 
-	// Deactivate old access key using new access key
-	newIamSvc := iam.New(p.Session)
-	_, err = newIamSvc.UpdateAccessKey(&iam.UpdateAccessKeyInput{
-		AccessKeyId: aws.String(oldCred.AccessKeyID),
-		Status:      aws.String("Inactive"),
-		UserName:    aws.String(userName),
-	})
+// rotateOptions builds the rotation options from the command line flags. The
+// journal and the profile's policy are set for each profile.
+func rotateOptions() (cloudAWS.RotateOptions, error) {
+	policy, err := cloudAWS.ParseSecondKeyPolicy(onSecondKey)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case iam.ErrCodeNoSuchEntityException:
-				fmt.Println(iam.ErrCodeNoSuchEntityException, aerr.Error())
-			case iam.ErrCodeLimitExceededException:
-				fmt.Println(iam.ErrCodeLimitExceededException, aerr.Error())
-			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+		return cloudAWS.RotateOptions{}, err
+	}
+	if envShell != "" {
+		if _, err := shellenv.Parse(envShell); err != nil {
+			return cloudAWS.RotateOptions{}, err
 		}
-		return
 	}
-
-	// Delete old access key using new access key
-	_, err = newIamSvc.DeleteAccessKey(&iam.DeleteAccessKeyInput{
-		AccessKeyId: aws.String(oldCred.AccessKeyID),
-		UserName:    aws.String(userName),
-	})
+	graceFile, err := cloudkeyPath(graceFileName)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case iam.ErrCodeNoSuchEntityException:
-				fmt.Println(iam.ErrCodeNoSuchEntityException, aerr.Error())
-			case iam.ErrCodeLimitExceededException:
-				fmt.Println(iam.ErrCodeLimitExceededException, aerr.Error())
-			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+		return cloudAWS.RotateOptions{}, err
+	}
+	var grace time.Duration
+	if gracePeriod != "" {
+		if grace, err = settings.ParseDuration(gracePeriod); err != nil {
+			return cloudAWS.RotateOptions{}, fmt.Errorf("Bad --grace: %v", err)
 		}
-		return
 	}
-}
-
-// SessionUserName gets the user name of the current session
-func SessionUserName(sess *session.Session) (string, error) {
-	var userName string
-
-	// AWS sts:GetCallerIdentity API
-	svc := sts.New(sess)
-	result, err := svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	onUsePolicy, err := cloudAWS.ParseWatchPolicy(onUse)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+		return cloudAWS.RotateOptions{}, err
+	}
+	var minAge time.Duration
+	if olderThan != "" {
+		if minAge, err = settings.ParseDuration(olderThan); err != nil {
+			return cloudAWS.RotateOptions{}, fmt.Errorf("Bad --older-than: %v", err)
 		}
-		return "", err
 	}
-
-	// Parse ARN
-	resultArn, err := arn.Parse(*result.Arn)
-	if err != nil {
-		return "", err
+	if _, err := appSettings(); err != nil {
+		return cloudAWS.RotateOptions{}, err
 	}
-
-	// Verify is a user
-	s := strings.Split(resultArn.Resource, "/")
-	if s[0] != "user" {
-		return "", errors.New("Not a user")
+	opts := cloudAWS.RotateOptions{
+		VerifyTimeout:     verifyTimeout,
+		VerifyInterval:    verifyInterval,
+		VerifyMaxInterval: verifyMaxInterval,
+		SecondKey:         policy,
+		LocalKeys:         cloudAWS.LocalAccessKeys(),
+		MinAge:            minAge,
+		Grace:             grace,
+		GraceFile:         graceFile,
+		Watch:             watch,
+		WatchInterval:     watchInterval,
+		OnUse:             onUsePolicy,
 	}
-	userName = s[1]
-
-	return userName, nil
+	// Without Deliver, profiles in environment variables fail before any
+	// access key is created
+	if canDeliver() {
+		opts.Deliver = deliverCredential
+	}
+	return opts, nil
 }
 
 func init() {
@@ -233,4 +339,20 @@ func init() {
 	// is called directly, e.g.:
 	// rotateCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rotateCmd.Flags().StringVarP(&profileName, "profile", "p", "", "Profile to rotate")
+	rotateCmd.Flags().BoolVar(&rotateAll, "all", false, "Rotate every profile with an access key")
+	rotateCmd.Flags().StringSliceVar(&profileNames, "profiles", nil, "Profiles to rotate, separated by commas. Names can be glob patterns like 'prod-*'.")
+	rotateCmd.Flags().StringVar(&olderThan, "older-than", "", "Only rotate access keys older than this, like '90d' (default is max_key_age in the config file, or always)")
+	rotateCmd.Flags().StringVar(&gracePeriod, "grace", "", "Only deactivate the old access key and keep it this long, like '7d', before cloudkey prune deletes it (default is grace_period in the config file, or delete right away)")
+	rotateCmd.Flags().DurationVar(&watch, "watch", 0, "Check the deactivated old access key for use this long before deleting it")
+	rotateCmd.Flags().DurationVar(&watchInterval, "watch-interval", cloudAWS.DefaultWatchInterval, "Delay between checks of the old access key with --watch")
+	rotateCmd.Flags().StringVar(&onUse, "on-use", string(cloudAWS.WatchReport), "What to do when the old access key is used with --watch. One of 'report' or 'reactivate'.")
+	rotateCmd.Flags().BoolVar(&useMFA, "mfa", false, "Ask for an MFA code and change the access keys with an MFA session")
+	rotateCmd.Flags().IntVar(&concurrency, "concurrency", 4, "How many profiles to rotate at the same time with --all or --profiles")
+	rotateCmd.Flags().StringVar(&onSecondKey, "on-second-key", string(cloudAWS.SecondKeyFail), "What to do when the user already has two access keys. One of 'fail', 'delete-inactive' or 'delete-oldest-unused'.")
+	rotateCmd.Flags().StringVar(&envShell, "shell", "", "Shell syntax for environment variable credentials. One of 'bash', 'zsh', 'fish' or 'powershell' (default detected from $SHELL)")
+	rotateCmd.Flags().StringVar(&envFile, "env-file", "", "Also write environment variable credentials to this file")
+	rotateCmd.Flags().StringVar(&secretExpiry, "secret-expiry", "", "How long a new Azure client secret is valid, like '90d' (default 180d)")
+	rotateCmd.Flags().DurationVar(&verifyTimeout, "verify-timeout", cloud.DefaultVerifyTimeout, "How long to wait for the new access key to be accepted")
+	rotateCmd.Flags().DurationVar(&verifyInterval, "verify-interval", cloud.DefaultVerifyInterval, "Initial delay between checks of the new access key")
+	rotateCmd.Flags().DurationVar(&verifyMaxInterval, "verify-max-interval", cloud.DefaultVerifyMaxInterval, "Maximum delay between checks of the new access key")
 }
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+	"github.com/buzzsurfr/cloudkey/internal/schedule"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+// crontabMarker ends the crontab line written by schedule install, so that
+// installing again replaces it
+const crontabMarker = "# cloudkey schedule"
+
+// scheduleCmd represents the schedule command
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Run cloudkey daemon from the system's scheduler",
+}
+
+// scheduleInstallCmd represents the schedule install command
+var scheduleInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install a systemd timer or crontab entry that rotates access keys",
+	Long: `Install sets up the system's scheduler to run "cloudkey daemon --once" on the
+--schedule, so access keys are rotated without cloudkey running all the time.
+Like the daemon, it only rotates profiles with a max_key_age in ~/.cloudkey.yaml.
+
+With --type systemd (the default where systemctl is found), it writes the user
+units cloudkey.service and cloudkey.timer to ~/.config/systemd/user and enables
+the timer. A timer that was missed while the machine was off runs when it comes
+back. With --type cron, it adds a line to your crontab, replacing the one added
+before.
+
+Use --dry-run to only print what would be installed.`,
+	Run: scheduleInstallFunc,
+}
+
+func scheduleInstallFunc(cmd *cobra.Command, args []string) {
+	sched, err := parseSchedule(scheduleSpec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	kind := scheduleType
+	if kind == "" {
+		kind = "cron"
+		if _, err := exec.LookPath("systemctl"); err == nil && runtime.GOOS == "linux" {
+			kind = "systemd"
+		}
+	}
+	command, err := daemonCommand()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	switch kind {
+	case "systemd":
+		err = installTimer(sched, command)
+	case "cron":
+		err = installCrontab(sched, command)
+	default:
+		err = fmt.Errorf("Unknown scheduler %s, must be 'systemd' or 'cron'", kind)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// daemonCommand is the command line that checks the access keys once
+func daemonCommand() ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, err
+	}
+	command := []string{exe, "daemon", "--once"}
+	if cfgFile != "" {
+		config, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return nil, err
+		}
+		command = append(command, "--config", config)
+	}
+	return command, nil
+}
+
+// installTimer writes and enables a systemd user timer
+func installTimer(sched schedule.Schedule, command []string) error {
+	var when string
+	switch s := sched.(type) {
+	case *schedule.Cron:
+		event, err := s.OnCalendar()
+		if err != nil {
+			return err
+		}
+		when = "OnCalendar=" + event + "\nPersistent=true\n"
+	case schedule.Interval:
+		when = fmt.Sprintf("OnBootSec=15min\nOnUnitActiveSec=%d\n", int64(time.Duration(s).Seconds()))
+	}
+	if jitter > 0 {
+		when += fmt.Sprintf("RandomizedDelaySec=%d\n", int64(jitter.Seconds()))
+	}
+
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = arg
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			quoted[i] = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+		}
+	}
+	service := "[Unit]\nDescription=Rotate cloud access keys with cloudkey\n\n" +
+		"[Service]\nType=oneshot\nExecStart=" + strings.Join(quoted, " ") + "\n"
+	timer := "[Unit]\nDescription=Rotate cloud access keys with cloudkey on a schedule\n\n" +
+		"[Timer]\n" + when + "\n[Install]\nWantedBy=timers.target\n"
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	dir = filepath.Join(dir, "systemd", "user")
+	units := []struct{ name, content string }{
+		{"cloudkey.service", service},
+		{"cloudkey.timer", timer},
+	}
+	if dryRun {
+		for _, u := range units {
+			fmt.Printf("# %s\n%s\n", filepath.Join(dir, u.name), u.content)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, u := range units {
+		path := filepath.Join(dir, u.name)
+		if err := atomicfile.WriteFile(path, []byte(u.content), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	for _, args := range [][]string{{"--user", "daemon-reload"}, {"--user", "enable", "--now", "cloudkey.timer"}} {
+		out, err := exec.Command("systemctl", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("systemctl %s failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	fmt.Println("Enabled cloudkey.timer. Check it with: systemctl --user list-timers cloudkey.timer")
+	return nil
+}
+
+// installCrontab adds the daemon to the user's crontab, replacing the line
+// added before
+func installCrontab(sched schedule.Schedule, command []string) error {
+	var spec string
+	switch s := sched.(type) {
+	case *schedule.Cron:
+		spec = s.String()
+	case schedule.Interval:
+		c, err := s.Cron()
+		if err != nil {
+			return err
+		}
+		spec = c.String()
+	}
+	// cron can't add jitter itself, so the daemon waits
+	if jitter > 0 {
+		command = append(command, "--jitter", jitter.String())
+	}
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = arg
+		if strings.ContainsAny(arg, " \t\"'\\$%") {
+			quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		// cron turns % into a newline
+		quoted[i] = strings.Replace(quoted[i], "%", `\%`, -1)
+	}
+	// The daemon writes its own log
+	line := fmt.Sprintf("%s %s >/dev/null 2>&1 %s", spec, strings.Join(quoted, " "), crontabMarker)
+	if dryRun {
+		fmt.Println(line)
+		return nil
+	}
+
+	var stderr bytes.Buffer
+	list := exec.Command("crontab", "-l")
+	list.Stderr = &stderr
+	current, err := list.Output()
+	if err != nil && !strings.Contains(strings.ToLower(stderr.String()), "no crontab") {
+		return fmt.Errorf("crontab -l failed: %v\n%s", err, stderr.String())
+	}
+	var lines []string
+	for _, l := range strings.Split(strings.TrimRight(string(current), "\n"), "\n") {
+		if l != "" && !strings.HasSuffix(l, crontabMarker) {
+			lines = append(lines, l)
+		}
+	}
+	lines = append(lines, line)
+
+	install := exec.Command("crontab", "-")
+	install.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	if out, err := install.CombinedOutput(); err != nil {
+		return fmt.Errorf("crontab failed: %v\n%s", err, out)
+	}
+	fmt.Printf("Added to your crontab: %s\n", line)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(scheduleCmd)
+	scheduleCmd.AddCommand(scheduleInstallCmd)
+
+	scheduleInstallCmd.Flags().StringVar(&scheduleSpec, "schedule", "@daily", "When to check the access keys. A cron expression like '30 3 * * *', or an interval like '6h' or '1d'.")
+	scheduleInstallCmd.Flags().DurationVar(&jitter, "jitter", 0, "Wait a random time of up to this long before each check")
+	scheduleInstallCmd.Flags().StringVar(&scheduleType, "type", "", "Scheduler to install into. One of 'systemd' or 'cron' (default is systemd where systemctl is found)")
+	scheduleInstallCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print what would be installed")
+}
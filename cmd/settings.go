@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/buzzsurfr/cloudkey/cloud"
+	cloudAWS "github.com/buzzsurfr/cloudkey/cloud/aws"
+	"github.com/buzzsurfr/cloudkey/internal/settings"
+	"github.com/spf13/viper"
+)
+
+var (
+	settingsOnce   sync.Once
+	loadedSettings *settings.Settings
+	settingsErr    error
+)
+
+// appSettings gets cloudkey's settings from the config file. They are only
+// read once. The settings are usable even with an error, which lists the
+// unknown keys and bad values that were left out.
+func appSettings() (*settings.Settings, error) {
+	settingsOnce.Do(func() {
+		loadedSettings, settingsErr = loadSettings()
+	})
+	return loadedSettings, settingsErr
+}
+
+// loadSettings reads the config file that viper found. It is decoded by the
+// settings package, since viper lowercases profile names and splits them
+// at dots.
+func loadSettings() (*settings.Settings, error) {
+	var data []byte
+	if filename := viper.ConfigFileUsed(); filename != "" {
+		var err error
+		if data, err = ioutil.ReadFile(filename); err != nil {
+			s, _ := settings.Parse(nil)
+			return s, err
+		}
+	}
+	s, err := settings.Load(data)
+	return s, unknownClouds(s, err)
+}
+
+// unknownClouds adds the clouds in the settings that cloudkey has no provider
+// for to the problems in err, so that a misspelled cloud is reported like a
+// misspelled key
+func unknownClouds(s *settings.Settings, err error) error {
+	var problems []settings.Problem
+	for _, name := range s.Clouds() {
+		if _, gerr := cloud.Get(name); gerr != nil {
+			problems = append(problems, settings.Problem{Key: "clouds." + name, Message: "unknown cloud"})
+		}
+	}
+	if len(problems) == 0 {
+		return err
+	}
+	switch serr := err.(type) {
+	case nil:
+		return &settings.Error{Problems: problems}
+	case *settings.Error:
+		serr.Problems = append(serr.Problems, problems...)
+	}
+	return err
+}
+
+// applyPolicy sets the rotation options from the policy of a profile in the
+// config file. --older-than and --grace take precedence over the policy, and
+// --mfa asks for an MFA code even if the policy doesn't require one.
+func applyPolicy(opts *cloudAWS.RotateOptions, profile string) {
+	s, _ := appSettings()
+	policy := s.Policy(cloudAWS.Name, profile)
+	if olderThan == "" {
+		opts.MinAge = policy.MaxKeyAge
+	}
+	if gracePeriod == "" {
+		opts.Grace = policy.GracePeriod
+	}
+	opts.Account = policy.AccountID
+	setHooks(opts, policy.Hooks)
+	opts.MFATokenCode = nil
+	if useMFA || policy.RequireMFA {
+		opts.MFATokenCode = promptMFACode(profile)
+	}
+}
@@ -1,5 +1,7 @@
 package cmd
 
+import "time"
+
 var (
 	profileName string
 	shortened   = false
@@ -7,4 +9,35 @@ var (
 	mainCommit  = "none"
 	mainDate    = "unknown"
 	output      string
+
+	verifyTimeout     time.Duration
+	verifyInterval    time.Duration
+	verifyMaxInterval time.Duration
+	rollback          bool
+	onSecondKey       string
+	envShell          string
+	envFile           string
+	rotateAll         bool
+	profileNames      []string
+	concurrency       int
+	dryRun            bool
+	force             bool
+	watch             time.Duration
+	watchInterval     time.Duration
+	onUse             string
+	outcome           string
+	since             string
+	historyOutput     string
+	restoreAt         string
+	restoreFile       string
+	listBackups       bool
+	useMFA            bool
+	olderThan         string
+	gracePeriod       string
+	scheduleSpec      string
+	jitter            time.Duration
+	once              bool
+	auditLog          string
+	scheduleType      string
+	secretExpiry      string
 )
@@ -5,12 +5,13 @@ go 1.12
 require (
 	github.com/aws/aws-sdk-go v1.29.14
 	github.com/mattn/go-colorable v0.1.6
+	github.com/mattn/go-isatty v0.0.12
 	github.com/mitchellh/go-homedir v1.1.0
-	github.com/mitchellh/mapstructure v1.1.2
 	github.com/olekukonko/tablewriter v0.0.4
 	github.com/spf13/cobra v0.0.6
 	github.com/spf13/viper v1.6.2
 	go.hein.dev/go-version v0.1.0
 	golang.org/x/sys v0.0.0-20200301204400-5d559ad92b82 // indirect
-	gopkg.in/ini.v1 v1.52.0
+	gopkg.in/ini.v1 v1.52.0 // indirect
+	gopkg.in/yaml.v2 v2.2.4
 )
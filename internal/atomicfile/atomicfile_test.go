@@ -0,0 +1,80 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "credentials")
+
+	t.Run("create new file", func(t *testing.T) {
+		err := WriteFile(filename, []byte("first"), 0600)
+
+		assertNoError(t, err)
+		assertContents(t, filename, "first")
+		assertPerm(t, filename, 0600)
+	})
+	t.Run("replace existing file and tighten permissions", func(t *testing.T) {
+		if err := os.Chmod(filename, 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := WriteFile(filename, []byte("second"), 0600)
+
+		assertNoError(t, err)
+		assertContents(t, filename, "second")
+		assertPerm(t, filename, 0600)
+	})
+	t.Run("no temporary files left behind", func(t *testing.T) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Errorf("got %d files, want 1", len(files))
+		}
+	})
+	t.Run("fail on missing directory", func(t *testing.T) {
+		err := WriteFile(filepath.Join(dir, "missing", "credentials"), []byte("x"), 0600)
+
+		if err == nil {
+			t.Error("wanted an error but didn't get one")
+		}
+	})
+}
+
+func assertContents(t *testing.T, filename, want string) {
+	t.Helper()
+	got, err := ioutil.ReadFile(filename)
+	assertNoError(t, err)
+	if string(got) != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
+
+func assertPerm(t *testing.T, filename string, want os.FileMode) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(filename)
+	assertNoError(t, err)
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("got mode %v want %v", got, want)
+	}
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("got error %q but didn't want one", err)
+	}
+}
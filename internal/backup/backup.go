@@ -0,0 +1,181 @@
+// Package backup keeps timestamped copies of a file before it is changed, so
+// that an earlier version can be restored.
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+)
+
+// timeFormat stamps each backup. It sorts by time and has no colons, which
+// are not allowed in Windows file names.
+const timeFormat = "20060102T150405.000000000Z"
+
+// Backup is a saved copy of a file
+type Backup struct {
+	Path string
+	Time time.Time
+}
+
+// prefix starts the names of the backups of filename. It has the base name of
+// the file, to be readable, and a hash of its absolute path, so files with the
+// same base name in different directories have their own backups.
+func prefix(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Base(abs) + "-" + hex.EncodeToString(sum[:4]) + ".", nil
+}
+
+// Save copies filename into dir, named after the file and the current time,
+// then removes all but the newest keep backups of the file. Keep 0 keeps
+// every backup. Nothing is saved if filename does not exist, and the returned
+// path is empty.
+func Save(dir, filename string, keep int) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	name, err := prefix(filename)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+time.Now().UTC().Format(timeFormat))
+	if err := atomicfile.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+
+	if keep <= 0 {
+		return path, nil
+	}
+	backups, err := List(dir, filename)
+	if err != nil {
+		return path, err
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			return path, err
+		}
+	}
+	return path, nil
+}
+
+// List gets the backups of filename in dir, newest first
+func List(dir, filename string) ([]Backup, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	name, err := prefix(filename)
+	if err != nil {
+		return nil, err
+	}
+	var backups []Backup
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), name) {
+			continue
+		}
+		t, err := time.Parse(timeFormat, strings.TrimPrefix(e.Name(), name))
+		if err != nil {
+			// Not a backup of this file, like credentials.old
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, e.Name()), Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// Find gets the newest backup taken at or before at. Backups must be newest
+// first, as returned by List.
+func Find(backups []Backup, at time.Time) (Backup, bool) {
+	for _, b := range backups {
+		if !b.Time.After(at) {
+			return b, true
+		}
+	}
+	return Backup{}, false
+}
+
+// Diff operations
+const (
+	Same   = ' '
+	Remove = '-'
+	Add    = '+'
+)
+
+// Line is a line of a diff
+type Line struct {
+	Op   byte
+	Text string
+}
+
+// Diff compares two files line by line. Lines of a that are not in b are
+// removed, and lines of b that are not in a are added.
+func Diff(a, b []byte) []Line {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []Line
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, Line{Same, x[i]})
+			i++
+			j++
+		case j == len(y) || (i < len(x) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, Line{Remove, x[i]})
+			i++
+		default:
+			lines = append(lines, Line{Add, y[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// splitLines splits a file into lines without their line endings
+func splitLines(data []byte) []string {
+	s := strings.Replace(string(data), "\r\n", "\n", -1)
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
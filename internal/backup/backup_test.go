@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "credentials")
+	backups := filepath.Join(dir, "backups")
+
+	t.Run("missing file", func(t *testing.T) {
+		path, err := Save(backups, filename, 3)
+
+		if err != nil || path != "" {
+			t.Errorf("got %q, %v but want nothing", path, err)
+		}
+	})
+	t.Run("keep newest backups", func(t *testing.T) {
+		for _, content := range []string{"one", "two", "three", "four"} {
+			if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Save(backups, filename, 3); err != nil {
+				t.Fatalf("got error %q but didn't want one", err)
+			}
+		}
+		// Not a backup, so it is neither listed nor removed
+		ioutil.WriteFile(filepath.Join(backups, "credentials.old"), nil, 0600)
+
+		got, err := List(backups, filename)
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 3 {
+			t.Fatalf("got %d backups but want 3", len(got))
+		}
+		newest, _ := ioutil.ReadFile(got[0].Path)
+		if string(newest) != "four" {
+			t.Errorf("got newest backup %q but want %q", newest, "four")
+		}
+		info, err := os.Stat(got[0].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("got permissions %v but want 0600", perm)
+		}
+	})
+	t.Run("files with the same name", func(t *testing.T) {
+		other := filepath.Join(dir, "other", "credentials")
+		if err := os.MkdirAll(filepath.Dir(other), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(other, []byte("other"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Save(backups, other, 1); err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+
+		got, err := List(backups, other)
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("got %d backups but want 1", len(got))
+		}
+		if data, _ := ioutil.ReadFile(got[0].Path); string(data) != "other" {
+			t.Errorf("got backup %q but want %q", data, "other")
+		}
+		// Keeping one backup of the other file leaves these alone
+		if got, _ := List(backups, filename); len(got) != 3 {
+			t.Errorf("got %d backups of %s but want 3", len(got), filename)
+		}
+	})
+}
+
+func TestFind(t *testing.T) {
+	day := time.Date(2020, 3, 14, 0, 0, 0, 0, time.UTC)
+	backups := []Backup{
+		{Path: "c", Time: day.AddDate(0, 0, 2)},
+		{Path: "b", Time: day.AddDate(0, 0, 1)},
+		{Path: "a", Time: day},
+	}
+
+	tests := []struct {
+		name  string
+		at    time.Time
+		want  string
+		found bool
+	}{
+		{"exact time", day.AddDate(0, 0, 1), "b", true},
+		{"between backups", day.Add(36 * time.Hour), "b", true},
+		{"after newest", day.AddDate(1, 0, 0), "c", true},
+		{"before oldest", day.Add(-time.Hour), "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := Find(backups, tt.at)
+
+			if found != tt.found || got.Path != tt.want {
+				t.Errorf("got %q, %v but want %q, %v", got.Path, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := []byte("[default]\naws_access_key_id = OLD\nregion = us-east-1\n")
+	b := []byte("[default]\r\naws_access_key_id = NEW\r\nregion = us-east-1\r\noutput = json\r\n")
+
+	got := Diff(a, b)
+
+	want := []Line{
+		{Same, "[default]"},
+		{Remove, "aws_access_key_id = OLD"},
+		{Add, "aws_access_key_id = NEW"},
+		{Same, "region = us-east-1"},
+		{Add, "output = json"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q but want %q", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+package fakecloud
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Alibaba is RAM and STS for one RAM user, at /ram and /sts. It checks the
+// signature of every request. Its keys are the AccessKeys.
+type Alibaba struct {
+	*httptest.Server
+	keyring
+	Account string
+	User    string
+}
+
+// NewAlibaba starts a fake Alibaba Cloud. Close it when done.
+func NewAlibaba() *Alibaba {
+	f := &Alibaba{keyring: newKeyring(), Account: "1234567890123456", User: "deploy"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// AddKey adds an AccessKey of the RAM user
+func (f *Alibaba) AddKey(created time.Time) Key {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addKey(created)
+}
+
+func (f *Alibaba) addKey(created time.Time) Key {
+	n := f.created + 1
+	return f.add(Key{
+		ID:      fmt.Sprintf("LTAI4Key%02dEXAMPLE", n),
+		Secret:  fmt.Sprintf("secret%02dEXAMPLE", n),
+		Active:  true,
+		Created: created,
+	})
+}
+
+// alibabaKey is an AccessKey as RAM shows it
+type alibabaKey struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	AccessKeySecret string `json:"AccessKeySecret,omitempty"`
+	Status          string `json:"Status"`
+	CreateDate      string `json:"CreateDate"`
+}
+
+func (f *Alibaba) keyOf(k *Key) alibabaKey {
+	status := "Active"
+	if !k.Active {
+		status = "Inactive"
+	}
+	return alibabaKey{AccessKeyID: k.ID, Status: status, CreateDate: k.Created.UTC().Format(time.RFC3339)}
+}
+
+// alibabaEncode encodes like RFC 3986, as the RPC signature needs
+func alibabaEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	return strings.Replace(s, "%7E", "~", -1)
+}
+
+// signedBy gets the AccessKey that signed the request
+func (f *Alibaba) signedBy(r *http.Request) (*Key, bool) {
+	q := r.URL.Query()
+	k, ok := f.keys[q.Get("AccessKeyId")]
+	if !ok || !k.Active || q.Get("SignatureMethod") != "HMAC-SHA1" {
+		return nil, false
+	}
+	var pairs []string
+	for name := range q {
+		if name != "Signature" {
+			pairs = append(pairs, alibabaEncode(name)+"="+alibabaEncode(q.Get(name)))
+		}
+	}
+	sort.Strings(pairs)
+	mac := hmac.New(sha1.New, []byte(k.Secret+"&"))
+	mac.Write([]byte("GET&%2F&" + alibabaEncode(strings.Join(pairs, "&"))))
+	return k, base64.StdEncoding.EncodeToString(mac.Sum(nil)) == q.Get("Signature")
+}
+
+func (f *Alibaba) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k, ok := f.signedBy(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"RequestId":"1","Code":"InvalidAccessKeyId.NotFound","Message":"Specified access key is not found."}`)
+		return
+	}
+	q := r.URL.Query()
+	action := strings.Trim(r.URL.Path, "/") + " " + q.Get("Action")
+	f.calls = append(f.calls, action+" as "+k.ID)
+	if strings.HasPrefix(action, "ram ") && q.Get("UserName") != f.User {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"RequestId":"1","Code":"EntityNotExist.User","Message":"The user does not exist."}`)
+		return
+	}
+	switch action {
+	case "sts GetCallerIdentity":
+		fmt.Fprintf(w, `{"AccountId":%q,"UserId":"2000","Arn":"acs:ram::%s:user/%s","IdentityType":"RAMUser"}`, f.Account, f.Account, f.User)
+	case "ram ListAccessKeys":
+		keys := []alibabaKey{}
+		for _, k := range f.keys {
+			keys = append(keys, f.keyOf(k))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"RequestId": "1", "AccessKeys": map[string]interface{}{"AccessKey": keys}})
+	case "ram CreateAccessKey":
+		// RAM allows two AccessKeys per user
+		if len(f.keys) >= 2 {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"RequestId":"1","Code":"LimitExceeded.User.AccessKey","Message":"The count of access keys for the user exceeds the limit."}`)
+			return
+		}
+		key := f.addKey(time.Now())
+		out := f.keyOf(&key)
+		out.AccessKeySecret = key.Secret
+		json.NewEncoder(w).Encode(map[string]interface{}{"RequestId": "1", "AccessKey": out})
+	case "ram UpdateAccessKey":
+		if k, ok := f.keys[q.Get("UserAccessKeyId")]; ok {
+			k.Active = q.Get("Status") == "Active"
+		}
+		fmt.Fprint(w, `{"RequestId":"1"}`)
+	case "ram DeleteAccessKey":
+		delete(f.keys, q.Get("UserAccessKeyId"))
+		fmt.Fprint(w, `{"RequestId":"1"}`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"RequestId":"1","Code":"InvalidAction.NotFound","Message":"Specified api is not found."}`)
+	}
+}
@@ -0,0 +1,115 @@
+package fakecloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+// Azure is the token endpoint of the Microsoft identity platform and the
+// Graph API of one application. Its keys are the client secrets.
+type Azure struct {
+	*httptest.Server
+	keyring
+	Tenant      string
+	ClientID    string
+	DisplayName string
+	// Expiry is when the last secret added by the client expires
+	Expiry time.Time
+}
+
+// NewAzure starts a fake Azure. Close it when done.
+func NewAzure() *Azure {
+	f := &Azure{
+		keyring:     newKeyring(),
+		Tenant:      "11111111-2222-3333-4444-555555555555",
+		ClientID:    "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee",
+		DisplayName: "deploy-bot",
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// AddKey adds a client secret to the application
+func (f *Azure) AddKey(created time.Time) Key {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addKey(created)
+}
+
+// addKey adds a secret. Each secret starts differently, since Graph only
+// shows the first three characters.
+func (f *Azure) addKey(created time.Time) Key {
+	n := f.created + 1
+	return f.add(Key{
+		ID:      fmt.Sprintf("00000000-0000-0000-0000-%012d", n),
+		Secret:  fmt.Sprintf("s%02d~secretEXAMPLE", n),
+		Active:  true,
+		Created: created,
+	})
+}
+
+// azureSecret is a client secret as Graph shows it
+type azureSecret struct {
+	KeyID         string    `json:"keyId"`
+	DisplayName   string    `json:"displayName,omitempty"`
+	Hint          string    `json:"hint"`
+	StartDateTime time.Time `json:"startDateTime"`
+	EndDateTime   time.Time `json:"endDateTime"`
+	SecretText    string    `json:"secretText,omitempty"`
+}
+
+func (f *Azure) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/"+f.Tenant+"/oauth2/v2.0/token" {
+		for _, k := range f.keys {
+			if r.FormValue("client_id") == f.ClientID && k.Secret == r.FormValue("client_secret") {
+				fmt.Fprintf(w, `{"access_token":"token-%s","token_type":"Bearer"}`, k.ID)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided.\r\nTrace ID: 1"}`)
+		return
+	}
+
+	appPath := "/v1.0/applications(appId='" + f.ClientID + "')"
+	path := strings.TrimPrefix(r.URL.Path, appPath)
+	f.calls = append(f.calls, r.Method+" "+path+" as "+strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-"))
+	switch {
+	case !strings.HasPrefix(r.URL.Path, appPath):
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"Request_ResourceNotFound","message":"Resource does not exist."}}`)
+	case r.Method == "GET" && path == "":
+		secrets := []azureSecret{}
+		for _, k := range f.keys {
+			secrets = append(secrets, azureSecret{KeyID: k.ID, Hint: k.Secret[:3], StartDateTime: k.Created})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"appId": f.ClientID, "displayName": f.DisplayName, "passwordCredentials": secrets})
+	case r.Method == "POST" && path == "/addPassword":
+		var in struct{ PasswordCredential azureSecret }
+		json.NewDecoder(r.Body).Decode(&in)
+		k := f.addKey(time.Now())
+		f.Expiry = in.PasswordCredential.EndDateTime
+		json.NewEncoder(w).Encode(azureSecret{
+			KeyID:         k.ID,
+			DisplayName:   in.PasswordCredential.DisplayName,
+			Hint:          k.Secret[:3],
+			StartDateTime: k.Created,
+			EndDateTime:   f.Expiry,
+			SecretText:    k.Secret,
+		})
+	case r.Method == "POST" && path == "/removePassword":
+		var in struct{ KeyID string }
+		json.NewDecoder(r.Body).Decode(&in)
+		delete(f.keys, in.KeyID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":"Request_ResourceNotFound","message":"Resource does not exist."}}`)
+	}
+}
@@ -0,0 +1,77 @@
+// Package fakecloud has fakes of the cloud APIs that cloudkey calls over
+// HTTP, for tests. Each fake is a local server keeping the keys of one user
+// in memory, and records the calls made to it.
+package fakecloud
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Key is a key of the fake's user
+type Key struct {
+	ID string
+	// Secret is what the client needs to use the key, like a client secret
+	// or a private key in PEM. Fakes of clouds that only keep public keys
+	// leave it empty for the keys they create.
+	Secret  string
+	Active  bool
+	Created time.Time
+}
+
+// keyring keeps the keys of the fake's user and the calls made to the fake
+type keyring struct {
+	mu      sync.Mutex
+	keys    map[string]*Key
+	calls   []string
+	created int
+}
+
+func newKeyring() keyring {
+	return keyring{keys: make(map[string]*Key)}
+}
+
+// Keys lists the keys of the user by ID
+func (k *keyring) Keys() []Key {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keys := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys
+}
+
+// Calls lists the calls made to the fake, like "GET /keys as <key ID>"
+func (k *keyring) Calls() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string(nil), k.calls...)
+}
+
+// add adds a key. The caller holds mu.
+func (k *keyring) add(key Key) Key {
+	k.created++
+	k.keys[key.ID] = &key
+	return key
+}
+
+// newRSAKey generates a key pair and encodes its private key in PEM. The keys
+// are small to keep tests fast.
+func newRSAKey() (*rsa.PrivateKey, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
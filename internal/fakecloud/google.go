@@ -0,0 +1,123 @@
+package fakecloud
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+// Google is the OAuth token endpoint and IAM API of one service account
+type Google struct {
+	*httptest.Server
+	keyring
+	Email   string
+	Project string
+}
+
+// NewGoogle starts a fake Google. Close it when done.
+func NewGoogle() *Google {
+	f := &Google{keyring: newKeyring(), Email: "robot@my-project.iam.gserviceaccount.com", Project: "my-project"}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// AddKey adds a key of the service account. Its secret is the private key.
+func (f *Google) AddKey(created time.Time) Key {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addKey(created)
+}
+
+func (f *Google) addKey(created time.Time) Key {
+	_, private := newRSAKey()
+	return f.add(Key{ID: fmt.Sprintf("%040d", f.created+1), Secret: private, Active: true, Created: created})
+}
+
+// KeyFile is the key file of a key, as Google hands it out
+func (f *Google) KeyFile(key Key) []byte {
+	b, _ := json.MarshalIndent(map[string]string{
+		"type":           "service_account",
+		"project_id":     f.Project,
+		"private_key_id": key.ID,
+		"private_key":    key.Secret,
+		"client_email":   f.Email,
+		"token_uri":      f.URL + "/token",
+	}, "", "  ")
+	return b
+}
+
+// googleKey is a key of a service account in the IAM API
+type googleKey struct {
+	Name           string `json:"name"`
+	ValidAfterTime string `json:"validAfterTime"`
+	KeyType        string `json:"keyType"`
+	Disabled       bool   `json:"disabled"`
+	PrivateKeyData string `json:"privateKeyData,omitempty"`
+}
+
+func (f *Google) keyOf(k *Key) googleKey {
+	return googleKey{
+		Name:           "projects/" + f.Project + "/serviceAccounts/" + f.Email + "/keys/" + k.ID,
+		ValidAfterTime: k.Created.UTC().Format(time.RFC3339),
+		KeyType:        "USER_MANAGED",
+		Disabled:       !k.Active,
+	}
+}
+
+func (f *Google) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/token" {
+		// Only hand out tokens for enabled keys
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		var h struct{ Kid string }
+		json.Unmarshal(header, &h)
+		if k, ok := f.keys[h.Kid]; !ok || !k.Active {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%s","token_type":"Bearer","expires_in":3600}`, h.Kid)
+		return
+	}
+
+	account := "/v1/projects/-/serviceAccounts/" + f.Email
+	path := strings.TrimPrefix(r.URL.Path, account)
+	f.calls = append(f.calls, r.Method+" "+path+" as "+strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer token-"))
+	switch {
+	case !strings.HasPrefix(r.URL.Path, account):
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":404,"message":"Not found","status":"NOT_FOUND"}}`)
+	case r.Method == "GET" && path == "":
+		fmt.Fprintf(w, `{"email":%q,"projectId":%q}`, f.Email, f.Project)
+	case r.Method == "GET" && path == "/keys":
+		keys := []googleKey{}
+		for _, k := range f.keys {
+			keys = append(keys, f.keyOf(k))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	case r.Method == "POST" && path == "/keys":
+		key := f.addKey(time.Now())
+		k := f.keyOf(&key)
+		k.PrivateKeyData = base64.StdEncoding.EncodeToString(f.KeyFile(key))
+		// Google keeps only the public key
+		f.keys[key.ID].Secret = ""
+		json.NewEncoder(w).Encode(k)
+	case r.Method == "POST" && strings.HasSuffix(path, ":disable"):
+		if k, ok := f.keys[strings.TrimSuffix(strings.TrimPrefix(path, "/keys/"), ":disable")]; ok {
+			k.Active = false
+		}
+		fmt.Fprint(w, `{}`)
+	case r.Method == "DELETE" && strings.HasPrefix(path, "/keys/"):
+		delete(f.keys, strings.TrimPrefix(path, "/keys/"))
+		fmt.Fprint(w, `{}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":404,"message":"Not found","status":"NOT_FOUND"}}`)
+	}
+}
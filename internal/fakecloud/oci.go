@@ -0,0 +1,175 @@
+package fakecloud
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OCI is the Identity API of one user. It checks the signature of every
+// request. Its keys are the API signing keys, by fingerprint.
+type OCI struct {
+	*httptest.Server
+	keyring
+	Tenancy string
+	User    string
+	public  map[string]*rsa.PublicKey
+}
+
+// NewOCI starts a fake OCI. Close it when done.
+func NewOCI() *OCI {
+	f := &OCI{
+		keyring: newKeyring(),
+		Tenancy: "ocid1.tenancy.oc1..aaaaexample",
+		User:    "ocid1.user.oc1..aaaaexample",
+		public:  make(map[string]*rsa.PublicKey),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// AddKey adds an API key of the user. Its secret is the private key.
+func (f *OCI) AddKey(created time.Time) Key {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	private, data := newRSAKey()
+	return f.addKey(&private.PublicKey, data, created)
+}
+
+func (f *OCI) addKey(public *rsa.PublicKey, secret string, created time.Time) Key {
+	id := ociFingerprint(public)
+	f.public[id] = public
+	return f.add(Key{ID: id, Secret: secret, Active: true, Created: created})
+}
+
+// ociFingerprint is the MD5 of the public key, as OCI shows it
+func ociFingerprint(key *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		panic(err)
+	}
+	sum := md5.Sum(der)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+var ociAuthorization = regexp.MustCompile(`^Signature version="1",keyId="([^"]*)",algorithm="rsa-sha256",headers="([^"]*)",signature="([^"]*)"$`)
+
+// signedBy gets the fingerprint of the key that signed the request. The
+// body must match its signed hash.
+func (f *OCI) signedBy(r *http.Request, body []byte) (string, bool) {
+	m := ociAuthorization.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return "", false
+	}
+	keyID := strings.SplitN(m[1], "/", 3)
+	if len(keyID) != 3 || keyID[0] != f.Tenancy || keyID[1] != f.User {
+		return "", false
+	}
+	key, ok := f.public[keyID[2]]
+	if !ok {
+		return "", false
+	}
+	var lines []string
+	for _, h := range strings.Split(m[2], " ") {
+		switch h {
+		case "(request-target)":
+			lines = append(lines, h+": "+strings.ToLower(r.Method)+" "+r.URL.RequestURI())
+		case "host":
+			lines = append(lines, h+": "+r.Host)
+		default:
+			lines = append(lines, h+": "+r.Header.Get(h))
+		}
+	}
+	if r.Method == "POST" {
+		sum := sha256.Sum256(body)
+		if r.Header.Get("X-Content-Sha256") != base64.StdEncoding.EncodeToString(sum[:]) || !strings.Contains(m[2], "x-content-sha256") {
+			return "", false
+		}
+	}
+	sig, _ := base64.StdEncoding.DecodeString(m[3])
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return keyID[2], rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig) == nil
+}
+
+// ociKey is an API key of a user in the Identity API
+type ociKey struct {
+	KeyID          string    `json:"keyId"`
+	Fingerprint    string    `json:"fingerprint"`
+	LifecycleState string    `json:"lifecycleState"`
+	TimeCreated    time.Time `json:"timeCreated"`
+}
+
+func (f *OCI) keyOf(k *Key) ociKey {
+	return ociKey{KeyID: f.Tenancy + "/" + f.User + "/" + k.ID, Fingerprint: k.ID, LifecycleState: "ACTIVE", TimeCreated: k.Created}
+}
+
+func (f *OCI) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	signer, ok := f.signedBy(r, body)
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"code":"NotAuthenticated","message":"The required information to complete authentication was not provided or was incorrect."}`)
+		return
+	}
+
+	user := "/20160918/users/" + f.User
+	path := strings.TrimPrefix(r.URL.Path, user)
+	f.calls = append(f.calls, r.Method+" "+path+" as "+signer)
+	switch {
+	case !strings.HasPrefix(r.URL.Path, user):
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":"NotAuthorizedOrNotFound","message":"Authorization failed or requested resource not found."}`)
+	case r.Method == "GET" && path == "":
+		fmt.Fprintf(w, `{"id":%q,"compartmentId":%q,"name":"deploy-bot"}`, f.User, f.Tenancy)
+	case r.Method == "GET" && path == "/apiKeys/":
+		keys := []ociKey{}
+		for _, k := range f.keys {
+			keys = append(keys, f.keyOf(k))
+		}
+		json.NewEncoder(w).Encode(keys)
+	case r.Method == "POST" && path == "/apiKeys/":
+		var in struct{ Key string }
+		json.NewDecoder(bytes.NewReader(body)).Decode(&in)
+		block, _ := pem.Decode([]byte(in.Key))
+		var public interface{}
+		if block != nil && block.Type == "PUBLIC KEY" {
+			public, _ = x509.ParsePKIXPublicKey(block.Bytes)
+		}
+		rsaKey, ok := public.(*rsa.PublicKey)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":"InvalidParameter","message":"Invalid public key"}`)
+			return
+		}
+		// OCI keeps only the public key
+		k := f.addKey(rsaKey, "", time.Now())
+		json.NewEncoder(w).Encode(f.keyOf(&k))
+	case r.Method == "DELETE" && strings.HasPrefix(path, "/apiKeys/"):
+		id := strings.TrimPrefix(path, "/apiKeys/")
+		delete(f.keys, id)
+		delete(f.public, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"code":"NotAuthorizedOrNotFound","message":"Authorization failed or requested resource not found."}`)
+	}
+}
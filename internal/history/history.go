@@ -0,0 +1,137 @@
+// Package history keeps an append-only log of key rotations, one JSON entry
+// per line.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Outcomes of a rotation
+const (
+	Rotated    = "rotated"
+	Skipped    = "skipped"
+	RolledBack = "rolled back"
+	Failed     = "failed"
+)
+
+// Entry records one rotation. Access key IDs are stored masked, never in full.
+type Entry struct {
+	Cloud    string    `json:"cloud"`
+	Profile  string    `json:"profile"`
+	Source   string    `json:"source,omitempty"`
+	Account  string    `json:"account,omitempty"`
+	UserName string    `json:"userName,omitempty"`
+	OldKeyID string    `json:"oldKeyId,omitempty"`
+	NewKeyID string    `json:"newKeyId,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// appendLock keeps entries appended by concurrent rotations from interleaving
+var appendLock sync.Mutex
+
+// Append adds an entry to the end of the log at path, creating it if needed
+func Append(path string, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	appendLock.Lock()
+	defer appendLock.Unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read reads the entries of the log at path, oldest first. It returns nothing
+// if the log does not exist.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Filter selects entries. Empty fields match every entry, except Profile
+// when ProfileSet is set: the empty profile name is the environment variables.
+type Filter struct {
+	Cloud      string
+	Profile    string
+	ProfileSet bool
+	Outcome    string
+	Since      time.Time
+}
+
+// Match checks whether an entry is selected by the filter
+func (f Filter) Match(e Entry) bool {
+	switch {
+	case f.Cloud != "" && e.Cloud != f.Cloud:
+		return false
+	case (f.ProfileSet || f.Profile != "") && e.Profile != f.Profile:
+		return false
+	case f.Outcome != "" && e.Outcome != f.Outcome:
+		return false
+	case !f.Since.IsZero() && e.Started.Before(f.Since):
+		return false
+	}
+	return true
+}
+
+// Select returns the entries matching the filter, in the same order
+func Select(entries []Entry, f Filter) []Entry {
+	var selected []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
+
+// LastRotated gets when each profile of a cloud was last rotated
+// successfully, by profile name
+func LastRotated(entries []Entry, cloud string) map[string]time.Time {
+	last := make(map[string]time.Time)
+	for _, e := range entries {
+		if e.Cloud != cloud || e.Outcome != Rotated {
+			continue
+		}
+		if e.Finished.After(last[e.Profile]) {
+			last[e.Profile] = e.Finished
+		}
+	}
+	return last
+}
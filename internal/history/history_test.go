@@ -0,0 +1,117 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "history.jsonl"), func() { os.RemoveAll(dir) }
+}
+
+func TestAppendRead(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	start := time.Date(2020, 3, 14, 9, 0, 0, 0, time.UTC)
+
+	t.Run("missing log", func(t *testing.T) {
+		got, err := Read(path)
+
+		if err != nil || len(got) != 0 {
+			t.Errorf("got %v, %v but want nothing", got, err)
+		}
+	})
+	t.Run("append entries", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				e := Entry{Cloud: "aws", Profile: "lab", Started: start.Add(time.Duration(i) * time.Minute), Outcome: Rotated}
+				if err := Append(path, e); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		got, err := Read(path)
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if len(got) != 10 {
+			t.Errorf("got %d entries but want 10", len(got))
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("got permissions %v but want 0600", perm)
+		}
+	})
+	t.Run("fail on bad entry", func(t *testing.T) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("{not json\n")
+		f.Close()
+
+		_, err = Read(path)
+
+		if err == nil {
+			t.Error("wanted an error but didn't get one")
+		}
+	})
+}
+
+func TestSelect(t *testing.T) {
+	day := time.Date(2020, 3, 14, 0, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Cloud: "aws", Profile: "lab", Started: day, Finished: day, Outcome: Rotated},
+		{Cloud: "aws", Profile: "corp", Started: day, Finished: day, Outcome: Failed},
+		{Cloud: "aws", Profile: "lab", Started: day.AddDate(0, 0, 2), Finished: day.AddDate(0, 0, 2), Outcome: Rotated},
+		{Cloud: "aws", Profile: "lab", Started: day.AddDate(0, 0, 3), Finished: day.AddDate(0, 0, 3), Outcome: Skipped},
+		{Cloud: "aws", Profile: "", Started: day.AddDate(0, 0, 4), Finished: day.AddDate(0, 0, 4), Outcome: Rotated},
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"everything", Filter{}, 5},
+		{"profile", Filter{Profile: "lab"}, 3},
+		{"environment variables", Filter{ProfileSet: true}, 1},
+		{"outcome", Filter{Outcome: Failed}, 1},
+		{"since", Filter{Since: day.AddDate(0, 0, 1)}, 3},
+		{"other cloud", Filter{Cloud: "gcp"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Select(entries, tt.filter); len(got) != tt.want {
+				t.Errorf("got %d entries but want %d", len(got), tt.want)
+			}
+		})
+	}
+	t.Run("last rotated", func(t *testing.T) {
+		last := LastRotated(entries, "aws")
+
+		if got, want := last["lab"], day.AddDate(0, 0, 2); !got.Equal(want) {
+			t.Errorf("got %v but want %v", got, want)
+		}
+		if _, ok := last["corp"]; ok {
+			t.Error("failed rotation counted as rotated")
+		}
+	})
+}
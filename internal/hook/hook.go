@@ -0,0 +1,72 @@
+// Package hook runs user commands before and after a key rotation. Secrets are
+// handed to a command in its environment and on stdin, never on its command
+// line, where other users could see them.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// DefaultTimeout is how long a hook may run before it is killed
+const DefaultTimeout = 5 * time.Minute
+
+// Hook is a command run by the shell
+type Hook struct {
+	Command string
+	// Env is added to cloudkey's own environment
+	Env []string
+	// Stdin is written to the command's standard input
+	Stdin []byte
+	// Stdout and Stderr receive the command's output
+	Stdout  io.Writer
+	Stderr  io.Writer
+	Timeout time.Duration
+}
+
+// Error is returned when a hook fails or exits with a non-zero status
+type Error struct {
+	Command string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hook %q failed: %v", e.Command, e.Err)
+}
+
+// Run runs the hook and waits for it to finish
+func (h Hook) Run() error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, h.Command)
+	cmd.Env = append(os.Environ(), h.Env...)
+	cmd.Stdin = bytes.NewReader(h.Stdin)
+	cmd.Stdout = h.Stdout
+	cmd.Stderr = h.Stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		return &Error{Command: h.Command, Err: err}
+	}
+	return nil
+}
+
+// shellCommand runs a command line with the system's shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
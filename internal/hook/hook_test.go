@@ -0,0 +1,48 @@
+package hook
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks are tested with a POSIX shell")
+	}
+
+	t.Run("pass secret in environment and stdin", func(t *testing.T) {
+		var stdout bytes.Buffer
+		h := Hook{
+			Command: `printf '%s ' "$SECRET"; cat`,
+			Env:     []string{"SECRET=from-env"},
+			Stdin:   []byte("from-stdin"),
+			Stdout:  &stdout,
+		}
+
+		err := h.Run()
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if got, want := stdout.String(), "from-env from-stdin"; got != want {
+			t.Errorf("got %q but want %q", got, want)
+		}
+	})
+	t.Run("fail on non-zero exit status", func(t *testing.T) {
+		err := Hook{Command: "exit 3"}.Run()
+
+		if _, ok := err.(*Error); !ok || !strings.Contains(err.Error(), "exit status 3") {
+			t.Errorf("got %v but want a hook error with the exit status", err)
+		}
+	})
+	t.Run("kill hook after timeout", func(t *testing.T) {
+		err := Hook{Command: "sleep 5", Timeout: 10 * time.Millisecond}.Run()
+
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("got %v but want a timeout", err)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+// Package httpapi calls the JSON APIs of the clouds that cloudkey reaches
+// without an SDK, and reads the errors they return.
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client makes the requests to the clouds
+var Client = &http.Client{Timeout: 30 * time.Second}
+
+// Error is an error returned by a cloud API
+type Error struct {
+	StatusCode int
+	// Code is the API's name for the error, like NOT_FOUND or invalid_grant
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// NewRequest makes a request with in as its JSON body, unless in is nil
+func NewRequest(method, url string, in interface{}) (*http.Request, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// Do sends a request and decodes the JSON response into out, unless out is
+// nil. A response without a 2xx status is returned as an *Error.
+func Do(req *http.Request, out interface{}) error {
+	resp, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return readError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// PostForm posts a form, like to an OAuth token endpoint, and decodes the
+// response like Do
+func PostForm(url string, form url.Values, out interface{}) error {
+	req, err := http.NewRequest("POST", url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return Do(req, out)
+}
+
+// readError reads the error from a response. The clouds return
+//
+//	{"error": {"code", "message"}}           Microsoft Graph
+//	{"error": {"status", "message"}}         Google APIs
+//	{"error", "error_description"}           OAuth token endpoints
+//	{"code", "message"}                      OCI, and Alibaba Cloud capitalized
+//
+// Anything else is kept as the message.
+func readError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	e := &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	var apiBody struct {
+		Error            json.RawMessage `json:"error"`
+		ErrorDescription string          `json:"error_description"`
+		Code             json.RawMessage `json:"code"`
+		Message          string          `json:"message"`
+	}
+	if json.Unmarshal(body, &apiBody) != nil {
+		return e
+	}
+	var detail struct {
+		Code    json.RawMessage `json:"code"`
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+	}
+	switch {
+	case apiBody.Error == nil:
+		if code := stringOf(apiBody.Code); code != "" {
+			e.Code, e.Message = code, apiBody.Message
+		}
+	case json.Unmarshal(apiBody.Error, &detail) == nil:
+		// Google's code is the HTTP status, and its status the name
+		e.Code, e.Message = detail.Status, detail.Message
+		if e.Code == "" {
+			e.Code = stringOf(detail.Code)
+		}
+	case stringOf(apiBody.Error) != "":
+		// Azure's description repeats the code and adds a trace ID on other lines
+		e.Code, e.Message = stringOf(apiBody.Error), strings.SplitN(apiBody.ErrorDescription, "\r\n", 2)[0]
+	}
+	return e
+}
+
+// stringOf is the JSON string in raw, or empty if it is something else
+func stringOf(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
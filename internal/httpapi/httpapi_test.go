@@ -0,0 +1,66 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"name":"robot"}`)
+	}))
+	defer server.Close()
+	req, err := NewRequest("POST", server.URL, map[string]string{"name": "robot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct{ Name string }
+
+	err = Do(req, &out)
+
+	if err != nil || out.Name != "robot" {
+		t.Errorf("got %+v, %v but want the decoded response", out, err)
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"Graph", 404, `{"error":{"code":"Request_ResourceNotFound","message":"Resource does not exist."}}`, "Request_ResourceNotFound: Resource does not exist."},
+		{"Google", 404, `{"error":{"code":404,"message":"Not found","status":"NOT_FOUND"}}`, "NOT_FOUND: Not found"},
+		{"OAuth", 401, `{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided.\r\nTrace ID: 1"}`, "invalid_client: AADSTS7000215: Invalid client secret provided."},
+		{"OCI", 401, `{"code":"NotAuthenticated","message":"The required information was not provided."}`, "NotAuthenticated: The required information was not provided."},
+		{"Alibaba Cloud", 400, `{"RequestId":"1","Code":"InvalidAccessKeyId.NotFound","Message":"Specified access key is not found."}`, "InvalidAccessKeyId.NotFound: Specified access key is not found."},
+		{"not JSON", 502, "Bad Gateway\n", "HTTP 502: Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+
+			err := PostForm(server.URL, url.Values{"grant_type": {"client_credentials"}}, nil)
+
+			e, ok := err.(*Error)
+			if !ok || e.StatusCode != tt.status {
+				t.Fatalf("got %v but want an *Error with status %d", err, tt.status)
+			}
+			if got := err.Error(); got != tt.want {
+				t.Errorf("got %q but want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Package inifile edits INI files such as the AWS credentials file without
+// disturbing anything it was not asked to change. Comments, blank lines,
+// ordering, unknown keys and line endings are all kept byte-for-byte.
+package inifile
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/buzzsurfr/cloudkey/internal/atomicfile"
+)
+
+// Key is a key and its value in a section
+type Key struct {
+	Name  string
+	Value string
+}
+
+// Section is a section of an INI file. Sections that appear more than once
+// are merged, with later keys overriding earlier ones.
+type Section struct {
+	Name string
+	Line int // line number of the section's first header
+	Keys []Key
+}
+
+// Get gets the value of a key. Key names are not case sensitive.
+func (s Section) Get(name string) (string, bool) {
+	for _, k := range s.Keys {
+		if strings.EqualFold(k.Name, name) {
+			return k.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParseError reports a line that is not a section header, key, comment or
+// blank line
+type ParseError struct {
+	Path string
+	Line int
+	Text string
+}
+
+func (e *ParseError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("line %d: malformed line %q", e.Line, e.Text)
+	}
+	return fmt.Sprintf("%s:%d: malformed line %q", e.Path, e.Line, e.Text)
+}
+
+// Parse parses data into its sections, in the order they first appear.
+// Section and key names keep their case.
+func Parse(data []byte) ([]Section, error) {
+	var sections []Section
+	index := make(map[string]int)
+	current := -1
+	for i, l := range split(data) {
+		content := strings.TrimSpace(l.text)
+		switch {
+		case l.bad:
+			return nil, &ParseError{Line: i + 1, Text: content}
+		case l.header:
+			n, ok := index[l.section]
+			if !ok {
+				n = len(sections)
+				index[l.section] = n
+				sections = append(sections, Section{Name: l.section, Line: i + 1})
+			}
+			current = n
+		case l.key != "":
+			if current < 0 {
+				return nil, &ParseError{Line: i + 1, Text: content}
+			}
+			sections[current].set(l.key, l.value)
+		case l.nested != "" && current >= 0:
+			// Keep nested values under their key, one per line
+			keys := sections[current].Keys
+			last := &keys[len(keys)-1]
+			last.Value = strings.TrimPrefix(last.Value+"\n"+l.nested, "\n")
+		}
+	}
+	return sections, nil
+}
+
+// ParseFile parses the INI file at path. Errors for malformed lines include
+// the path.
+func ParseFile(path string) ([]Section, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := Parse(data)
+	if perr, ok := err.(*ParseError); ok {
+		perr.Path = path
+	}
+	return sections, err
+}
+
+// set sets a key, replacing a key with the same name
+func (s *Section) set(name, value string) {
+	for i, k := range s.Keys {
+		if strings.EqualFold(k.Name, name) {
+			s.Keys[i].Value = value
+			return
+		}
+	}
+	s.Keys = append(s.Keys, Key{Name: name, Value: value})
+}
+
+// line is one line of an INI file, including its line ending
+type line struct {
+	text    string
+	section string // section the line belongs to
+	header  bool   // line is a section header
+	key     string // key name, if the line is a key
+	value   string // value, if the line is a key
+	nested  string // nested value under the previous key
+	bad     bool   // line is malformed
+}
+
+// Set returns a copy of data with the keys set in section. Existing keys are
+// changed in place, missing keys are added after the last key of the section,
+// and a missing section is appended to the end of the file.
+func Set(data []byte, section string, keys []Key) []byte {
+	lines := split(data)
+	eol := lineEnding(data)
+
+	var missing []Key
+	for _, k := range keys {
+		found := false
+		for i, l := range lines {
+			if l.header || l.section != section || !strings.EqualFold(l.key, k.Name) {
+				continue
+			}
+			lines[i].text = setValue(l.text, k.Value)
+			found = true
+		}
+		if !found {
+			missing = append(missing, k)
+		}
+	}
+
+	var buf bytes.Buffer
+	if len(missing) == 0 {
+		for _, l := range lines {
+			buf.WriteString(l.text)
+		}
+		return buf.Bytes()
+	}
+
+	// Add missing keys after the last key (or header) of the first matching section
+	insertAfter := -1
+	for i, l := range lines {
+		if l.section != section {
+			if insertAfter >= 0 {
+				break
+			}
+			continue
+		}
+		if l.header || l.key != "" {
+			insertAfter = i
+		}
+	}
+	if insertAfter < 0 {
+		// Append a new section, separated from the rest by a blank line
+		for _, l := range lines {
+			buf.WriteString(l.text)
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString(eol)
+		}
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte(eol+eol)) {
+			buf.WriteString(eol)
+		}
+		buf.WriteString("[" + section + "]" + eol)
+		for _, k := range missing {
+			buf.WriteString(k.Name + " = " + k.Value + eol)
+		}
+		buf.WriteString(eol)
+		return buf.Bytes()
+	}
+	for i, l := range lines {
+		buf.WriteString(l.text)
+		if i != insertAfter {
+			continue
+		}
+		if !strings.HasSuffix(l.text, "\n") {
+			buf.WriteString(eol)
+		}
+		for _, k := range missing {
+			buf.WriteString(k.Name + " = " + k.Value + eol)
+		}
+	}
+	return buf.Bytes()
+}
+
+// SetFile sets the keys in a section of the file at path, which is created
+// if it does not exist. The file is replaced atomically and given perm.
+func SetFile(path, section string, keys []Key, perm os.FileMode) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return atomicfile.WriteFile(path, Set(data, section, keys), perm)
+}
+
+// split breaks data into lines, keeping each line's ending
+func split(data []byte) []line {
+	var lines []line
+	section := ""
+	inValue := false // the previous line was a key, so lines indented deeper continue its value
+	keyIndent := 0   // the indentation of that key
+	for len(data) > 0 {
+		n := bytes.IndexByte(data, '\n')
+		if n < 0 {
+			n = len(data)
+		} else {
+			n++
+		}
+		l := line{text: string(data[:n])}
+		data = data[n:]
+
+		content := strings.TrimSpace(strings.TrimPrefix(l.text, "\ufeff"))
+		indent := len(l.text) - len(strings.TrimLeft(l.text, " \t"))
+		switch {
+		case content == "", content[0] == '#', content[0] == ';':
+			inValue = false
+		case inValue && indent > keyIndent:
+			// Nested value, such as the settings under s3 in ~/.aws/config
+			l.nested = content
+		case content[0] == '[':
+			if !strings.HasSuffix(content, "]") || strings.TrimSpace(content[1:len(content)-1]) == "" {
+				l.bad = true
+				break
+			}
+			section = strings.TrimSpace(content[1 : len(content)-1])
+			l.header = true
+			inValue = false
+		case strings.Contains(content, "="):
+			eq := strings.Index(content, "=")
+			l.key = strings.TrimSpace(content[:eq])
+			l.value = strings.TrimSpace(content[eq+1:])
+			l.bad = l.key == ""
+			inValue, keyIndent = true, indent
+		default:
+			l.bad = true
+		}
+		l.section = section
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// setValue replaces the value of a key line, keeping the key, the spacing
+// around the equals sign and the line ending
+func setValue(text, value string) string {
+	eq := strings.Index(text, "=")
+	prefix := text[:eq+1]
+	rest := text[eq+1:]
+	for len(rest) > 0 && (rest[0] == ' ' || rest[0] == '\t') {
+		prefix += rest[:1]
+		rest = rest[1:]
+	}
+	ending := ""
+	if strings.HasSuffix(rest, "\r\n") {
+		ending = "\r\n"
+	} else if strings.HasSuffix(rest, "\n") {
+		ending = "\n"
+	}
+	return prefix + value + ending
+}
+
+// lineEnding guesses the line ending used by data
+func lineEnding(data []byte) string {
+	if bytes.Contains(data, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
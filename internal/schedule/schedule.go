@@ -0,0 +1,282 @@
+// Package schedule works out when the next unattended rotation is due, from a
+// cron expression or a fixed interval.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule gets the next time after t that something is due
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// Interval is due every so often
+type Interval time.Duration
+
+// Next gets the time one interval after t
+func (i Interval) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(i))
+}
+
+// Cron returns the interval as a cron expression. Only intervals that evenly
+// divide an hour or a day can be written as one.
+func (i Interval) Cron() (*Cron, error) {
+	d := time.Duration(i)
+	switch {
+	case d <= 0:
+		// Can't be written as a cron expression
+	case d == 24*time.Hour:
+		return Parse("0 0 * * *")
+	case d%time.Hour == 0 && 24%int(d/time.Hour) == 0:
+		return Parse(fmt.Sprintf("0 */%d * * *", d/time.Hour))
+	case d%time.Minute == 0 && d < time.Hour && 60%int(d/time.Minute) == 0:
+		return Parse(fmt.Sprintf("*/%d * * * *", d/time.Minute))
+	}
+	return nil, fmt.Errorf("The interval %s can't be written as a cron expression. Use one that divides an hour or a day evenly", d)
+}
+
+func (i Interval) String() string {
+	return "every " + time.Duration(i).String()
+}
+
+// Cron is due at the times matching a cron expression, with the fields
+// minute, hour, day of month, month and day of week
+type Cron struct {
+	spec                          string
+	minute, hour, dom, month, dow field
+}
+
+// field is the set of values a cron field matches, one bit per value
+type field struct {
+	bits uint64
+	// star is set for a field given as "*" (or "*/n"), which matters for the
+	// day of month and day of week
+	star bool
+}
+
+func (f field) has(v int) bool {
+	return f.bits&(1<<uint(v)) != 0
+}
+
+// values lists the matching values in order
+func (f field) values(min, max int) []int {
+	var vs []int
+	for v := min; v <= max; v++ {
+		if f.has(v) {
+			vs = append(vs, v)
+		}
+	}
+	return vs
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var dayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Parse parses a cron expression like "30 3 * * 1-5", or one of @hourly,
+// @daily, @weekly, @monthly and @yearly. Months and days of the week can also
+// be given by their first three letters. Sunday is 0 or 7.
+func Parse(spec string) (*Cron, error) {
+	expr := strings.TrimSpace(spec)
+	if m, ok := macros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Bad cron expression %q: must have 5 fields (minute, hour, day of month, month, day of week)", spec)
+	}
+	c := &Cron{spec: spec}
+	var err error
+	parsers := []struct {
+		f        *field
+		name     string
+		min, max int
+		names    map[string]int
+	}{
+		{&c.minute, "minute", 0, 59, nil},
+		{&c.hour, "hour", 0, 23, nil},
+		{&c.dom, "day of month", 1, 31, nil},
+		{&c.month, "month", 1, 12, monthNames},
+		{&c.dow, "day of week", 0, 7, dayNames},
+	}
+	for i, p := range parsers {
+		if *p.f, err = parseField(fields[i], p.min, p.max, p.names); err != nil {
+			return nil, fmt.Errorf("Bad cron expression %q: %s %v", spec, p.name, err)
+		}
+	}
+	// Sunday is both 0 and 7
+	if c.dow.has(7) {
+		c.dow.bits |= 1
+		c.dow.bits &^= 1 << 7
+	}
+	return c, nil
+}
+
+// parseField parses a comma-separated list of values, ranges like 1-5 and
+// steps like */15 or 0-30/10
+func parseField(s string, min, max int, names map[string]int) (field, error) {
+	var f field
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return f, fmt.Errorf("has a bad step in %q", part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := min, max
+		switch {
+		case rng == "*":
+			f.star = f.star || len(strings.Split(s, ",")) == 1
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = parseValue(bounds[0], names); err != nil {
+				return f, err
+			}
+			if hi, err = parseValue(bounds[1], names); err != nil {
+				return f, err
+			}
+		default:
+			v, err := parseValue(rng, names)
+			if err != nil {
+				return f, err
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return f, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			f.bits |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func parseValue(s string, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("has a bad value %q", s)
+	}
+	return v, nil
+}
+
+// Next gets the first matching minute after t, in t's time zone. It returns
+// the zero time if nothing matches within five years, like February 30.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case !c.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches checks the day of month and day of week. Like cron, a day
+// matches either of them if both are restricted.
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	if c.dom.star || c.dow.star {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *Cron) String() string {
+	return c.spec
+}
+
+// ErrNotCalendar means a cron expression restricts both the day of month and
+// the day of week, which a systemd calendar event can't express
+var ErrNotCalendar = errors.New("A systemd timer can't restrict both the day of month and the day of week. Use a crontab instead")
+
+// OnCalendar formats the schedule as a systemd calendar event, for the
+// OnCalendar setting of a timer
+func (c *Cron) OnCalendar() (string, error) {
+	if !c.dom.star && !c.dow.star {
+		return "", ErrNotCalendar
+	}
+	list := func(f field, min, max int, format string) string {
+		if f.star && len(f.values(min, max)) == max-min+1 {
+			return "*"
+		}
+		vs := f.values(min, max)
+		s := make([]string, len(vs))
+		for i, v := range vs {
+			s[i] = fmt.Sprintf(format, v)
+		}
+		return strings.Join(s, ",")
+	}
+	event := fmt.Sprintf("*-%s-%s %s:%s:00",
+		list(c.month, 1, 12, "%02d"),
+		list(c.dom, 1, 31, "%02d"),
+		list(c.hour, 0, 23, "%02d"),
+		list(c.minute, 0, 59, "%02d"),
+	)
+	if !c.dow.star || len(c.dow.values(0, 6)) < 7 {
+		days := []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+		var names []string
+		for _, v := range c.dow.values(0, 6) {
+			names = append(names, days[v])
+		}
+		event = strings.Join(names, ",") + " " + event
+	}
+	return event, nil
+}
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Jitter adds a random delay of up to max to t, so that many machines on the
+// same schedule don't all rotate at once
+func Jitter(t time.Time, max time.Duration) time.Time {
+	if max <= 0 {
+		return t
+	}
+	rndMu.Lock()
+	defer rndMu.Unlock()
+	return t.Add(time.Duration(rnd.Int63n(int64(max))))
+}
@@ -0,0 +1,113 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Saturday
+	now := time.Date(2020, 3, 14, 9, 26, 53, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2020, 3, 14, 9, 27, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2020, 3, 14, 9, 30, 0, 0, time.UTC)},
+		{spec: "30 3 * * *", want: time.Date(2020, 3, 15, 3, 30, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 9 * * mon-fri", want: time.Date(2020, 3, 16, 9, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", want: time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 jan,jul *", want: time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 12 29 2 *", want: time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		// Either the 20th or a Monday
+		{spec: "0 0 20 * 1", want: time.Date(2020, 3, 16, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 30 2 *", want: time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) got error %q but didn't want one", tt.spec, err)
+			continue
+		}
+
+		if got := c.Next(now); !got.Equal(tt.want) {
+			t.Errorf("%q got %v but want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * foo *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) got no error but want one", spec)
+		}
+	}
+}
+
+func TestOnCalendar(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr error
+	}{
+		{spec: "@daily", want: "*-*-* 00:00:00"},
+		{spec: "30 3 * * *", want: "*-*-* 03:30:00"},
+		{spec: "*/20 * * * *", want: "*-*-* *:00,20,40:00"},
+		{spec: "0 9 * * 1-5", want: "Mon,Tue,Wed,Thu,Fri *-*-* 09:00:00"},
+		{spec: "0 0 1 */6 *", want: "*-01,07-01 00:00:00"},
+		{spec: "0 0 20 * 1", wantErr: ErrNotCalendar},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := c.OnCalendar()
+
+		if err != tt.wantErr || got != tt.want {
+			t.Errorf("%q got %q, %v but want %q, %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestIntervalCron(t *testing.T) {
+	tests := []struct {
+		every time.Duration
+		want  string
+	}{
+		{every: 15 * time.Minute, want: "*/15 * * * *"},
+		{every: 6 * time.Hour, want: "0 */6 * * *"},
+		{every: 24 * time.Hour, want: "0 0 * * *"},
+		{every: 7 * time.Hour},
+		{every: 48 * time.Hour},
+	}
+	for _, tt := range tests {
+		c, err := Interval(tt.every).Cron()
+
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s got %v but want an error", tt.every, c)
+			}
+			continue
+		}
+		if err != nil || c.String() != tt.want {
+			t.Errorf("%s got %v, %v but want %q", tt.every, c, err, tt.want)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	now := time.Date(2020, 3, 14, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		got := Jitter(now, time.Minute)
+
+		if got.Before(now) || !got.Before(now.Add(time.Minute)) {
+			t.Fatalf("got %v but want within a minute after %v", got, now)
+		}
+	}
+	if got := Jitter(now, 0); !got.Equal(now) {
+		t.Errorf("got %v but want no jitter", got)
+	}
+}
@@ -0,0 +1,442 @@
+// Package settings reads cloudkey's own settings (~/.cloudkey.yaml): defaults
+// for every AWS profile, overrides for single profiles, the same for the
+// other clouds under clouds, and backups.
+//
+//	defaults:
+//	  max_key_age: 90d
+//	  grace_period: 7d
+//	backups:
+//	  keep: 10
+//	profiles:
+//	  lab:
+//	    max_key_age: 30d
+//	    require_mfa: true
+//	    account_id: "123456789012"
+//	    exclude_from_bulk: true
+//	    hooks:
+//	      post:
+//	        - ./update-jenkins.sh
+//	clouds:
+//	  gcp:
+//	    defaults:
+//	      max_key_age: 30d
+//	    profiles:
+//	      ci:
+//	        exclude_from_bulk: true
+package settings
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultBackupsKeep is how many backups of each file are kept by default
+const DefaultBackupsKeep = 10
+
+// AWS is the cloud of the top-level defaults and profiles, which cloudkey
+// had before it rotated the keys of other clouds
+const AWS = "aws"
+
+// awsOnly are the settings that only AWS rotations use
+var awsOnly = map[string]bool{"grace_period": true, "require_mfa": true, "account_id": true}
+
+// Hooks are the commands run before and after rotating a profile
+type Hooks struct {
+	Pre  []string
+	Post []string
+}
+
+// Policy is how a profile is rotated, after applying the defaults and the
+// profile's own overrides
+type Policy struct {
+	// MaxKeyAge skips access keys younger than this. Zero always rotates.
+	MaxKeyAge time.Duration
+	// GracePeriod keeps the old access key deactivated this long instead of
+	// deleting it. Zero deletes it right away.
+	GracePeriod time.Duration
+	// RequireMFA makes the IAM calls with an MFA session
+	RequireMFA bool
+	// AccountID refuses to rotate an access key of any other account
+	AccountID string
+	// ExcludeFromBulk leaves the profile out of rotate --all and patterns
+	ExcludeFromBulk bool
+	Hooks           Hooks
+}
+
+// overrides are the settings given for the defaults or a profile. Nil fields
+// were not given.
+type overrides struct {
+	maxKeyAge       *time.Duration
+	gracePeriod     *time.Duration
+	requireMFA      *bool
+	accountID       *string
+	excludeFromBulk *bool
+	pre             []string
+	post            []string
+	hasPre, hasPost bool
+}
+
+// apply changes the policy by the given settings
+func (o overrides) apply(p *Policy) {
+	if o.maxKeyAge != nil {
+		p.MaxKeyAge = *o.maxKeyAge
+	}
+	if o.gracePeriod != nil {
+		p.GracePeriod = *o.gracePeriod
+	}
+	if o.requireMFA != nil {
+		p.RequireMFA = *o.requireMFA
+	}
+	if o.accountID != nil {
+		p.AccountID = *o.accountID
+	}
+	if o.excludeFromBulk != nil {
+		p.ExcludeFromBulk = *o.excludeFromBulk
+	}
+	if o.hasPre {
+		p.Hooks.Pre = o.pre
+	}
+	if o.hasPost {
+		p.Hooks.Post = o.post
+	}
+}
+
+// cloudSettings are the defaults and profile overrides of one cloud
+type cloudSettings struct {
+	defaults overrides
+	profiles map[string]overrides
+}
+
+// Settings are cloudkey's settings
+type Settings struct {
+	// BackupsKeep is how many backups of each file are kept. Zero keeps every backup.
+	BackupsKeep int
+	clouds      map[string]cloudSettings
+}
+
+// Policy gets the policy of a profile of a cloud. Only the settings written
+// for that cloud apply, so that the hooks of an AWS profile never get the key
+// of a profile of another cloud with the same name. Profile names are case
+// sensitive, like the profiles of the credentials file.
+func (s *Settings) Policy(cloud, profile string) Policy {
+	var p Policy
+	c := s.clouds[cloud]
+	c.defaults.apply(&p)
+	if o, ok := c.profiles[profile]; ok {
+		o.apply(&p)
+	}
+	return p
+}
+
+// Clouds lists the clouds with settings under clouds, in order
+func (s *Settings) Clouds() []string {
+	var names []string
+	for name := range s.clouds {
+		if name != AWS {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Problem is an unknown key or a bad value in the settings
+type Problem struct {
+	Key     string
+	Message string
+}
+
+func (p Problem) String() string {
+	return p.Key + ": " + p.Message
+}
+
+// Error is returned when the settings have problems
+type Error struct {
+	Problems []Problem
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		lines[i] = p.String()
+	}
+	return "Bad settings:\n  " + strings.Join(lines, "\n  ")
+}
+
+// parser collects the problems found while parsing
+type parser struct {
+	problems []Problem
+}
+
+func (ps *parser) problem(key, format string, a ...interface{}) {
+	ps.problems = append(ps.problems, Problem{Key: key, Message: fmt.Sprintf(format, a...)})
+}
+
+// Load reads the settings from the YAML of the config file. It is decoded
+// here rather than by viper, which lowercases every key, profile names too.
+// The settings are usable even with an error.
+func Load(data []byte) (*Settings, error) {
+	var all map[interface{}]interface{}
+	if err := yaml.Unmarshal(data, &all); err != nil {
+		s, _ := Parse(nil)
+		return s, err
+	}
+	m, _ := stringKeys(all).(map[string]interface{})
+	return Parse(m)
+}
+
+// stringKeys turns the maps decoded from YAML into maps with string keys, as
+// Parse takes them
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = stringKeys(item)
+		}
+		return list
+	}
+	return value
+}
+
+// Parse reads the settings from a map as decoded from YAML. Every problem is reported, not just the first one, in an *Error.
+func Parse(all map[string]interface{}) (*Settings, error) {
+	ps := &parser{}
+	s := &Settings{BackupsKeep: DefaultBackupsKeep, clouds: make(map[string]cloudSettings)}
+	aws := cloudSettings{profiles: make(map[string]overrides)}
+	for _, key := range sortedKeys(all) {
+		value := all[key]
+		if ps.section(&aws, AWS, "", key, value) {
+			continue
+		}
+		switch key {
+		case "clouds":
+			clouds, ok := ps.table(key, value)
+			if !ok {
+				continue
+			}
+			for _, name := range sortedKeys(clouds) {
+				path := key + "." + name
+				if name == AWS {
+					ps.problem(path, "AWS takes the top-level defaults and profiles")
+					continue
+				}
+				settings, ok := ps.table(path, clouds[name])
+				if !ok {
+					continue
+				}
+				c := cloudSettings{profiles: make(map[string]overrides)}
+				for _, k := range sortedKeys(settings) {
+					if !ps.section(&c, name, path+".", k, settings[k]) {
+						ps.problem(path+"."+k, "unknown key, must be 'defaults' or 'profiles'")
+					}
+				}
+				s.clouds[name] = c
+			}
+		case "backups":
+			backups, ok := ps.table(key, value)
+			if !ok {
+				continue
+			}
+			for _, k := range sortedKeys(backups) {
+				switch k {
+				case "keep":
+					if n, ok := backups[k].(int); ok && n >= 0 {
+						s.BackupsKeep = n
+					} else {
+						ps.problem(key+"."+k, "must be a whole number of at least 0, not %v", backups[k])
+					}
+				default:
+					ps.problem(key+"."+k, "unknown key")
+				}
+			}
+		default:
+			ps.problem(key, "unknown key")
+		}
+	}
+	s.clouds[AWS] = aws
+	if len(ps.problems) > 0 {
+		return s, &Error{Problems: ps.problems}
+	}
+	return s, nil
+}
+
+// section parses the defaults or the profiles of a cloud, found at prefix.
+// It reports whether key is one of them.
+func (ps *parser) section(c *cloudSettings, cloud, prefix, key string, value interface{}) bool {
+	switch key {
+	case "defaults":
+		c.defaults = ps.overrides(prefix+key, cloud, value)
+	case "profiles":
+		profiles, ok := ps.table(prefix+key, value)
+		if !ok {
+			break
+		}
+		for _, name := range sortedKeys(profiles) {
+			c.profiles[name] = ps.overrides(prefix+key+"."+name, cloud, profiles[name])
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// overrides parses the settings of the defaults or a profile of a cloud
+func (ps *parser) overrides(path, cloud string, value interface{}) overrides {
+	var o overrides
+	m, ok := ps.table(path, value)
+	if !ok {
+		return o
+	}
+	for _, k := range sortedKeys(m) {
+		key, v := path+"."+k, m[k]
+		if awsOnly[k] && cloud != AWS {
+			ps.problem(key, "only used with AWS")
+			continue
+		}
+		switch k {
+		case "max_key_age":
+			o.maxKeyAge = ps.duration(key, v)
+		case "grace_period":
+			o.gracePeriod = ps.duration(key, v)
+		case "require_mfa":
+			o.requireMFA = ps.bool(key, v)
+		case "exclude_from_bulk":
+			o.excludeFromBulk = ps.bool(key, v)
+		case "account_id":
+			o.accountID = ps.accountID(key, v)
+		case "hooks":
+			hooks, ok := ps.table(key, v)
+			if !ok {
+				continue
+			}
+			for _, h := range sortedKeys(hooks) {
+				switch h {
+				case "pre":
+					o.pre, o.hasPre = ps.commands(key+"."+h, hooks[h]), true
+				case "post":
+					o.post, o.hasPost = ps.commands(key+"."+h, hooks[h]), true
+				default:
+					ps.problem(key+"."+h, "unknown key, must be 'pre' or 'post'")
+				}
+			}
+		default:
+			ps.problem(key, "unknown key")
+		}
+	}
+	return o
+}
+
+func (ps *parser) table(key string, value interface{}) (map[string]interface{}, bool) {
+	if value == nil {
+		return nil, false
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		ps.problem(key, "must be a map of settings")
+	}
+	return m, ok
+}
+
+func (ps *parser) duration(key string, value interface{}) *time.Duration {
+	s, ok := value.(string)
+	if !ok {
+		ps.problem(key, "must be a duration like 90d or 12h, not %v", value)
+		return nil
+	}
+	d, err := ParseDuration(s)
+	if err != nil {
+		ps.problem(key, "must be a duration like 90d or 12h, not %q", s)
+		return nil
+	}
+	return &d
+}
+
+func (ps *parser) bool(key string, value interface{}) *bool {
+	b, ok := value.(bool)
+	if !ok {
+		ps.problem(key, "must be true or false, not %v", value)
+		return nil
+	}
+	return &b
+}
+
+func (ps *parser) accountID(key string, value interface{}) *string {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case int:
+		// An unquoted account ID loses its leading zeros
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if len(s) != 12 || strings.Trim(s, "0123456789") != "" {
+		if s == "" {
+			s = fmt.Sprint(value)
+		}
+		ps.problem(key, "must be a 12-digit account ID in quotes, not %s", s)
+		return nil
+	}
+	return &s
+}
+
+func (ps *parser) commands(key string, value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return []string{}
+	case string:
+		return []string{v}
+	case []interface{}:
+		commands := make([]string, 0, len(v))
+		for i, c := range v {
+			s, ok := c.(string)
+			if !ok || s == "" {
+				ps.problem(fmt.Sprintf("%s[%d]", key, i), "must be a command, not %v", c)
+				continue
+			}
+			commands = append(commands, s)
+		}
+		return commands
+	}
+	ps.problem(key, "must be a command or a list of commands")
+	return nil
+}
+
+// ParseDuration parses a duration like time.ParseDuration, and also accepts
+// whole days and weeks such as "90d" or "2w"
+func ParseDuration(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,254 @@
+package settings
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	day := 24 * time.Hour
+
+	t.Run("no settings", func(t *testing.T) {
+		s, err := Parse(map[string]interface{}{})
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if s.BackupsKeep != DefaultBackupsKeep {
+			t.Errorf("got %d backups kept but want %d", s.BackupsKeep, DefaultBackupsKeep)
+		}
+		if got := s.Policy(AWS, "default"); !reflect.DeepEqual(got, Policy{}) {
+			t.Errorf("got %+v but want the zero policy", got)
+		}
+	})
+	t.Run("profile overrides defaults", func(t *testing.T) {
+		s, err := Parse(map[string]interface{}{
+			"defaults": map[string]interface{}{
+				"max_key_age":  "90d",
+				"grace_period": "7d",
+				"hooks":        map[string]interface{}{"post": "./notify.sh"},
+			},
+			"backups": map[string]interface{}{"keep": 3},
+			"profiles": map[string]interface{}{
+				"Lab": map[string]interface{}{
+					"max_key_age":       "2w",
+					"require_mfa":       true,
+					"account_id":        "012345678901",
+					"exclude_from_bulk": true,
+					"hooks": map[string]interface{}{
+						"pre":  []interface{}{"./stop.sh", "./drain.sh"},
+						"post": nil,
+					},
+				},
+			},
+		})
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if s.BackupsKeep != 3 {
+			t.Errorf("got %d backups kept but want 3", s.BackupsKeep)
+		}
+		wantDefault := Policy{MaxKeyAge: 90 * day, GracePeriod: 7 * day, Hooks: Hooks{Post: []string{"./notify.sh"}}}
+		if got := s.Policy(AWS, "other"); !reflect.DeepEqual(got, wantDefault) {
+			t.Errorf("got %+v but want %+v", got, wantDefault)
+		}
+		wantLab := Policy{
+			MaxKeyAge:       14 * day,
+			GracePeriod:     7 * day,
+			RequireMFA:      true,
+			AccountID:       "012345678901",
+			ExcludeFromBulk: true,
+			Hooks:           Hooks{Pre: []string{"./stop.sh", "./drain.sh"}, Post: []string{}},
+		}
+		if got := s.Policy(AWS, "Lab"); !reflect.DeepEqual(got, wantLab) {
+			t.Errorf("got %+v but want %+v", got, wantLab)
+		}
+		// Profile names are case sensitive
+		if got := s.Policy(AWS, "lab"); !reflect.DeepEqual(got, wantDefault) {
+			t.Errorf("got %+v but want %+v", got, wantDefault)
+		}
+	})
+	t.Run("unquoted account ID", func(t *testing.T) {
+		s, err := Parse(map[string]interface{}{
+			"profiles": map[string]interface{}{
+				"lab": map[string]interface{}{"account_id": 123456789012},
+			},
+		})
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if got := s.Policy(AWS, "lab").AccountID; got != "123456789012" {
+			t.Errorf("got account ID %q but want 123456789012", got)
+		}
+	})
+	t.Run("settings only apply to their cloud", func(t *testing.T) {
+		s, err := Parse(map[string]interface{}{
+			"defaults": map[string]interface{}{"max_key_age": "90d"},
+			"profiles": map[string]interface{}{
+				"default": map[string]interface{}{
+					"hooks": map[string]interface{}{"post": "./update-jenkins.sh"},
+				},
+			},
+			"clouds": map[string]interface{}{
+				"gcp": map[string]interface{}{
+					"profiles": map[string]interface{}{
+						"ci": map[string]interface{}{"exclude_from_bulk": true},
+					},
+				},
+			},
+		})
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		wantAWS := Policy{MaxKeyAge: 90 * day, Hooks: Hooks{Post: []string{"./update-jenkins.sh"}}}
+		if got := s.Policy(AWS, "default"); !reflect.DeepEqual(got, wantAWS) {
+			t.Errorf("got %+v but want %+v", got, wantAWS)
+		}
+		if got := s.Policy("gcp", "default"); !reflect.DeepEqual(got, Policy{}) {
+			t.Errorf("got %+v for gcp but want the zero policy", got)
+		}
+		if got := s.Policy("gcp", "ci"); !reflect.DeepEqual(got, Policy{ExcludeFromBulk: true}) {
+			t.Errorf("got %+v but want only excluded from bulk", got)
+		}
+		if got := s.Policy("azure", "ci"); !reflect.DeepEqual(got, Policy{}) {
+			t.Errorf("got %+v for azure but want the zero policy", got)
+		}
+		if got := s.Clouds(); !reflect.DeepEqual(got, []string{"gcp"}) {
+			t.Errorf("got clouds %v but want [gcp]", got)
+		}
+	})
+	t.Run("report every problem", func(t *testing.T) {
+		_, err := Parse(map[string]interface{}{
+			"default": map[string]interface{}{},
+			"backups": map[string]interface{}{"keep": -1},
+			"clouds": map[string]interface{}{
+				"aws": map[string]interface{}{},
+				"gcp": map[string]interface{}{
+					"defaults": map[string]interface{}{"grace_period": "7d"},
+					"profile":  map[string]interface{}{},
+				},
+			},
+			"defaults": map[string]interface{}{
+				"max_key_age":  "soon",
+				"grace_period": 7,
+				"require_mfa":  "yes",
+			},
+			"profiles": map[string]interface{}{
+				"lab": map[string]interface{}{
+					"account_id": 1.2345678901e+10,
+					"hooks":      map[string]interface{}{"during": "./x.sh", "pre": []interface{}{1}},
+					"region":     "us-east-1",
+				},
+				"prod": "yes",
+			},
+		})
+
+		e, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("got %v but want a settings error", err)
+		}
+		var got []string
+		for _, p := range e.Problems {
+			got = append(got, p.Key)
+		}
+		want := []string{
+			"backups.keep",
+			"clouds.aws",
+			"clouds.gcp.defaults.grace_period",
+			"clouds.gcp.profile",
+			"default",
+			"defaults.grace_period",
+			"defaults.max_key_age",
+			"defaults.require_mfa",
+			"profiles.lab.account_id",
+			"profiles.lab.hooks.during",
+			"profiles.lab.hooks.pre[0]",
+			"profiles.lab.region",
+			"profiles.prod",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got problems with %v but want %v", got, want)
+		}
+	})
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("profile names kept as written", func(t *testing.T) {
+		s, err := Load([]byte(`
+backups:
+  keep: 3
+profiles:
+  Prod:
+    max_key_age: 30d
+    hooks:
+      post: [./notify.sh]
+  prod:
+    require_mfa: true
+  team.admin:
+    account_id: "012345678901"
+`))
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if s.BackupsKeep != 3 {
+			t.Errorf("got %d backups kept but want 3", s.BackupsKeep)
+		}
+		wantProd := Policy{MaxKeyAge: 30 * 24 * time.Hour, Hooks: Hooks{Post: []string{"./notify.sh"}}}
+		if got := s.Policy(AWS, "Prod"); !reflect.DeepEqual(got, wantProd) {
+			t.Errorf("got %+v but want %+v", got, wantProd)
+		}
+		if got := s.Policy(AWS, "prod"); !reflect.DeepEqual(got, Policy{RequireMFA: true}) {
+			t.Errorf("got %+v but want only MFA required", got)
+		}
+		if got := s.Policy(AWS, "team.admin").AccountID; got != "012345678901" {
+			t.Errorf("got account ID %q but want 012345678901", got)
+		}
+	})
+	t.Run("no config file", func(t *testing.T) {
+		s, err := Load(nil)
+
+		if err != nil {
+			t.Fatalf("got error %q but didn't want one", err)
+		}
+		if s.BackupsKeep != DefaultBackupsKeep {
+			t.Errorf("got %d backups kept but want %d", s.BackupsKeep, DefaultBackupsKeep)
+		}
+	})
+	t.Run("bad YAML", func(t *testing.T) {
+		s, err := Load([]byte("profiles: [\n"))
+
+		if err == nil {
+			t.Error("got no error but want one")
+		}
+		if s == nil || s.BackupsKeep != DefaultBackupsKeep {
+			t.Errorf("got %+v but want the default settings", s)
+		}
+	})
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "90d", want: 90 * 24 * time.Hour},
+		{in: "2w", want: 14 * 24 * time.Hour},
+		{in: "36h", want: 36 * time.Hour},
+		{in: "0", want: 0},
+		{in: "-1d", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDuration(%q) got %v, %v", tt.in, got, err)
+		}
+	}
+}
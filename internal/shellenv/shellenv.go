@@ -0,0 +1,91 @@
+// Package shellenv formats environment variables as statements that a shell
+// can evaluate, such as with eval "$(cloudkey rotate)".
+package shellenv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Shells supported by Format
+const (
+	Bash       = "bash"
+	Zsh        = "zsh"
+	Fish       = "fish"
+	PowerShell = "powershell"
+)
+
+// Var is an environment variable
+type Var struct {
+	Name  string
+	Value string
+}
+
+// Parse checks the name of a shell. Common aliases like sh and pwsh are accepted.
+func Parse(shell string) (string, error) {
+	switch strings.ToLower(shell) {
+	case Bash, "sh", "ksh", "dash":
+		return Bash, nil
+	case Zsh:
+		return Zsh, nil
+	case Fish:
+		return Fish, nil
+	case PowerShell, "pwsh", "powershell.exe", "pwsh.exe":
+		return PowerShell, nil
+	}
+	return "", fmt.Errorf("Unknown shell %q, must be one of bash, zsh, fish or powershell", shell)
+}
+
+// Detect guesses the user's shell from the environment, falling back to bash
+func Detect() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		if s, err := Parse(filepath.Base(shell)); err == nil {
+			return s
+		}
+	}
+	// PowerShell sets PSModulePath but not SHELL
+	if os.Getenv("PSModulePath") != "" {
+		return PowerShell
+	}
+	return Bash
+}
+
+// Format formats the variables as statements that set them in the shell, one
+// per line
+func Format(shell string, vars []Var) (string, error) {
+	shell, err := Parse(shell)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, v := range vars {
+		switch shell {
+		case Bash, Zsh:
+			fmt.Fprintf(&b, "export %s=%s\n", v.Name, quotePOSIX(v.Value))
+		case Fish:
+			fmt.Fprintf(&b, "set -gx %s %s;\n", v.Name, quoteFish(v.Value))
+		case PowerShell:
+			fmt.Fprintf(&b, "$Env:%s = %s\n", v.Name, quotePowerShell(v.Value))
+		}
+	}
+	return b.String(), nil
+}
+
+// quotePOSIX single-quotes s, closing and reopening the quotes around any
+// single quote in it
+func quotePOSIX(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// quoteFish single-quotes s, where fish allows escaping quotes and backslashes
+func quoteFish(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return "'" + strings.Replace(s, "'", `\'`, -1) + "'"
+}
+
+// quotePowerShell single-quotes s, doubling any single quote in it
+func quotePowerShell(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
@@ -0,0 +1,62 @@
+package shellenv
+
+import (
+	"os"
+	"testing"
+)
+
+var vars = []Var{
+	{Name: "AWS_ACCESS_KEY_ID", Value: "AKIAI44QH8DHBEXAMPLE"},
+	{Name: "AWS_SECRET_ACCESS_KEY", Value: `je7MtGbClwBF/2Zp9Utk/h3yCo8n'\EXAMPLEKEY`},
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{"bash", "export AWS_ACCESS_KEY_ID='AKIAI44QH8DHBEXAMPLE'\nexport AWS_SECRET_ACCESS_KEY='je7MtGbClwBF/2Zp9Utk/h3yCo8n'\\''\\EXAMPLEKEY'\n"},
+		{"zsh", "export AWS_ACCESS_KEY_ID='AKIAI44QH8DHBEXAMPLE'\nexport AWS_SECRET_ACCESS_KEY='je7MtGbClwBF/2Zp9Utk/h3yCo8n'\\''\\EXAMPLEKEY'\n"},
+		{"fish", "set -gx AWS_ACCESS_KEY_ID 'AKIAI44QH8DHBEXAMPLE';\nset -gx AWS_SECRET_ACCESS_KEY 'je7MtGbClwBF/2Zp9Utk/h3yCo8n\\'\\\\EXAMPLEKEY';\n"},
+		{"pwsh", "$Env:AWS_ACCESS_KEY_ID = 'AKIAI44QH8DHBEXAMPLE'\n$Env:AWS_SECRET_ACCESS_KEY = 'je7MtGbClwBF/2Zp9Utk/h3yCo8n''\\EXAMPLEKEY'\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			got, err := Format(tt.shell, vars)
+
+			if err != nil {
+				t.Fatalf("got error %q but didn't want one", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q but want %q", got, tt.want)
+			}
+		})
+	}
+	t.Run("fail on unknown shell", func(t *testing.T) {
+		_, err := Format("cmd.exe", vars)
+
+		if err == nil {
+			t.Error("wanted an error but didn't get one")
+		}
+	})
+}
+
+func TestDetect(t *testing.T) {
+	defer os.Setenv("SHELL", os.Getenv("SHELL"))
+
+	t.Run("from SHELL", func(t *testing.T) {
+		os.Setenv("SHELL", "/usr/local/bin/fish")
+
+		if got := Detect(); got != Fish {
+			t.Errorf("got %q but want %q", got, Fish)
+		}
+	})
+	t.Run("unknown SHELL falls back to bash", func(t *testing.T) {
+		os.Setenv("SHELL", "/bin/tcsh")
+		os.Unsetenv("PSModulePath")
+
+		if got := Detect(); got != Bash {
+			t.Errorf("got %q but want %q", got, Bash)
+		}
+	})
+}